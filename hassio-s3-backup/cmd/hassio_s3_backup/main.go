@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"hassio-proton-drive-backup/internal/backup"
 	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/filesystem"
+	"hassio-proton-drive-backup/internal/metrics"
 	"hassio-proton-drive-backup/internal/s3"
 	"hassio-proton-drive-backup/webui"
 	"log/slog"
@@ -17,8 +20,18 @@ import (
 
 func main() {
 	// Initalize config
-	cs := config.NewConfigService()
-	c := cs.Config
+	cs, err := config.NewConfigService()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	c := cs.Config()
+
+	// Webhook templates are parsed by the backup service, an invalid one fails startup like the rest of the config
+	if err := backup.ValidateWebhooks(c.Webhooks); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
 
 	// Set LogLevel
 	opts := &slog.HandlerOptions{
@@ -28,15 +41,34 @@ func main() {
 	handler := slog.NewTextHandler(os.Stdout, opts)
 	slog.SetDefault(slog.New(handler))
 
-	// Initalize S3
-	s3, err := s3.NewClient(cs)
-	if err != nil {
-		slog.Error("failed to initialize S3 client", "error", err)
-		os.Exit(1)
+	// Initialize storage for every destination
+	storages := make(map[string]backup.Storage)
+	for _, d := range c.Destinations {
+		storage, err := newStorage(d, c.Upload)
+		if err != nil {
+			slog.Error("failed to initialize storage", "destination", d.Name, "error", err)
+			os.Exit(1)
+		}
+		storages[d.Name] = storage
+	}
+
+	// Initialize read-only storage for the other instances to view
+	instances := make(map[string]map[string]backup.ViewStorage)
+	for _, prefix := range c.ViewPrefixes {
+		instances[prefix] = make(map[string]backup.ViewStorage)
+		for _, d := range c.Destinations {
+			d.Prefix = prefix
+			storage, err := newViewStorage(d)
+			if err != nil {
+				slog.Warn("failed to initialize storage of other instance", "destination", d.Name, "prefix", prefix, "error", err)
+				continue
+			}
+			instances[prefix][d.Name] = storage
+		}
 	}
 
 	// Initialize the backup service
-	bs := backup.NewService(s3, cs)
+	bs := backup.NewService(storages, instances, cs, backup.NewScheduler(backup.SystemClock))
 
 	// Initialize mux and register routes
 	mux := http.NewServeMux()
@@ -52,6 +84,8 @@ func main() {
 		Addr:    ":8099",
 		Handler: mux,
 	}
+	// Event streams stay open until they're closed, so they'd hold up the shutdown otherwise
+	server.RegisterOnShutdown(bs.CloseSubscriptions)
 
 	go func() {
 		slog.Info("starting HTTP server", "address", server.Addr)
@@ -62,22 +96,26 @@ func main() {
 		slog.Info("stopped serving new connections.")
 	}()
 
-	// TODO: Decide if the external server is needed
-	// Could be used for metrics or direct access to the UI
-	/* 	// Define server
-	   	extServer := http.Server{
-	   		Addr:    ":9101",
-	   		Handler: proxy.Router,
-	   	}
-	   	// Start http server
-	   	go func() {
-	   		slog.Info("Starting http server on port 9101")
-	   		if err := extServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-	   			slog.Error("HTTP server error", "error", err)
-	   			os.Exit(1)
-	   		}
-	   		slog.Info("Stopped serving new connections.")
-	   	}() */
+	// Serve metrics on their own port, outside of ingress, for Prometheus to scrape.
+	// The port is the one exposed in config.yaml, the Supervisor maps it to the host port set by the user.
+	var metricsServer *http.Server
+	if c.Metrics {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("GET /metrics", metrics.DefaultRegistry.Handler())
+
+		metricsServer = &http.Server{
+			Addr:    ":9101",
+			Handler: metricsMux,
+		}
+
+		go func() {
+			slog.Info("starting metrics server", "address", metricsServer.Addr)
+			if err := metricsServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("metrics server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	// Graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -93,5 +131,41 @@ func main() {
 		os.Exit(1)
 	}
 
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("metrics shutdown error", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := bs.Stop(shutdownCtx); err != nil {
+		slog.Error("backup service shutdown error", "error", err)
+		os.Exit(1)
+	}
+
 	slog.Info("graceful shutdown complete")
 }
+
+// newStorage creates the storage client for a destination
+func newStorage(d config.DestinationOptions, upload config.UploadOptions) (backup.Storage, error) {
+	switch d.Type {
+	case config.DestinationLocal:
+		return filesystem.NewClient(d)
+	case config.DestinationS3:
+		return s3.NewClient(d, upload)
+	}
+
+	return nil, fmt.Errorf("unknown destination type %q", d.Type)
+}
+
+// newViewStorage creates a storage client for a destination that only reads from it
+func newViewStorage(d config.DestinationOptions) (backup.ViewStorage, error) {
+	switch d.Type {
+	case config.DestinationLocal:
+		return filesystem.NewViewClient(d)
+	case config.DestinationS3:
+		return s3.NewViewClient(d)
+	}
+
+	return nil, fmt.Errorf("unknown destination type %q", d.Type)
+}
@@ -2,7 +2,10 @@ module hassio-proton-drive-backup
 
 go 1.23.1
 
-require github.com/minio/minio-go/v7 v7.0.76
+require (
+	github.com/minio/minio-go/v7 v7.0.76
+	golang.org/x/crypto v0.27.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
@@ -15,7 +18,6 @@ require (
 	github.com/minio/md5-simd v1.1.2 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
 	github.com/rs/xid v1.6.0 // indirect
-	golang.org/x/crypto v0.27.0 // indirect
 	golang.org/x/net v0.29.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
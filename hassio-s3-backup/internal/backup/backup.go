@@ -3,22 +3,23 @@ package backup
 import (
 	"context"
 	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/cron"
+	"hassio-proton-drive-backup/internal/crypt"
 	"hassio-proton-drive-backup/internal/hassio"
 	"hassio-proton-drive-backup/internal/s3"
+	"hassio-proton-drive-backup/internal/throttle"
+	"io"
 	"log/slog"
 	"os"
-	"sort"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/minio/minio-go/v7"
 )
 
 // status is a custom type to represent the status of the backup
@@ -28,24 +29,45 @@ const (
 	StatusDeleting    status = "DELETING"    // Backup is being deleted
 	StatusPending     status = "PENDING"     // Backup is initialized but no action taken
 	StatusRunning     status = "RUNNING"     // Backup is being created in Home Assistant
-	StatusSynced      status = "SYNCED"      // Backup is present in both Home Assistant and S3
+	StatusSynced      status = "SYNCED"      // Backup is present in Home Assistant and all required destinations
+	StatusIncomplete  status = "INCOMPLETE"  // Backup is present in Home Assistant but missing from some required destinations
 	StatusHAOnly      status = "HAONLY"      // Backup is only present in Home Assistant
-	StatusS3Only      status = "S3ONLY"      // Backup is only present in S3
+	StatusS3Only      status = "S3ONLY"      // Backup is only present in remote destinations
 	StatusSyncing     status = "SYNCING"     // Backup is being uploaded to S3
 	StatusDownloading status = "DOWNLOADING" // Backup is being downloaded from S3
+	StatusVerifying   status = "VERIFYING"   // Backup is being downloaded from remote destinations to verify its checksum
+	StatusCorrupt     status = "CORRUPT"     // Backup in a remote destination doesn't match its checksum
+	StatusRetrying    status = "RETRYING"    // Backup process failed and will be retried
 	StatusFailed      status = "FAILED"      // Backup process failed somewhere
 )
 
 // Backup represents the details and status of a backup process
 type Backup struct {
-	Date         time.Time      `json:"date"`
-	S3           *s3.Object     `json:"s3"`
-	HA           *hassio.Backup `json:"ha"`
-	ID           string         `json:"id"`
-	Name         string         `json:"name"`
-	Status       status         `json:"status"`
-	ErrorMessage string         `json:"errorMessage"`
-	Pinned       bool           `json:"pinned"`
+	Date         time.Time             `json:"date"`
+	Remotes      map[string]*s3.Object `json:"remotes"`
+	HA           *hassio.Backup        `json:"ha"`
+	ID           string                `json:"id"`
+	Name         string                `json:"name"`
+	Slug         string                `json:"slug,omitempty"` // Slug of the backup in Home Assistant
+	Profile      string                `json:"profile"`
+	Status       status                `json:"status"`
+	ErrorMessage string                `json:"errorMessage"`
+	Pinned       bool                  `json:"pinned"`
+	Manifest     *Manifest             `json:"manifest,omitempty"`   // Content of backup.json in the tarball
+	Checksum     string                `json:"checksum,omitempty"`   // SHA-256 of the tarball
+	VerifiedAt   *time.Time            `json:"verifiedAt,omitempty"` // Last time all remotes matched the checksum
+	Uploads      map[string]*Upload    `json:"uploads,omitempty"`    // Interrupted uploads by destination
+	Attempts     int                   `json:"attempts,omitempty"`   // Failed attempts since the last success
+	NextRetry    *time.Time            `json:"nextRetry,omitempty"`
+
+	// Creation in Home Assistant failed, so retries create the backup again instead of only uploading it
+	CreationFailed bool `json:"creationFailed,omitempty"`
+
+	// Progress of an ongoing upload or download
+	Progress         float64 `json:"progress,omitempty"`         // Percent
+	BytesTransferred int64   `json:"bytesTransferred,omitempty"` // Bytes
+	BytesTotal       int64   `json:"bytesTotal,omitempty"`       // Bytes
+	Throughput       float64 `json:"throughput,omitempty"`       // Bytes per second
 }
 
 // UpdateStatus updates the status of the backup
@@ -53,134 +75,274 @@ func (b *Backup) UpdateStatus(status status) {
 	b.Status = status
 }
 
+// supervisor is the part of the Supervisor API the service uses, implemented by hassio.Client
+type supervisor interface {
+	ListBackups(ctx context.Context) ([]*hassio.Backup, error)
+	BackupFull(ctx context.Context, request hassio.BackupRequest) (string, error)
+	BackupPartial(ctx context.Context, request hassio.PartialBackupRequest) (string, error)
+	UploadBackup(ctx context.Context, data io.Reader) error
+	DeleteBackup(ctx context.Context, slug string) error
+	RestoreBackup(ctx context.Context, slug string, password string) error
+	RestorePartialBackup(ctx context.Context, slug string, request hassio.PartialRestoreRequest) error
+	SetState(ctx context.Context, entityID string, state hassio.State) error
+	CreateNotification(ctx context.Context, id string, title string, message string) error
+	DismissNotification(ctx context.Context, id string) error
+	FireEvent(ctx context.Context, eventType string, data interface{}) error
+}
+
 // Service handles backup operations and synchronization
 type Service struct {
-	s3Client      *minio.Client
-	hassioClient  *hassio.Client
-	configService *config.Service
-	config        *config.Options
-	backups       []*Backup
-	mutex         sync.Mutex
-}
-
-var (
-	backupTimer            *time.Timer
-	syncTicker             *time.Ticker
-	syncInterval           time.Duration
-	stopBackupChan         chan struct{}
-	stopSyncChan           chan struct{}
-	nextBackupCalculatedAt time.Time
-	nextBackupIn           time.Duration
-	ongoingBackups         map[string]struct{}
-)
+	storages       map[string]Storage
+	instances      map[string]map[string]ViewStorage // Storages of other instances by key prefix and destination
+	instanceCache  instanceCache                     // Metadata of the backups of other instances
+	hassioClient   supervisor
+	configService  *config.Service
+	store          store  // Tracked backups, every read and change of a backup goes through the store
+	backupDir      string // Where Home Assistant keeps the tarballs of its backups
+	scheduler      *Scheduler
+	jobs           *jobQueue           // Operations run one at a time in the order they were requested
+	events         *eventBus           // Changes published to subscribers such as the event stream
+	entities       *entityPublisher    // State of the backups published as entities in Home Assistant
+	notifier       *notifier           // Outcome of backups reported to Home Assistant
+	webhooks       *webhookNotifier    // Events about backups posted to the configured webhooks
+	lastSync       time.Time           // When the last successful sync finished, guarded by the store
+	ongoing        operations          // Backups with an ongoing operation, which syncs leave alone
+	invalidObjects map[string]struct{} // Remote objects already found not to be backups, guarded by the store
+	mutex          sync.Mutex          // Guards the next scheduled backup
+
+	// The next scheduled backup
+	nextBackup        time.Time
+	nextBackupProfile string
+}
 
-func init() {
-	stopBackupChan = make(chan struct{})
-	stopSyncChan = make(chan struct{})
+// syncInterval is the time between two scheduled syncs
+const syncInterval = 1 * time.Hour
 
-	backupTimer = time.NewTimer(time.Hour)
-	backupTimer.Stop()
+// errBackupNotFound is returned when no tracked backup has the given ID
+var errBackupNotFound = errors.New("backup not found")
 
-	syncInterval = 1 * time.Hour
+// syncOperation is the ongoing operation of a sync, it can't be mistaken for a backup ID
+const syncOperation = "#sync"
 
-	ongoingBackups = make(map[string]struct{})
-}
+// NewService creates a new Service instance with a storage for each configured destination,
+// and the storages of other instances whose backups are only listed. Backups, syncs and retries
+// are scheduled by the scheduler and run by the job queue, stopping the service stops both.
+func NewService(storages map[string]Storage, instances map[string]map[string]ViewStorage, configService *config.Service, scheduler *Scheduler) *Service {
+	// Record the requests to the Supervisor
+	hassioClient := instrumentSupervisor(hassio.NewService(configService.Config().SupervisorToken))
+	events := newEventBus(scheduler.clock)
 
-// NewService creates a new Service instance
-func NewService(s3Client *minio.Client, configService *config.Service) *Service {
-	hassioClient := hassio.NewService(configService.Config.SupervisorToken)
+	// Record the requests to every destination
+	instrumented := make(map[string]Storage, len(storages))
+	for destination, storage := range storages {
+		instrumented[destination] = instrumentStorage(destination, storage)
+	}
+
+	// Notifications and webhooks are configured by the current config whenever a backup is reported
+	notifications := func() config.NotificationOptions {
+		return configService.Config().Notifications
+	}
+	webhooks := func() []config.WebhookOptions {
+		return configService.Config().Webhooks
+	}
 
 	service := &Service{
-		hassioClient:  hassioClient,
-		s3Client:      s3Client,
-		configService: configService,
-		config:        configService.Config,
+		hassioClient:   hassioClient,
+		storages:       instrumented,
+		instances:      instances,
+		store:          store{path: stateFile},
+		backupDir:      haBackupDir,
+		scheduler:      scheduler,
+		events:         events,
+		entities:       newEntityPublisher(hassioClient),
+		notifier:       newNotifier(hassioClient, notifications),
+		webhooks:       newWebhookNotifier(webhooks, scheduler.Done()),
+		jobs:           newJobQueue(scheduler.clock, events),
+		invalidObjects: make(map[string]struct{}),
+		configService:  configService,
 	}
 
+	service.registerMetrics()
+
+	// Publish entities and report backups in Home Assistant until the service is stopped
+	go service.entities.run(scheduler.Done())
+	go service.notifier.run(scheduler.Done())
+
 	// Initial load and sync of backups
+	service.store.Lock()
 	service.loadBackupsFromFile()
-	service.syncBackups()
+	service.syncBackups(context.Background())
+	service.resetTimerForNextBackup()
+	service.store.Unlock()
 
-	// Start scheduled backups and syncs
-	go service.startBackupScheduler()
-	go service.startBackupSyncScheduler()
+	// Start scheduled syncs and verifications
+	service.scheduleSync()
+	service.scheduleVerify()
 	go service.listenForConfigChanges(configService.ConfigChangeChan)
 
 	return service
 }
 
-// PerformBackup creates a new backup and uploads it to S3
-func (s *Service) PerformBackup(name string) error {
-	if len(ongoingBackups) > 0 {
-		err := errors.New("another backup is already in progress")
-		slog.Error(err.Error())
+// config returns the current config, read once per use since the API can replace it at any time
+func (s *Service) config() *config.Options {
+	return s.configService.Config()
+}
+
+// Stop stops scheduling backups and cancels queued and running jobs, waiting for them to return or the context to be done
+func (s *Service) Stop(ctx context.Context) error {
+	slog.Info("stopping backup scheduler")
+	if err := s.scheduler.Stop(ctx); err != nil {
 		return err
 	}
 
-	backup := s.initializeBackup(name)
+	return s.jobs.stop(ctx)
+}
+
+// PerformBackup creates a new backup using the given profile and uploads it to S3
+func (s *Service) PerformBackup(ctx context.Context, name string, profileName string) error {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	profile, exists := s.config().GetProfile(profileName)
+	if !exists {
+		return fmt.Errorf("profile \"%s\" doesn't exist", profileName)
+	}
+
+	backup := s.initializeBackup(name, profile)
+	s.jobs.setBackup(ctx, backup.ID)
+	defer s.updateEntities()
 
 	// Track ongoing backups to avoid syncing or any other manipulation in the meantime
-	ongoingBackups[backup.ID] = struct{}{}
-	defer delete(ongoingBackups, backup.ID)
+	if !s.ongoing.start(backup.ID, false) {
+		s.store.remove(backup.ID)
+		return fmt.Errorf("backup can't be created while a sync is running")
+	}
+	defer s.ongoing.finish(backup.ID)
 
-	backup.UpdateStatus(StatusRunning)
-	slug, err := s.hassioClient.BackupFull(backup.Name)
-	if err != nil {
-		backup.ErrorMessage = err.Error()
-		backup.UpdateStatus(StatusFailed)
+	s.setStatus(backup, StatusRunning)
 
-		err = fmt.Errorf("backup creation in home assistant failed: %v", err)
+	var slug string
+	var err error
+	s.store.unlocked(func() {
+		slug, err = s.createHABackup(ctx, backup, profile)
+	})
+	if errors.Is(err, context.Canceled) {
+		// Home Assistant may still create the backup, the next sync picks it up if it does
+		slog.Info("backup creation cancelled", "name", backup.Name)
+		s.store.remove(backup.ID)
+		return err
+	}
+	if err != nil {
+		err = fmt.Errorf("backup creation in home assistant failed: %w", err)
+		backup.CreationFailed = true
+		s.scheduleRetry(backup, err)
 		return err
 	}
 
-	slog.Debug("backup created in home assistant", "name", backup.Name, "slug", backup.HA.Slug)
 	backup.HA.Slug = slug
+	backup.Slug = slug
+	slog.Debug("backup created in home assistant", "name", backup.Name, "slug", slug)
+	s.webhooks.notify(webhookCreated, backup, "", "")
 
-	err = s.syncBackupToS3(backup)
+	err = s.syncBackupToS3(ctx, backup)
 	if err != nil {
 		return err
 	}
-	slog.Debug("backup uploaded to s3", "name", backup.Name)
+	slog.Debug("backup uploaded to destinations", "name", backup.Name)
 
-	backup.UpdateStatus(StatusSynced)
-	delete(ongoingBackups, backup.ID)
+	s.ongoing.finish(backup.ID)
 	slog.Info("backup successfully created and synced", "name", backup.Name)
 
-	if err := s.syncBackups(); err != nil {
+	if err := s.syncBackups(ctx); err != nil {
 		slog.Error("error syncing backups", "error", err)
 	}
 
 	return nil
 }
 
+// createHABackup requests a full or partial backup from Home Assistant depending on the profile
+func (s *Service) createHABackup(ctx context.Context, backup *Backup, profile config.Profile) (string, error) {
+	started := time.Now()
+	slug, err := s.requestHABackup(ctx, backup, profile)
+	if err == nil {
+		creationDuration.Observe(time.Since(started).Seconds())
+	}
+
+	return slug, err
+}
+
+// requestHABackup requests the backup from Home Assistant and waits for it to be created
+func (s *Service) requestHABackup(ctx context.Context, backup *Backup, profile config.Profile) (string, error) {
+	settings := s.config().BackupSettings
+	request := hassio.BackupRequest{
+		Name:                         backup.Name,
+		Password:                     settings.Password,
+		Compressed:                   settings.Compressed,
+		HomeassistantExcludeDatabase: settings.ExcludeDatabase,
+	}
+
+	if !profile.Partial {
+		return s.hassioClient.BackupFull(ctx, request)
+	}
+
+	return s.hassioClient.BackupPartial(ctx, hassio.PartialBackupRequest{
+		BackupRequest: request,
+		HomeAssistant: profile.HomeAssistant,
+		Addons:        profile.Addons,
+		Folders:       profile.Folders,
+	})
+}
+
 // DeleteBackup deletes a backup from all sources
-func (s *Service) DeleteBackup(id string) error {
-	index, backup := s.getBackupByID(id)
+func (s *Service) DeleteBackup(ctx context.Context, id string) error {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	_, backup := s.store.get(id)
+	if backup == nil {
+		return errBackupNotFound
+	}
+
+	if !s.ongoing.start(backup.ID, false) {
+		return fmt.Errorf("backup is busy")
+	}
+	defer s.ongoing.finish(backup.ID)
 
 	// Delete backup from Home Assistant
-	backup.UpdateStatus(StatusDeleting)
+	s.setStatus(backup, StatusDeleting)
 
-	if backup.HA != nil && *backup.HA != (hassio.Backup{}) {
+	if backup.HA != nil && backup.HA.Slug != "" {
 		slog.Debug("deleting backup from home assistant", "name", backup.Name)
-		err := s.hassioClient.DeleteBackup(backup.HA.Slug)
+
+		var err error
+		slug := backup.HA.Slug
+		s.store.unlocked(func() {
+			err = s.hassioClient.DeleteBackup(ctx, slug)
+		})
 		if err != nil {
 			slog.Error("failed to delete backup in home assistant", "name", backup.Name, "error", err)
 			return err
 		}
 	}
 
-	// Delete backup from S3
-	if backup.S3 != nil && *backup.S3 != (s3.Object{}) {
-		slog.Debug("deleting backup from s3", "backup", backup)
-		err := s.s3Client.RemoveObject(context.Background(), s.config.S3.Bucket, backup.S3.Key, minio.RemoveObjectOptions{})
+	// Delete backup from every destination
+	for name, object := range backup.Remotes {
+		slog.Debug("deleting backup from destination", "name", backup.Name, "destination", name)
+
+		var err error
+		key := object.Key
+		s.store.unlocked(func() {
+			err = s.storages[name].Delete(ctx, key)
+		})
 		if err != nil {
-			slog.Error("failed to delete backup in s3", "name", backup.Name, "error", err)
+			slog.Error("failed to delete backup in destination", "name", backup.Name, "destination", name, "error", err)
 			return err
 		}
+		delete(backup.Remotes, name)
 	}
 
 	// Remove backup from local list
-	s.backups = append(s.backups[:index], s.backups[index+1:]...)
+	s.store.remove(backup.ID)
 
 	// Save the updated backup state to file
 	if err := s.saveBackupsToFile(); err != nil {
@@ -195,50 +357,168 @@ func (s *Service) DeleteBackup(id string) error {
 	return nil
 }
 
-// RestoreBackup calls Home Assistant to restore a backup
+// RestoreBackup calls Home Assistant to restore a backup, protected backups use the given password or the configured one.
+// The password may have changed since the backup was created, so the password it was created with can be given.
 // Note: might not be needed, as the restore can be done from the Home Assistant UI
-func (s *Service) RestoreBackup(id string) error {
-	_, backup := s.getBackupByID(id)
-	err := s.hassioClient.RestoreBackup(backup.HA.Slug)
+func (s *Service) RestoreBackup(ctx context.Context, id string, password string) error {
+	s.store.RLock()
+	_, backup := s.store.get(id)
+	if backup == nil || backup.HA == nil || backup.HA.Slug == "" {
+		s.store.RUnlock()
+		return fmt.Errorf("backup isn't in home assistant")
+	}
+	name, slug := backup.Name, backup.HA.Slug
+	partial, content := backup.HA.Type == "partial", backup.HA.Content
+	s.store.RUnlock()
+
+	if password == "" {
+		password = s.config().BackupSettings.Password
+	}
+
+	// Partial backups can only be restored partially, so everything they contain is selected
+	var err error
+	if partial {
+		err = s.hassioClient.RestorePartialBackup(ctx, slug, hassio.PartialRestoreRequest{
+			RestoreRequest: hassio.RestoreRequest{Password: password},
+			HomeAssistant:  content.HomeAssistant,
+			Addons:         content.Addons,
+			Folders:        content.Folders,
+		})
+	} else {
+		err = s.hassioClient.RestoreBackup(ctx, slug, password)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to restore backup in home assistant: %v", err)
 	}
 
-	slog.Info("restored to backup", "name", backup.Name)
+	slog.Info("restored to backup", "name", name)
 	return nil
 }
 
 // DownloadBackup downloads a backup from S3 to Home Assistant
-func (s *Service) DownloadBackup(id string) error {
-	_, backup := s.getBackupByID(id)
+func (s *Service) DownloadBackup(ctx context.Context, id string) error {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	_, backup := s.store.get(id)
+	if backup == nil {
+		return errBackupNotFound
+	}
+
+	if !s.ongoing.start(backup.ID, false) {
+		return fmt.Errorf("backup is busy")
+	}
+	defer s.ongoing.finish(backup.ID)
 
 	slog.Debug("downloading backup to home assistant", "name", backup.Name)
-	backup.UpdateStatus(StatusDownloading)
+	s.setStatus(backup, StatusDownloading)
+
+	var err error
+	s.store.unlocked(func() {
+		err = s.downloadBackup(ctx, backup)
+	})
+	clearProgress(backup)
+	if err != nil {
+		s.setStatus(backup, StatusS3Only)
+		return err
+	}
 
-	object, err := s.s3Client.GetObject(context.Background(), s.config.S3.Bucket, backup.S3.Key, minio.GetObjectOptions{})
+	slog.Info("backup downloaded", "name", backup.Name)
+	s.ongoing.finish(backup.ID)
+	s.syncBackups(ctx)
+
+	return nil
+}
+
+// UploadBackup uploads a backup in Home Assistant to every destination that doesn't have it yet.
+// Backups that failed or were cancelled are uploaded again.
+func (s *Service) UploadBackup(ctx context.Context, id string) error {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	_, backup := s.store.get(id)
+	if backup == nil {
+		return errBackupNotFound
+	}
+
+	if backup.HA == nil || backup.HA.Slug == "" {
+		return fmt.Errorf("backup isn't in home assistant")
+	}
+
+	if !s.ongoing.start(backup.ID, false) {
+		return fmt.Errorf("backup is busy")
+	}
+	defer s.ongoing.finish(backup.ID)
+
+	slog.Info("uploading backup to destinations", "name", backup.Name)
+	if err := s.syncBackupToS3(ctx, backup); err != nil {
+		return err
+	}
+
+	return s.saveBackupsToFile()
+}
+
+// downloadBackup streams a backup from the first destination in configuration order that has it to Home Assistant.
+// It runs without holding the lock of the store.
+func (s *Service) downloadBackup(ctx context.Context, backup *Backup) error {
+	var object io.ReadCloser
+	var remote *s3.Object
+	err := fmt.Errorf("backup not found in any destination")
+	for _, d := range s.config().Destinations {
+		var exists bool
+		remote, exists = backup.Remotes[d.Name]
+		if !exists {
+			continue
+		}
+
+		object, err = s.storages[d.Name].Get(ctx, remote.Key)
+		if err == nil {
+			break
+		}
+		slog.Warn("failed to get backup from destination", "name", backup.Name, "destination", d.Name, "error", err)
+	}
 	if err != nil {
-		slog.Error("failed to get backup from s3", "name", backup.Name, "error", err)
-		backup.UpdateStatus(StatusS3Only)
+		slog.Error("failed to get backup from remote", "name", backup.Name, "error", err)
 		return err
 	}
 	defer object.Close()
 
-	err = s.hassioClient.UploadBackup(object)
+	var reader io.Reader = throttle.NewReader(ctx, object, s.config().RestoreLimit())
+	reader = newProgressReader(reader, &s.store, s.events, backup, 0, int64(remote.Size*1024*1024))
+	if strings.HasSuffix(remote.Key, crypt.Suffix) {
+		slog.Debug("decrypting backup", "name", backup.Name)
+
+		secret, err := s.config().Encryption.Secret()
+		if err != nil {
+			slog.Error("backup is encrypted but no key is available", "name", backup.Name, "error", err)
+			return err
+		}
+
+		reader, err = crypt.NewReader(reader, secret)
+		if err != nil {
+			slog.Error("failed to decrypt backup", "name", backup.Name, "error", err)
+			return err
+		}
+	}
+
+	err = s.hassioClient.UploadBackup(ctx, reader)
 	if err != nil {
 		slog.Error("failed to upload backup to home assistant", "name", backup.Name, "error", err)
-		backup.UpdateStatus(StatusS3Only)
 		return err
 	}
 
-	slog.Info("backup downloaded", "name", backup.Name)
-	s.syncBackups()
-
 	return nil
 }
 
 // PinBackup pins a backup to prevent it from being deleted
 func (s *Service) PinBackup(id string) error {
-	_, backup := s.getBackupByID(id)
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	_, backup := s.store.get(id)
+	if backup == nil {
+		return errBackupNotFound
+	}
 	backup.Pinned = true
 
 	slog.Info("backup pinned", "name", backup.Name)
@@ -248,7 +528,13 @@ func (s *Service) PinBackup(id string) error {
 
 // UnpinBackup unpins a backup to allow it to be deleted
 func (s *Service) UnpinBackup(id string) error {
-	_, backup := s.getBackupByID(id)
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	_, backup := s.store.get(id)
+	if backup == nil {
+		return errBackupNotFound
+	}
 	backup.Pinned = false
 
 	slog.Info("backup unpinned", "name", backup.Name)
@@ -256,21 +542,34 @@ func (s *Service) UnpinBackup(id string) error {
 	return s.saveBackupsToFile()
 }
 
-// ListBackups returns the list of backups in memory
+// ListBackups returns a copy of the list of backups in memory
 func (s *Service) ListBackups() []*Backup {
-	return s.backups
+	return s.store.snapshot()
 }
 
 // TimeUntilNextBackup returns the time until the next backup in milliseconds
 func (s *Service) TimeUntilNextBackup() int64 {
-	return time.Until(nextBackupCalculatedAt.Add(nextBackupIn)).Milliseconds()
+	next, _ := s.NextBackup()
+	return next.Sub(s.scheduler.Now()).Milliseconds()
+}
+
+// NextBackup returns when the next scheduled backup will run and for which profile
+func (s *Service) NextBackup() (time.Time, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.nextBackup.In(s.config().Timezone), s.nextBackupProfile
 }
 
-// NameExists checks if a backup with the given name exists
-func (s *Service) NameExists(name string) bool {
-	generatedName := generateBackupName(name, s.config.BackupNameFormat, s.config.Timezone)
+// NameExists checks if a backup with the given name, or the name generated by the profile, exists
+func (s *Service) NameExists(name string, profileName string) bool {
+	profile, _ := s.config().GetProfile(profileName)
+	generatedName := generateBackupName(name, profile.NameFormat, s.scheduler.Now().In(s.config().Timezone))
 
-	for _, backup := range s.backups {
+	s.store.RLock()
+	defer s.store.RUnlock()
+
+	for _, backup := range s.store.backups {
 		if backup.Name == generatedName {
 			return true
 		}
@@ -279,29 +578,46 @@ func (s *Service) NameExists(name string) bool {
 	return false
 }
 
-// ResetBackups resets the local state of backups
-func (s *Service) ResetBackups() error {
-	file, err := os.Create("/data/backups.json")
+// ResetBackups forgets the tracked backups and finds them again with a sync, it runs as a job
+func (s *Service) ResetBackups(ctx context.Context) error {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	file, err := os.Create(s.store.path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	s.backups = []*Backup{}
-	s.syncBackups()
-
-	return nil
+	s.store.reset()
+	return s.syncBackups(ctx)
 }
 
-// syncBackups synchronizes the backups by performing the following steps
-func (s *Service) syncBackups() error {
-	// Cancel if there is an ongoing backup
-	if len(ongoingBackups) > 0 {
+// syncBackups synchronizes the backups by performing the following steps. The lock of the store must be held.
+func (s *Service) syncBackups(ctx context.Context) (err error) {
+	// Cancel if there is an ongoing backup, and keep other operations from starting until the sync is done
+	if !s.ongoing.start(syncOperation, true) {
 		slog.Debug("skipping synchronization due to ongoing backup operations.")
 		return nil
 	}
+	defer s.ongoing.finish(syncOperation)
+
+	changed := false
+	started := time.Now()
+	s.events.publish(EventSyncStarted, nil)
+	defer func() {
+		syncDuration.Observe(time.Since(started).Seconds())
 
-	// Reset timer when this function returns
+		event := SyncEvent{Changed: changed}
+		if err != nil {
+			syncErrors.Inc()
+			event.Error = err.Error()
+		}
+		s.events.publish(EventSyncFinished, event)
+	}()
+
+	// Reset timer when this function returns, then publish the entities with the next backup
+	defer s.updateEntities()
 	defer s.resetTimerForNextBackup()
 
 	// Take an initial snapshot of the state
@@ -310,50 +626,46 @@ func (s *Service) syncBackups() error {
 		return err
 	}
 
-	// Create a map of backups for easy access
-	backupMap := make(map[string]*Backup)
-	for _, backup := range s.backups {
-		backupMap[backup.Name] = backup
-		// Nil out HA and S3
-		// This will delete the backup from the map if it's not found in HA or S3 during the sync
-		backup.HA = nil
-		backup.S3 = nil
-	}
+	// Index backups by slug, name and object keys to match them with what is found during the sync
+	index := newBackupIndex(s.store.backups)
 
 	// Keep HA backups up to date
-	err = s.updateHABackups(backupMap)
+	err = s.updateHABackups(ctx, index)
 	if err != nil {
 		return err
 	}
 
 	// Keep S3 backups up to date
-	err = s.updateS3Backups(backupMap)
+	err = s.updateS3Backups(ctx, index)
 	if err != nil {
 		return err
 	}
 
+	// Backups not found in HA or any destination lose their copies
+	index.apply()
+
+	// Make sure backups in Home Assistant are valid before syncing them
+	s.inspectHABackups()
+
 	// Mark backups for deletion if needed
-	err = s.deleteExcessBackups()
+	err = s.deleteExcessBackups(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Update statuses and sync backups to S3 if needed
-	for _, backup := range s.backups {
-		backupInHA, backupInS3 := backup.HA != nil, backup.S3 != nil
-		if backupInHA && backupInS3 {
-			backup.UpdateStatus(StatusSynced)
-		} else if backupInHA {
-			backup.UpdateStatus(StatusHAOnly)
-		} else if backupInS3 {
-			backup.UpdateStatus(StatusS3Only)
-		}
+	for _, backup := range s.store.backups {
+		s.updateStatus(backup)
 	}
 
-	if err := s.ensureS3Backups(); err != nil {
+	if err := s.ensureS3Backups(ctx); err != nil {
 		return err
 	}
 
+	s.clearStaleRetries()
+	s.abortStaleUploads(ctx)
+	s.resetRetryTimer()
+
 	// Take a final snapshot of the state
 	finalState, err := s.calculateBackupsHash()
 	if err != nil {
@@ -361,14 +673,14 @@ func (s *Service) syncBackups() error {
 	}
 
 	// Compare initial and final state to determine if anything was done
-	if initialState == finalState {
+	changed = initialState != finalState
+	if !changed {
 		slog.Info("nothing to do")
 	}
 
 	// Sort and save backups
-	sort.Slice(s.backups, func(i, j int) bool {
-		return s.backups[i].Date.After(s.backups[j].Date)
-	})
+	s.store.sort()
+	s.lastSync = s.scheduler.Now()
 
 	if err := s.saveBackupsToFile(); err != nil {
 		slog.Error("error saving backup state after backup operation", "error", err)
@@ -378,46 +690,109 @@ func (s *Service) syncBackups() error {
 	return nil
 }
 
-// ensureS3Backups syncs the required number of backups to S3
-func (s *Service) ensureS3Backups() error {
-	haOnlyBackups := []*Backup{}
-	s3Backups := 0
+// updateStatus sets the status of a backup based on where it's present
+func (s *Service) updateStatus(backup *Backup) {
+	backupInHA, backupInRemote := backup.HA != nil, len(backup.Remotes) > 0
+	// The manifest is only needed to upload, copies that are already everywhere are synced even if the tarball can't be read
+	synced := backupInHA && backupInRemote && s.inRequiredDestinations(backup)
+
+	// Corrupt backups stay corrupt until they pass a verification
+	if backup.Status == StatusCorrupt || backup.Status == StatusVerifying {
+		return
+	}
 
-	for _, backup := range s.backups {
-		if !backup.Pinned {
-			switch backup.Status {
-			case StatusSynced, StatusS3Only:
-				s3Backups++
-			case StatusHAOnly:
-				haOnlyBackups = append(haOnlyBackups, backup)
+	// Failed backups and backups waiting for a retry keep their status until they're synced
+	if !synced && (backup.Status == StatusFailed || backup.NextRetry != nil) {
+		if backup.NextRetry != nil {
+			s.setStatus(backup, StatusRetrying)
+		}
+		return
+	}
+
+	if synced {
+		backup.clearRetry()
+		s.setStatus(backup, StatusSynced)
+	} else if backupInHA && backupInRemote {
+		s.setStatus(backup, StatusIncomplete)
+	} else if backupInHA {
+		s.setStatus(backup, StatusHAOnly)
+	} else if backupInRemote {
+		s.setStatus(backup, StatusS3Only)
+	}
+}
+
+// inRequiredDestinations checks if a backup is present in every destination that isn't optional
+func (s *Service) inRequiredDestinations(backup *Backup) bool {
+	for _, d := range s.config().Destinations {
+		if _, exists := backup.Remotes[d.Name]; !exists && !d.Optional {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ensureS3Backups syncs the required number of backups of each profile to each destination
+func (s *Service) ensureS3Backups(ctx context.Context) error {
+	options := s.config()
+	for _, profile := range options.AllProfiles() {
+		for _, d := range options.Destinations {
+			if err := s.ensureDestinationBackups(ctx, profile, d); err != nil {
+				return err
 			}
 		}
 	}
 
+	return nil
+}
+
+// ensureDestinationBackups syncs the required number of backups of a profile to a destination
+func (s *Service) ensureDestinationBackups(ctx context.Context, profile config.Profile, d config.DestinationOptions) error {
+	missingBackups := []*Backup{}
+	remoteBackups := 0
+	keep := profile.Keep(d.Name)
+
+	for _, backup := range s.store.backups {
+		if backup.Profile != profile.Name || backup.Pinned || backup.Status == StatusFailed || backup.waitingForRetry(s.scheduler.Now()) {
+			continue
+		}
+
+		if _, exists := backup.Remotes[d.Name]; exists {
+			remoteBackups++
+		} else if backup.HA != nil {
+			missingBackups = append(missingBackups, backup)
+		}
+	}
+
 	var uploadCount int
-	if s.config.BackupsInS3 > 0 {
-		uploadCount = s.config.BackupsInS3 - s3Backups
+	if keep > 0 {
+		uploadCount = keep - remoteBackups
 	} else {
-		uploadCount = len(haOnlyBackups)
+		uploadCount = len(missingBackups)
 	}
 
-	if uploadCount > 0 && uploadCount > len(haOnlyBackups) {
-		uploadCount = len(haOnlyBackups)
+	if uploadCount > 0 && uploadCount > len(missingBackups) {
+		uploadCount = len(missingBackups)
 	}
 
 	for i := 0; i < uploadCount; i++ {
-		backup := haOnlyBackups[i]
-		if err := s.syncBackupToS3(backup); err != nil {
+		backup := missingBackups[i]
+		if err := s.syncBackupToDestination(ctx, backup, d); err != nil && !d.Optional {
 			return err
 		}
+		s.updateStatus(backup)
 	}
 
 	return nil
 }
 
-// updateHABackups adds Home Assistant backups to the backup map if they don't exist by name
-func (s *Service) updateHABackups(backupMap map[string]*Backup) error {
-	haBackups, err := s.hassioClient.ListBackups()
+// updateHABackups matches Home Assistant backups to tracked backups by slug and tracks the ones that don't match
+func (s *Service) updateHABackups(ctx context.Context, index *backupIndex) error {
+	var haBackups []*hassio.Backup
+	var err error
+	s.store.unlocked(func() {
+		haBackups, err = s.hassioClient.ListBackups(ctx)
+	})
 	if err != nil {
 		return err
 	}
@@ -428,178 +803,207 @@ func (s *Service) updateHABackups(backupMap map[string]*Backup) error {
 	}
 
 	for _, haBackup := range haBackups {
-		if haBackup.Type == "partial" {
-			continue // Skip partial backups
-		}
-
-		if _, exists := backupMap[haBackup.Name]; !exists {
-			slog.Info("found untracked backup in home assistant", "name", haBackup.Name)
+		backup := index.match(haBackup.Slug, haBackup.Name)
+		if backup == nil {
+			profile, matched := s.matchProfile(haBackup)
+			if !matched {
+				slog.Debug("skipping backup not matching any profile", "name", haBackup.Name, "type", haBackup.Type)
+				continue
+			}
 
-			backup := s.initializeBackup(haBackup.Name)
-			backup.HA = haBackup
-			backup.Date = haBackup.Date.In(s.config.Timezone)
+			slog.Info("found untracked backup in home assistant", "name", haBackup.Name, "profile", profile.Name)
 
-			backupMap[haBackup.Name] = backup
-		} else {
-			backupMap[haBackup.Name].HA = haBackup
+			backup = s.initializeBackup(haBackup.Name, profile)
+			backup.Date = haBackup.Date.In(s.config().Timezone)
 		}
+
+		backup.Slug = haBackup.Slug
+		index.add(backup)
+		index.foundInHA(backup, haBackup)
 	}
 
 	return nil
 }
 
-// updateS3Backups adds backups found in S3 to the backup map if they don't exist by name
-func (s *Service) updateS3Backups(backupMap map[string]*Backup) error {
-	s3Backups := []*s3.Object{}
-	objectCh := s.s3Client.ListObjects(context.Background(), s.config.S3.Bucket, minio.ListObjectsOptions{})
-	for object := range objectCh {
-		if object.Err != nil {
-			slog.Error("could not list objects in s3: %v", "error", object.Err)
-			return fmt.Errorf("could not list objects: %v", object.Err)
-		}
-
-		s3Backups = append(s3Backups, &s3.Object{
-			Key:      object.Key,
-			Size:     float64(object.Size) / (1024 * 1024), // convert bytes to MB
-			Modified: object.LastModified,
-		})
+// matchProfile finds the profile of an untracked Home Assistant backup
+// Full backups belong to the default profile and partial backups to the profile selecting the same content
+func (s *Service) matchProfile(haBackup *hassio.Backup) (config.Profile, bool) {
+	if haBackup.Type != "partial" {
+		return s.config().GetProfile(config.DefaultProfile)
 	}
 
-	if len(s3Backups) == 0 {
-		slog.Debug("no backups found in s3")
-		return nil
+	for _, profile := range s.config().AllProfiles() {
+		if !profile.Partial || profile.HomeAssistant != haBackup.Content.HomeAssistant {
+			continue
+		}
+
+		if sameElements(profile.Addons, haBackup.Content.Addons) && sameElements(profile.Folders, haBackup.Content.Folders) {
+			return profile, true
+		}
 	}
 
-	for _, s3Backup := range s3Backups {
-		name := strings.TrimSuffix(s3Backup.Key, ".tar")
+	return config.Profile{}, false
+}
 
-		if _, exists := backupMap[name]; !exists {
-			slog.Info("found untracked backup in s3", "name", s3Backup.Key)
-			backup := s.initializeBackup(name)
+// sameElements checks if two slices contain the same strings regardless of order
+func sameElements(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
 
-			backup.S3 = s3Backup
-			backup.Date = s3Backup.Modified
+	counts := make(map[string]int)
+	for _, v := range a {
+		counts[v]++
+	}
 
-		} else {
-			backupMap[name].S3 = s3Backup
+	for _, v := range b {
+		if counts[v] == 0 {
+			return false
 		}
+		counts[v]--
 	}
 
-	return nil
+	return true
 }
 
-// deleteExcessBackups marks the oldest excess backups for deletion based on the given limit and returns true if any were marked for deletion
-func (s *Service) deleteExcessBackups() error {
-	// Get backups that aren't pinned or failed
-	backups := []*Backup{}
+// updateS3Backups matches objects in each destination to tracked backups by the slug in their metadata.
+// Untracked objects are only added if they are valid Home Assistant backups.
+func (s *Service) updateS3Backups(ctx context.Context, index *backupIndex) error {
+	for _, d := range s.config().Destinations {
+		var remoteBackups []*s3.Object
+		var err error
+		s.store.unlocked(func() {
+			remoteBackups, err = s.storages[d.Name].List(ctx)
+		})
+		if err != nil {
+			slog.Error("could not list objects in destination", "destination", d.Name, "error", err)
+			return err
+		}
 
-	for _, backup := range s.backups {
-		if !backup.Pinned && backup.Status != StatusFailed {
-			backups = append(backups, backup)
+		if len(remoteBackups) == 0 {
+			slog.Debug("no backups found in destination", "destination", d.Name)
+			continue
 		}
-	}
 
-	// Sort non-pinned backups by date, oldest first
-	sort.Slice(backups, func(i, j int) bool {
-		return backups[i].Date.Before(backups[j].Date)
-	})
+		for _, remoteBackup := range remoteBackups {
+			backup, slug := s.matchObject(ctx, index, d.Name, remoteBackup)
 
-	// Mark excess backups in HA for deletion
-	if s.config.BackupsInHA > 0 {
-		haBackups := []*Backup{}
-		var emptyHA hassio.Backup
+			if backup == nil {
+				manifest, ok := s.isBackupObject(ctx, d.Name, remoteBackup)
+				if !ok {
+					continue
+				}
 
-		for _, backup := range backups {
-			if backup.HA != nil && *backup.HA != emptyHA {
-				haBackups = append(haBackups, backup)
-			}
-		}
+				slog.Info("found untracked backup in destination", "name", remoteBackup.Key, "destination", d.Name)
+				profile, matched := s.matchProfile(&hassio.Backup{Type: manifest.Type, Content: manifest.content()})
+				if !matched {
+					profile, _ = s.config().GetProfile(config.DefaultProfile)
+				}
 
-		// Retain the most recent HA backups
-		if len(haBackups) > s.config.BackupsInHA {
-			for i := 0; i < len(haBackups)-s.config.BackupsInHA; i++ {
-				if !haBackups[i].Pinned {
-					if err := s.hassioClient.DeleteBackup(haBackups[i].HA.Slug); err != nil {
-						return err
-					}
+				name := manifest.Name
+				if name == "" {
+					name, _ = parseObjectKey(remoteBackup.Key)
+				}
 
-					haBackups[i].HA = nil
+				// Only found in the destination so far, the sync of Home Assistant backups already ran
+				backup = s.initializeBackup(name, profile)
+				backup.HA = nil
+				backup.Manifest = manifest
+				backup.Date = manifest.Date.In(s.config().Timezone)
+				if manifest.Date.IsZero() {
+					backup.Date = remoteBackup.Modified
+				}
 
-					slog.Info("deleted backup from home assistant", "name", haBackups[i].Name)
+				if slug == "" {
+					slug = manifest.Slug
 				}
 			}
-		} else {
-			slog.Debug("skipping deletion for Home Assistant backups; limit is set to 0.")
+
+			if backup.Slug == "" && slug != "" {
+				backup.Slug = slug
+			}
+			index.add(backup)
+			index.foundInDestination(backup, d.Name, remoteBackup)
 		}
 	}
 
-	// Mark excess backups in S3 for deletion
-	if s.config.BackupsInS3 > 0 {
+	return nil
+}
 
-		s3Backups := []*Backup{}
-		for _, backup := range backups {
-			if backup.S3 != nil {
-				s3Backups = append(s3Backups, backup)
-			}
+// deleteExcessBackups deletes the backups of each profile that aren't kept by its retention
+func (s *Service) deleteExcessBackups(ctx context.Context) error {
+	for _, deletion := range s.retentionPlan(s.config()) {
+		_, backup := s.store.get(deletion.ID)
+		if backup == nil {
+			continue
 		}
 
-		if len(s3Backups) > s.config.BackupsInS3 {
-			// Mark the oldest S3 backups for deletion
-			for i := 0; i < len(s3Backups)-s.config.BackupsInS3; i++ {
-				if !s3Backups[i].Pinned {
-					if err := s.s3Client.RemoveObject(context.Background(), s.config.S3.Bucket, s3Backups[i].S3.Key, minio.RemoveObjectOptions{}); err != nil {
-						return err
-					}
+		if deletion.Location == LocationHA {
+			var err error
+			slug := backup.HA.Slug
+			s.store.unlocked(func() {
+				err = s.hassioClient.DeleteBackup(ctx, slug)
+			})
+			if err != nil {
+				return err
+			}
 
-					s3Backups[i].S3 = nil
+			backup.HA = nil
 
-					slog.Info("deleted backup from S3", "name", s3Backups[i].Name)
-				}
-			}
-		} else {
-			slog.Debug("skipping deletion for S3 backups; limit is set to 0.")
+			slog.Info("deleted backup from home assistant", "name", backup.Name, "reason", deletion.Reason)
+			s.webhooks.notify(webhookDeleted, backup, deletion.Location, deletion.Reason)
+			continue
 		}
-	}
 
-	// Delete backups from the local map after ensuring HA and S3 are up to date
-	backupsToKeep := []*Backup{}
-	for _, backup := range s.backups {
-		if backup.HA != nil || backup.S3 != nil || backup.Status == StatusFailed {
-			backupsToKeep = append(backupsToKeep, backup)
+		var err error
+		key := backup.Remotes[deletion.Location].Key
+		s.store.unlocked(func() {
+			err = s.storages[deletion.Location].Delete(ctx, key)
+		})
+		if err != nil {
+			return err
 		}
+
+		delete(backup.Remotes, deletion.Location)
+
+		slog.Info("deleted backup from destination", "name", backup.Name, "destination", deletion.Location, "reason", deletion.Reason)
+		s.webhooks.notify(webhookDeleted, backup, deletion.Location, deletion.Reason)
 	}
 
-	s.backups = backupsToKeep
+	// Delete backups from the local map after ensuring HA and destinations are up to date
+	s.store.retain(func(backup *Backup) bool {
+		return backup.HA != nil || len(backup.Remotes) > 0 || backup.Status == StatusFailed || backup.NextRetry != nil
+	})
 
 	return nil
 }
 
-// initializeBackup returns a new internal backup object
-func (s *Service) initializeBackup(name string) *Backup {
-	generatedName := generateBackupName(name, s.config.BackupNameFormat, s.config.Timezone)
+// initializeBackup returns a new internal backup object for the given profile
+func (s *Service) initializeBackup(name string, profile config.Profile) *Backup {
+	generatedName := generateBackupName(name, profile.NameFormat, s.scheduler.Now().In(s.config().Timezone))
 
 	backup := &Backup{
-		ID:     base64.RawURLEncoding.EncodeToString([]byte(generatedName)),
-		Name:   generatedName,
-		Date:   time.Now().In(s.config.Timezone),
-		Status: StatusPending,
-		S3:     new(s3.Object),
-		HA:     new(hassio.Backup),
+		ID:      s.newBackupID(generatedName),
+		Name:    generatedName,
+		Profile: profile.Name,
+		Date:    s.scheduler.Now().In(s.config().Timezone),
+		Status:  StatusPending,
+		Remotes: make(map[string]*s3.Object),
+		HA:      new(hassio.Backup),
 	}
 
-	s.backups = append([]*Backup{backup}, s.backups...)
+	s.store.add(backup)
 
-	slog.Debug("new backup initialized", "name", backup.Name, "status", backup.Status)
+	slog.Debug("new backup initialized", "name", backup.Name, "profile", backup.Profile, "status", backup.Status)
 	return backup
 }
 
-// generateBackupName generates a backup name based on the provided format and timezone
-func generateBackupName(requestName string, format string, timezone *time.Location) string {
+// generateBackupName generates a backup name based on the provided format and the current time
+func generateBackupName(requestName string, format string, now time.Time) string {
 	if requestName != "" {
 		return requestName
 	}
 
-	now := time.Now().In(timezone)
 	format = strings.ReplaceAll(format, "{year}", now.Format("2006"))
 	format = strings.ReplaceAll(format, "{month}", now.Format("01"))
 	format = strings.ReplaceAll(format, "{day}", now.Format("02"))
@@ -610,21 +1014,47 @@ func generateBackupName(requestName string, format string, timezone *time.Locati
 	return format
 }
 
-// syncBackupToS3 uploads a backup to the remote drive if needed
-func (s *Service) syncBackupToS3(backup *Backup) error {
-	if backup.S3 != nil {
-		_, err := s.s3Client.StatObject(context.Background(), s.config.S3.Bucket, backup.Name, minio.StatObjectOptions{})
+// syncBackupToS3 uploads a backup to every destination that doesn't have it yet
+func (s *Service) syncBackupToS3(ctx context.Context, backup *Backup) error {
+	for _, d := range s.config().Destinations {
+		if err := s.syncBackupToDestination(ctx, backup, d); err != nil && !d.Optional {
+			return err
+		}
+	}
+
+	s.updateStatus(backup)
+
+	return nil
+}
+
+// syncBackupToDestination uploads a backup to a single destination if needed
+func (s *Service) syncBackupToDestination(ctx context.Context, backup *Backup, d config.DestinationOptions) error {
+	storage := s.storages[d.Name]
+
+	if remote, exists := backup.Remotes[d.Name]; exists {
+		var err error
+		key := remote.Key
+		s.store.unlocked(func() {
+			_, err = storage.Stat(ctx, key)
+		})
 		if err == nil {
 			return nil
 		}
 	}
 
-	slog.Debug("syncing backup to s3", "name", backup.Name)
-	backup.UpdateStatus(StatusSyncing)
-	_, err := s.uploadBackupToS3(backup)
+	slog.Debug("syncing backup to destination", "name", backup.Name, "destination", d.Name)
+	s.setStatus(backup, StatusSyncing)
+	started := time.Now()
+	key, err := s.uploadBackupToS3(ctx, backup, d.Name, storage)
 	if err != nil {
-		backup.UpdateStatus(StatusFailed)
-		backup.ErrorMessage = err.Error()
+		err = fmt.Errorf("upload to %s failed: %w", d.Name, err)
+
+		if d.Optional {
+			slog.Warn("failed to sync backup to optional destination", "name", backup.Name, "destination", d.Name, "error", err)
+			return err
+		}
+
+		s.scheduleRetry(backup, err)
 
 		if err := s.saveBackupsToFile(); err != nil {
 			slog.Error("error saving backup state after backup operation", "error", err)
@@ -633,149 +1063,311 @@ func (s *Service) syncBackupToS3(backup *Backup) error {
 
 		return err
 	}
+	uploadDuration.Observe(time.Since(started).Seconds(), d.Name)
 
-	s.updateS3BackupDetails(backup)
-
-	backup.UpdateStatus(StatusSynced)
+	if err := s.updateS3BackupDetails(ctx, backup, d.Name, key); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-// uploadBackupToS3 uploads a backup from Home Assistant to the remote drive
-func (s *Service) uploadBackupToS3(backup *Backup) (string, error) {
-	ctx := context.Background()
-	contentType := "application/octet-stream"
-
-	objectName := fmt.Sprintf("%s.%s", backup.Name, "tar")
-	path := fmt.Sprintf("%s/%s.%s", "/backup", backup.HA.Slug, "tar")
-
-	slog.Debug("uploading backup to s3", "name", backup.Name)
-	info, err := s.s3Client.FPutObject(ctx, s.config.S3.Bucket, objectName, path, minio.PutObjectOptions{ContentType: contentType})
+// uploadBackupToS3 uploads a backup from Home Assistant to the given destination, resuming an interrupted upload if possible
+func (s *Service) uploadBackupToS3(ctx context.Context, backup *Backup, destination string, storage Storage) (string, error) {
+	path := s.haBackupPath(backup)
+	stat, err := os.Stat(path)
 	if err != nil {
 		return "", err
 	}
 
-	return info.Key, nil
-}
+	// Only valid backups are uploaded, the tarball is read without holding the lock
+	if backup.Manifest == nil {
+		var manifest *Manifest
+		s.store.unlocked(func() {
+			manifest, err = inspectFile(path)
+		})
+		if err != nil {
+			return "", err
+		}
+		backup.Manifest = manifest
+	}
 
-// startBackupScheduler starts a goroutine that will perform backups on a timer
-func (s *Service) startBackupScheduler() {
-	s.resetTimerForNextBackup()
+	size := stat.Size()
+	key := objectKey(backup)
 
-	go func() {
-		for {
-			select {
-			case <-backupTimer.C:
-				slog.Info("performing scheduled backup")
+	// Encrypt the backup on the fly if encryption is enabled
+	var secret []byte
+	if s.config().Encryption.Enabled() {
+		secret, err = s.config().Encryption.Secret()
+		if err != nil {
+			return "", err
+		}
+
+		size = crypt.EncryptedSize(size)
+		key += crypt.Suffix
+	}
+
+	if backup.Uploads == nil {
+		backup.Uploads = make(map[string]*Upload)
+	}
 
-				if err := s.PerformBackup(""); err != nil {
-					slog.Error("failed to perform scheduled backup", "error", err)
+	upload, exists := backup.Uploads[destination]
+	if !exists {
+		upload = &Upload{}
+		backup.Uploads[destination] = upload
+	}
+
+	// The checksum of the tarball is stored with the object so it can be verified later on
+	if backup.Checksum == "" {
+		var checksum string
+		s.store.unlocked(func() {
+			checksum, err = checksumFile(path)
+		})
+		if err != nil {
+			return "", err
+		}
+		backup.Checksum = checksum
+	}
+	metadata := map[string]string{
+		checksumMetadata: backup.Checksum,
+		slugMetadata:     backup.Slug,
+		dateMetadata:     backup.Date.UTC().Format(time.RFC3339),
+		typeMetadata:     backup.Manifest.Type,
+	}
+
+	// The encryption header is kept with the upload so a resumed upload continues the same stream
+	if secret == nil {
+		upload.EncryptionHeader = nil
+	} else if upload.EncryptionHeader == nil {
+		if upload.EncryptionHeader, err = crypt.NewHeader(); err != nil {
+			return "", err
+		}
+	}
+
+	header := upload.EncryptionHeader
+	open := func(offset int64) (io.ReadCloser, error) {
+		r, err := openBackupAt(path, secret, header, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		reader := throttle.NewReader(ctx, r, s.config().TransferLimit())
+		return &readCloser{Reader: newProgressReader(reader, &s.store, s.events, backup, offset, size), closers: []io.Closer{r}}, nil
+	}
+
+	slog.Debug("uploading backup to s3", "name", backup.Name, "destination", destination, "encrypted", secret != nil)
+	defer clearProgress(backup)
+
+	var object *s3.Object
+	if resumable, ok := storage.(ResumableStorage); ok {
+		// The upload keeps changing its parts while it runs, the backup gets a copy every time they're saved
+		multipart := cloneMultipart(upload.Multipart)
+		save := func(multipart *s3.Upload) {
+			s.store.update(func() {
+				upload.Multipart = cloneMultipart(multipart)
+				if err := s.saveBackupsToFile(); err != nil {
+					slog.Error("error saving upload state", "error", err)
 				}
-			case <-stopBackupChan:
-				slog.Info("stopping backup scheduler")
+			})
+		}
+
+		s.store.unlocked(func() {
+			object, err = resumable.PutResumable(ctx, key, open, size, metadata, multipart, save)
+		})
+	} else {
+		s.store.unlocked(func() {
+			var r io.ReadCloser
+			if r, err = open(0); err != nil {
 				return
 			}
+			defer r.Close()
+
+			object, err = storage.Put(ctx, key, r, size, metadata)
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	delete(backup.Uploads, destination)
+
+	return object.Key, nil
+}
+
+// performScheduledBackup queues the backup the timer was set for
+func (s *Service) performScheduledBackup() {
+	_, profile := s.NextBackup()
+	slog.Info("performing scheduled backup", "profile", profile)
+
+	s.jobs.add(OperationCreate, "", func(ctx context.Context) error {
+		err := s.PerformBackup(ctx, "", profile)
+		if err != nil {
+			slog.Error("failed to perform scheduled backup", "error", err)
+
+			s.store.Lock()
+			s.resetTimerForNextBackup()
+			s.updateEntities()
+			s.store.Unlock()
 		}
-	}()
+
+		return err
+	})
 }
 
-// startBackupSyncScheduler starts a goroutine that will perform syncs on a timer
-func (s *Service) startBackupSyncScheduler() {
-	syncTicker = time.NewTicker(syncInterval)
+// scheduleSync schedules the next periodic sync
+func (s *Service) scheduleSync() {
+	s.scheduler.Schedule(jobSync, syncInterval, func() {
+		slog.Info("performing scheduled backup sync")
+		s.queueSync()
+		s.scheduleSync()
+	})
+}
 
-	go func() {
-		for {
-			select {
-			case <-syncTicker.C:
-				slog.Info("performing scheduled backup sync")
+// queueSync queues a sync with Home Assistant and the destinations
+func (s *Service) queueSync() {
+	s.jobs.add(OperationSync, "", func(ctx context.Context) error {
+		s.store.Lock()
+		defer s.store.Unlock()
 
-				if err := s.syncBackups(); err != nil {
-					slog.Error("error performing backup sync", "error", err)
-				}
-			case <-stopSyncChan:
-				slog.Info("stopping sync scheduler")
-				syncTicker.Stop()
-				return
-			}
+		if err := s.syncBackups(ctx); err != nil {
+			slog.Error("error performing backup sync", "error", err)
+			return err
 		}
-	}()
+
+		return nil
+	})
 }
 
-// calculateDurationUntilNextBackup calculates the duration until the next backup should occur
-func (s *Service) calculateDurationUntilNextBackup() time.Duration {
-	latestBackup := s.getLatestBackup()
-	if latestBackup == nil {
-		return 1 * time.Second
+// calculateDurationUntilNextBackup calculates the duration until the next backup should occur and which profile it's for
+func (s *Service) calculateDurationUntilNextBackup() (time.Duration, string) {
+	now := s.scheduler.Now()
+
+	var next time.Time
+	var nextProfile string
+
+	for _, profile := range s.config().AllProfiles() {
+		at, scheduled := s.nextBackupTime(profile, now)
+		if !scheduled {
+			continue
+		}
+
+		if nextProfile == "" || at.Before(next) {
+			next = at
+			nextProfile = profile.Name
+		}
+	}
+
+	// Check again tomorrow if no profile is scheduled
+	if nextProfile == "" {
+		return 24 * time.Hour, ""
 	}
 
-	elapsed := time.Since(latestBackup.Date)
-	if elapsed >= time.Duration(s.config.BackupInterval)*24*time.Hour {
-		return 1 * time.Second
+	if duration := next.Sub(now); duration > time.Second {
+		return duration, nextProfile
 	}
 
-	return time.Duration(s.config.BackupInterval)*24*time.Hour - elapsed
+	return 1 * time.Second, nextProfile
 }
 
-// resetTimerForNextBackup sets the timer for the next backup
-func (s *Service) resetTimerForNextBackup() {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// nextBackupTime returns when the next backup of a profile is due, or false if the profile isn't scheduled
+func (s *Service) nextBackupTime(profile config.Profile, now time.Time) (time.Time, bool) {
+	latestBackup := s.getLatestBackup(profile.Name)
+
+	// Cron schedules take precedence over intervals
+	if profile.Schedule != "" {
+		schedule, err := cron.Parse(profile.Schedule)
+		if err != nil {
+			slog.Error("invalid backup schedule", "profile", profile.Name, "schedule", profile.Schedule, "error", err)
+			return time.Time{}, false
+		}
 
-	nextBackupIn = s.calculateDurationUntilNextBackup()
-	nextBackupCalculatedAt = time.Now()
+		now = now.In(s.config().Timezone)
 
-	if !backupTimer.Stop() && backupTimer != nil {
-		select {
-		case <-backupTimer.C: // Drain the channel
-		default:
+		// A backup was missed if the schedule fired between the latest backup and now
+		if latestBackup != nil && s.config().MissedBackups != config.MissedBackupsSkip {
+			missed := schedule.Next(latestBackup.Date.In(s.config().Timezone))
+			if !missed.IsZero() && !missed.After(now) {
+				slog.Info("scheduled backup was missed, catching up", "profile", profile.Name, "missed", missed)
+				return now, true
+			}
 		}
+
+		next := schedule.Next(now)
+		return next, !next.IsZero()
+	}
+
+	// Profiles without an interval are only backed up on request
+	if profile.Interval <= 0 {
+		return time.Time{}, false
+	}
+
+	if latestBackup == nil {
+		return now, true
 	}
 
-	backupTimer.Reset(nextBackupIn)
-	slog.Debug(fmt.Sprintf("next backup in %s", nextBackupIn.String()))
+	return latestBackup.Date.Add(time.Duration(profile.Interval) * 24 * time.Hour), true
+}
+
+// resetTimerForNextBackup sets the timer for the next backup. The lock of the store must be held.
+func (s *Service) resetTimerForNextBackup() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	nextBackupIn, profile := s.calculateDurationUntilNextBackup()
+	s.nextBackup = s.scheduler.Now().Add(nextBackupIn)
+	s.nextBackupProfile = profile
+
+	s.scheduler.Schedule(jobBackup, nextBackupIn, s.performScheduledBackup)
+	slog.Debug(fmt.Sprintf("next backup in %s", nextBackupIn.String()), "profile", profile)
 }
 
 // listenForConfigChanges listens for changes to certain config values and takes action when the config changes
 func (s *Service) listenForConfigChanges(configChan <-chan *config.Options) {
-	for range configChan {
-		s.syncBackups()
+	for {
+		select {
+		case <-configChan:
+			s.events.publish(EventConfigChanged, nil)
+			s.queueSync()
+		case <-s.scheduler.Done():
+			return
+		}
 	}
 }
 
-// loadBackupsFromFile populates the initial list of backups from a file on disk
+// loadBackupsFromFile populates the initial list of backups from a file on disk. The lock of the store must be held.
 func (s *Service) loadBackupsFromFile() {
-	path := "/data/backups.json"
-	data, err := os.ReadFile(path)
-	if err != nil {
+	if err := s.store.load(); err != nil {
 		slog.Error("error loading backups from file", "error", err)
 		return
 	}
 
-	if err := json.Unmarshal(data, &s.backups); err != nil {
-		slog.Error("error unmarshaling backups", "error", err)
+	for _, backup := range s.store.backups {
+		// Backups from before profiles were introduced belong to the default profile
+		if backup.Profile == "" {
+			backup.Profile = config.DefaultProfile
+		}
+
+		// Retries from before failed creations were flagged are creations if the backup never got a slug
+		if backup.NextRetry != nil && backup.Slug == "" && (backup.HA == nil || backup.HA.Slug == "") {
+			backup.CreationFailed = true
+		}
 	}
 }
 
-// saveBackupsToFile persists the list of backups to a file on disk
+// saveBackupsToFile persists the list of backups to a file on disk. The lock of the store must be held.
 func (s *Service) saveBackupsToFile() error {
-	data, err := json.Marshal(s.backups)
-	if err != nil {
-		return err
-	}
-
-	err = os.WriteFile("/data/backups.json", data, 0644)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return s.store.save()
 }
 
-// getLatestBackup returns the latest backup
-func (s *Service) getLatestBackup() *Backup {
+// getLatestBackup returns the latest backup of a profile
+func (s *Service) getLatestBackup(profile string) *Backup {
 	var latestBackup *Backup
 
-	for _, backup := range s.backups {
+	for _, backup := range s.store.backups {
+		if backup.Profile != profile {
+			continue
+		}
+
 		if latestBackup == nil || backup.Date.After(latestBackup.Date) {
 			latestBackup = backup
 		}
@@ -784,44 +1376,54 @@ func (s *Service) getLatestBackup() *Backup {
 	return latestBackup
 }
 
-// getBackupByID finds and returns the backup by ID
-func (s *Service) getBackupByID(id string) (int, *Backup) {
-	for i, b := range s.backups {
-		if b.ID == id {
-			return i, b
-		}
-	}
-
-	return -1, nil
-}
-
-// updateS3BackupDetails updates the backup with information from S3
-func (s *Service) updateS3BackupDetails(backup *Backup) error {
-	slog.Debug("fetching backup attributes from s3", "name", backup.Name)
+// updateS3BackupDetails updates the backup with information from a destination
+func (s *Service) updateS3BackupDetails(ctx context.Context, backup *Backup, destination string, key string) error {
+	slog.Debug("fetching backup attributes from destination", "name", backup.Name, "destination", destination)
 
-	objectName := backup.Name + ".tar"
-	object, err := s.s3Client.StatObject(context.Background(), s.config.S3.Bucket, objectName, minio.StatObjectOptions{})
+	var attributes *s3.Object
+	var err error
+	s.store.unlocked(func() {
+		attributes, err = s.storages[destination].Stat(ctx, key)
+	})
 	if err != nil {
 		return fmt.Errorf("could not open object: %v", err)
 	}
 
-	attributes := &s3.Object{
-		Key:      objectName,
-		Size:     float64(object.Size) / (1024 * 1024), // convert bytes to MB
-		Modified: object.LastModified,
-	}
-
 	slog.Debug("attributes fetched", "key", attributes.Key, "size", attributes.Size, "modified", attributes.Modified)
 
-	backup.S3 = attributes
+	backup.Remotes[destination] = attributes
 
 	return nil
 }
 
+// objectKey returns the key of a backup in a destination, the slug keeps backups with the same name apart
+func objectKey(backup *Backup) string {
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(backup.Name)
+	if backup.Slug == "" {
+		return name + ".tar"
+	}
+
+	return fmt.Sprintf("%s_%s.tar", name, backup.Slug)
+}
+
+// keySlugPattern matches the slug objectKey appends to the name of a backup
+var keySlugPattern = regexp.MustCompile(`^(.*)_([0-9a-f]{8})$`)
+
+// parseObjectKey returns the backup name and slug for an object key, with or without encryption suffix.
+// Keys of backups uploaded before slugs were recorded have no slug.
+func parseObjectKey(key string) (string, string) {
+	name := strings.TrimSuffix(strings.TrimSuffix(key, crypt.Suffix), ".tar")
+	if match := keySlugPattern.FindStringSubmatch(name); match != nil {
+		return match[1], match[2]
+	}
+
+	return name, ""
+}
+
 // calculateBackupsHash returns a hash of the backup array
 func (s *Service) calculateBackupsHash() (string, error) {
 	h := sha256.New()
-	for _, backup := range s.backups {
+	for _, backup := range s.store.backups {
 		backupJSON, err := json.Marshal(backup)
 		if err != nil {
 			return "", err
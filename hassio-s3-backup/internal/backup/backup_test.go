@@ -0,0 +1,537 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSyncMatchesObjectsToBackups(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s3Storage, nasStorage := newMemoryStorage(clock), newMemoryStorage(clock)
+	s := newTestService(t, clock, &config.Options{
+		Timezone: time.UTC,
+		Destinations: []config.DestinationOptions{
+			{Name: "s3", Type: config.DestinationS3},
+			{Name: "nas", Type: config.DestinationLocal},
+		},
+		Profiles: []config.Profile{{Name: "addons", Partial: true, Addons: []string{"core_ssh"}}},
+	}, map[string]Storage{"s3": s3Storage, "nas": nasStorage})
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+
+	// Matched by the slug in the metadata of the object, although it was renamed, then uploaded to the other destination
+	renamed := &Backup{ID: "renamed", Name: "Full Backup", Slug: "aaaa1111", Profile: config.DefaultProfile, Date: clock.Now(), Remotes: map[string]*s3.Object{
+		"s3": {Key: "Full Backup_aaaa1111.tar"},
+	}}
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now(), Type: "full"})
+	s3Storage.putObject("Renamed_aaaa1111.tar", []byte("backup"), clock.Now(), map[string]string{slugMetadata: "aaaa1111"})
+
+	// Matched by name, objects uploaded by earlier versions have no metadata
+	legacy := &Backup{ID: "legacy", Name: "Legacy", Profile: config.DefaultProfile, Date: clock.Now().AddDate(0, 0, -1)}
+	nasStorage.putObject("Legacy.tar", []byte("backup"), clock.Now(), nil)
+
+	// Not matched, a backup with another slug only shares the name
+	other := &Backup{ID: "other", Name: "Shared", Slug: "bbbb2222", Profile: config.DefaultProfile, Date: clock.Now().AddDate(0, 0, -2)}
+	s3Storage.putObject("Shared.tar", []byte("backup"), clock.Now(), map[string]string{slugMetadata: "cccc3333"})
+
+	// Gone from Home Assistant and every destination
+	gone := &Backup{ID: "gone", Name: "Gone", Slug: "dddd4444", Profile: config.DefaultProfile, Date: clock.Now().AddDate(0, 0, -3), Remotes: map[string]*s3.Object{
+		"s3": {Key: "Gone_dddd4444.tar"},
+	}}
+
+	// Untracked backup, found by reading its manifest
+	nasStorage.putObject("Add-ons_eeee5555.tar", backupTarball(t, Manifest{
+		Slug:   "eeee5555",
+		Name:   "Add-ons",
+		Date:   time.Date(2024, 9, 20, 3, 0, 0, 0, time.UTC),
+		Type:   "partial",
+		Addons: []ManifestAddon{{Slug: "core_ssh", Name: "Terminal & SSH", Version: "9.14.0"}},
+	}), clock.Now(), nil)
+
+	// Objects that aren't backups
+	s3Storage.putObject("notes.txt", []byte("notes"), clock.Now(), nil)
+	s3Storage.putObject("broken.tar", []byte("not a tarball"), clock.Now(), nil)
+
+	s.store.backups = []*Backup{renamed, legacy, other, gone}
+
+	runSync(t, s)
+
+	if remote := renamed.Remotes["s3"]; remote == nil || remote.Key != "Renamed_aaaa1111.tar" {
+		t.Errorf("renamed backup has remote %+v in s3, want the renamed object", remote)
+	}
+	if remote := renamed.Remotes["nas"]; remote == nil || renamed.Status != StatusSynced {
+		t.Errorf("renamed backup is %s with remote %+v in nas, want it uploaded and synced", renamed.Status, remote)
+	}
+	if remote := legacy.Remotes["nas"]; remote == nil || remote.Key != "Legacy.tar" || legacy.Status != StatusS3Only {
+		t.Errorf("legacy backup is %s with remote %+v in nas, want the object with its name", legacy.Status, remote)
+	}
+
+	var found *Backup
+	for _, backup := range s.store.backups {
+		switch backup {
+		case other:
+			t.Errorf("backup with another slug was matched to %v", other.Remotes)
+		case gone:
+			t.Error("backup gone from home assistant and every destination is still tracked")
+		}
+
+		// Objects that aren't valid backups aren't tracked, even with a slug in their metadata
+		switch backup.Slug {
+		case "eeee5555":
+			found = backup
+		case "cccc3333":
+			t.Errorf("object that isn't a backup is tracked as %q", backup.Name)
+		}
+	}
+
+	if found == nil {
+		t.Fatal("untracked backup in nas isn't tracked")
+	}
+	if found.Name != "Add-ons" || found.Profile != "addons" || found.Manifest == nil || found.Remotes["nas"] == nil {
+		t.Errorf("untracked backup is tracked as %q of profile %q with manifest %v and remotes %v", found.Name, found.Profile, found.Manifest, found.Remotes)
+	}
+	if want := time.Date(2024, 9, 20, 3, 0, 0, 0, time.UTC); !found.Date.Equal(want) {
+		t.Errorf("untracked backup has date %s, want the date of its manifest %s", found.Date, want)
+	}
+
+	if len(s.invalidObjects) != 2 {
+		t.Errorf("%d objects were recorded as invalid, want 2", len(s.invalidObjects))
+	}
+	if len(s.store.backups) != 3 {
+		t.Errorf("%d backups are tracked, want 3", len(s.store.backups))
+	}
+
+	// Syncing again finds the same backups without uploading them again
+	uploaded := renamed.Remotes["nas"].Key
+	runSync(t, s)
+
+	if len(s.store.backups) != 3 {
+		t.Errorf("%d backups are tracked after syncing again, want 3", len(s.store.backups))
+	}
+	if remote := found.Remotes["nas"]; remote == nil {
+		t.Error("found backup lost its remote after syncing again")
+	}
+	if remote := renamed.Remotes["nas"]; remote == nil || remote.Key != uploaded {
+		t.Errorf("renamed backup has remote %+v in nas after syncing again, want %s", remote, uploaded)
+	}
+}
+
+func TestSyncDeletesExcessBackups(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	storage := newMemoryStorage(clock)
+	s := newTestService(t, clock, &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3, Keep: 1}},
+	}, map[string]Storage{"s3": storage})
+
+	for i, name := range []string{"Newest", "Older", "Pinned", "Oldest"} {
+		key := name + ".tar"
+		storage.putObject(key, []byte("backup"), clock.Now(), nil)
+		s.store.backups = append(s.store.backups, &Backup{
+			ID:      name,
+			Name:    name,
+			Profile: config.DefaultProfile,
+			Date:    clock.Now().AddDate(0, 0, -i),
+			Pinned:  name == "Pinned",
+			Remotes: map[string]*s3.Object{"s3": {Key: key}},
+		})
+	}
+
+	runSync(t, s)
+
+	tracked := []string{}
+	for _, backup := range s.store.backups {
+		tracked = append(tracked, backup.Name)
+	}
+	if len(tracked) != 2 || tracked[0] != "Newest" || tracked[1] != "Pinned" {
+		t.Errorf("tracking %v after retention, want the newest and the pinned backup", tracked)
+	}
+
+	for _, key := range []string{"Older.tar", "Oldest.tar"} {
+		if _, err := storage.Stat(context.Background(), key); err == nil {
+			t.Errorf("%s wasn't deleted from the destination", key)
+		}
+	}
+	if len(storage.deleted) != 2 {
+		t.Errorf("deleted %v, want only the 2 excess backups", storage.deleted)
+	}
+}
+
+func TestSyncReplicatesToEveryDestination(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s3Storage, nasStorage, usbStorage := newMemoryStorage(clock), newMemoryStorage(clock), newMemoryStorage(clock)
+	usbStorage.putErr = errors.New("device not mounted")
+	s := newTestService(t, clock, &config.Options{
+		Timezone: time.UTC,
+		Destinations: []config.DestinationOptions{
+			{Name: "s3", Type: config.DestinationS3},
+			{Name: "nas", Type: config.DestinationLocal},
+			{Name: "usb", Type: config.DestinationLocal, Optional: true},
+		},
+	}, map[string]Storage{"s3": s3Storage, "nas": nasStorage, "usb": usbStorage})
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now(), Type: "full"})
+
+	runSync(t, s)
+
+	if len(s.store.backups) != 1 {
+		t.Fatalf("tracking %d backups, want the backup in home assistant", len(s.store.backups))
+	}
+	backup := s.store.backups[0]
+
+	// The optional destination failing doesn't keep the backup from being synced
+	if backup.Status != StatusSynced || backup.ErrorMessage != "" {
+		t.Errorf("backup is %s with error %q, want it synced to the required destinations", backup.Status, backup.ErrorMessage)
+	}
+	for destination, storage := range map[string]*memoryStorage{"s3": s3Storage, "nas": nasStorage} {
+		remote := backup.Remotes[destination]
+		if remote == nil {
+			t.Errorf("backup has no remote in %s", destination)
+			continue
+		}
+		if _, err := storage.Stat(context.Background(), remote.Key); err != nil {
+			t.Errorf("backup wasn't uploaded to %s: %v", destination, err)
+		}
+	}
+	if remote, exists := backup.Remotes["usb"]; exists {
+		t.Errorf("backup has remote %+v in the failing optional destination", remote)
+	}
+}
+
+func TestSyncMarksPartiallyUploadedBackupIncomplete(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s3Storage, nasStorage := newMemoryStorage(clock), newMemoryStorage(clock)
+	nasStorage.putErr = errors.New("connection refused")
+	s := newTestService(t, clock, &config.Options{
+		Timezone: time.UTC,
+		Destinations: []config.DestinationOptions{
+			{Name: "s3", Type: config.DestinationS3},
+			{Name: "nas", Type: config.DestinationLocal, Keep: 1},
+		},
+		Retry: config.RetryOptions{Attempts: 3, Backoff: time.Minute},
+	}, map[string]Storage{"s3": s3Storage, "nas": nasStorage})
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Older", Date: clock.Now().Add(-time.Hour), Type: "full"})
+
+	// The upload to s3 succeeds and the one to nas fails, so the backup waits for a retry
+	s.store.Lock()
+	err := s.syncBackups(context.Background())
+	s.store.Unlock()
+	if err == nil {
+		t.Fatal("sync with a failing required destination returned no error")
+	}
+
+	s.store.RLock()
+	older := s.store.backups[0]
+	if older.Status != StatusRetrying || older.Remotes["s3"] == nil || older.Remotes["nas"] != nil {
+		t.Errorf("backup is %s with remotes %v, want it in s3 and waiting to be retried", older.Status, older.Remotes)
+	}
+	s.store.RUnlock()
+
+	// By the time the retry is due, a newer backup is the 1 to keep in nas, so the older one is left out of it
+	nasStorage.mutex.Lock()
+	nasStorage.putErr = nil
+	nasStorage.mutex.Unlock()
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "bbbb2222", Name: "Newer", Date: clock.Now(), Type: "full"})
+
+	clock.Advance(2 * time.Minute)
+
+	jobs := s.jobs.list()
+	if len(jobs) != 1 {
+		t.Fatalf("%d jobs queued when the retry was due, want 1", len(jobs))
+	}
+	waitForJob(t, s.jobs, jobs[0].ID, JobSucceeded)
+
+	s.store.RLock()
+	defer s.store.RUnlock()
+
+	if older.Status != StatusIncomplete || older.NextRetry != nil || older.Remotes["nas"] != nil {
+		t.Errorf("older backup is %s with retry %v and remotes %v, want it incomplete without nas", older.Status, older.NextRetry, older.Remotes)
+	}
+	for _, backup := range s.store.backups {
+		if backup.Slug == "bbbb2222" && (backup.Status != StatusSynced || backup.Remotes["nas"] == nil) {
+			t.Errorf("newer backup is %s with remotes %v, want it synced to both destinations", backup.Status, backup.Remotes)
+		}
+	}
+}
+
+func TestSyncAppliesRetentionPerDestination(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s3Storage, nasStorage := newMemoryStorage(clock), newMemoryStorage(clock)
+	s := newTestService(t, clock, &config.Options{
+		Timezone: time.UTC,
+		Destinations: []config.DestinationOptions{
+			{Name: "s3", Type: config.DestinationS3, Keep: 2},
+			{Name: "nas", Type: config.DestinationLocal, Keep: 1},
+		},
+	}, map[string]Storage{"s3": s3Storage, "nas": nasStorage})
+
+	for i, name := range []string{"Newest", "Older", "Oldest"} {
+		key := name + ".tar"
+		s3Storage.putObject(key, []byte("backup"), clock.Now(), nil)
+		nasStorage.putObject(key, []byte("backup"), clock.Now(), nil)
+		s.store.backups = append(s.store.backups, &Backup{
+			ID:      name,
+			Name:    name,
+			Profile: config.DefaultProfile,
+			Date:    clock.Now().AddDate(0, 0, -i),
+			Remotes: map[string]*s3.Object{"s3": {Key: key}, "nas": {Key: key}},
+		})
+	}
+
+	runSync(t, s)
+
+	if len(s3Storage.deleted) != 1 || s3Storage.deleted[0] != "Oldest.tar" {
+		t.Errorf("deleted %v from s3, want only the oldest backup past its 2 to keep", s3Storage.deleted)
+	}
+	sort.Strings(nasStorage.deleted)
+	if len(nasStorage.deleted) != 2 || nasStorage.deleted[0] != "Older.tar" || nasStorage.deleted[1] != "Oldest.tar" {
+		t.Errorf("deleted %v from nas, want the 2 backups past its 1 to keep", nasStorage.deleted)
+	}
+
+	remotes := make(map[string]int)
+	for _, backup := range s.store.backups {
+		for destination := range backup.Remotes {
+			remotes[backup.Name+" in "+destination]++
+		}
+	}
+	if len(s.store.backups) != 2 || len(remotes) != 3 || remotes["Newest in nas"] != 1 || remotes["Older in s3"] != 1 {
+		t.Errorf("tracking %v after retention, want the newest backup in both destinations and the older one in s3", remotes)
+	}
+}
+
+func TestSyncWhileHandlingRequests(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	storage := newMemoryStorage(clock)
+	s := newTestService(t, clock, &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+	}, map[string]Storage{"s3": storage})
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+	for i := 1; i <= 5; i++ {
+		supervisor.addBackup(t, s.backupDir, Manifest{Slug: fmt.Sprintf("aaaa000%d", i), Name: fmt.Sprintf("Backup %d", i), Date: clock.Now().AddDate(0, 0, -i), Type: "full"})
+	}
+	runSync(t, s)
+
+	ids := []string{}
+	for _, backup := range s.store.backups {
+		ids = append(ids, backup.ID)
+	}
+	deleted := ids[0]
+
+	mux := http.NewServeMux()
+	RegisterBackupRoutes(mux, s)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	request := func(method string, path string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		return resp
+	}
+	hammer := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					f()
+				}
+			}
+		}()
+	}
+
+	// Backups that are in Home Assistant and the destination never show up as missing while a sync runs
+	hammer(func() {
+		resp := request(http.MethodGet, "/api/backups")
+		if resp == nil {
+			return
+		}
+		defer resp.Body.Close()
+
+		var backups []*Backup
+		if err := json.NewDecoder(resp.Body).Decode(&backups); err != nil {
+			t.Errorf("listed backups aren't JSON: %v", err)
+			return
+		}
+		for _, backup := range backups {
+			if backup.ID != deleted && (backup.HA == nil || len(backup.Remotes) != 1) {
+				t.Errorf("listed %s with home assistant backup %v and remotes %v during a sync", backup.Name, backup.HA, backup.Remotes)
+			}
+		}
+	})
+	hammer(func() {
+		for _, id := range ids[1:] {
+			for _, action := range []string{"pin", "unpin"} {
+				if resp := request(http.MethodPost, "/api/backups/"+id+"/"+action); resp != nil {
+					resp.Body.Close()
+				}
+			}
+		}
+	})
+	hammer(func() {
+		if resp := request(http.MethodDelete, "/api/backups/"+deleted); resp != nil {
+			resp.Body.Close()
+		}
+	})
+
+	for i := 0; i < 20; i++ {
+		runSync(t, s)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRestoreBackup(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{Timezone: time.UTC}, nil)
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+
+	full := supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now(), Type: "full"})
+	partial := supervisor.addBackup(t, s.backupDir, Manifest{
+		Slug:    "bbbb2222",
+		Name:    "Add-ons",
+		Date:    clock.Now(),
+		Type:    "partial",
+		Addons:  []ManifestAddon{{Slug: "core_ssh", Name: "Terminal & SSH", Version: "9.14.0"}},
+		Folders: []string{"share"},
+	})
+	s.store.backups = []*Backup{
+		{ID: "full", Name: full.Name, Slug: full.Slug, HA: full, Profile: config.DefaultProfile, Date: full.Date},
+		{ID: "partial", Name: partial.Name, Slug: partial.Slug, HA: partial, Profile: config.DefaultProfile, Date: partial.Date},
+	}
+
+	if err := s.RestoreBackup(context.Background(), "full", "secret"); err != nil {
+		t.Fatalf("restoring the full backup returned error: %v", err)
+	}
+	if err := s.RestoreBackup(context.Background(), "partial", ""); err != nil {
+		t.Fatalf("restoring the partial backup returned error: %v", err)
+	}
+
+	requests := supervisor.received("/backups/aaaa1111/restore/full")
+	if len(requests) != 1 || string(requests[0].Body) != `{"password":"secret"}` {
+		t.Errorf("full backup restored with %v, want a single full restore with the password", requests)
+	}
+
+	// Partial backups are restored with everything they contain
+	requests = supervisor.received("/backups/bbbb2222/restore/partial")
+	if len(requests) != 1 {
+		t.Fatalf("partial backup restored with %d partial restores, want 1", len(requests))
+	}
+	var request hassio.PartialRestoreRequest
+	if err := json.Unmarshal(requests[0].Body, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request.HomeAssistant || len(request.Addons) != 1 || request.Addons[0] != "core_ssh" || len(request.Folders) != 1 || request.Folders[0] != "share" {
+		t.Errorf("partial restore of %+v, want the add-on and folder in the backup", request)
+	}
+	if len(supervisor.received("/backups/bbbb2222/restore/full")) != 0 {
+		t.Error("partial backup was restored in full")
+	}
+}
+
+func TestSyncMarksUnreadableBackupInDestinationsSynced(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	storage := newMemoryStorage(clock)
+	s := newTestService(t, clock, &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+	}, map[string]Storage{"s3": storage})
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+
+	// The tarball in Home Assistant can't be read, but the destination already has a copy
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now(), Type: "full"})
+	if err := os.Remove(filepath.Join(s.backupDir, "aaaa1111.tar")); err != nil {
+		t.Fatal(err)
+	}
+	storage.putObject("Full Backup_aaaa1111.tar", []byte("backup"), clock.Now(), map[string]string{slugMetadata: "aaaa1111"})
+
+	runSync(t, s)
+
+	if len(s.store.backups) != 1 {
+		t.Fatalf("tracking %d backups, want the backup in home assistant and the destination", len(s.store.backups))
+	}
+	if backup := s.store.backups[0]; backup.Status != StatusSynced || backup.Manifest != nil {
+		t.Errorf("backup is %s with manifest %v, want it synced without a manifest", backup.Status, backup.Manifest)
+	}
+}
+
+func TestSyncMatchesObjectsByKeyWithoutMetadata(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	storage := newMemoryStorage(clock)
+	s := newTestService(t, clock, &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3, Keep: 1}},
+	}, map[string]Storage{"s3": storage})
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+
+	// The metadata can't be read, so the slug in the key tells apart the backups sharing a name
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now().Add(-time.Hour), Type: "full"})
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "bbbb2222", Name: "Full Backup", Date: clock.Now(), Type: "full"})
+	storage.putObject("Full Backup_bbbb2222.tar", []byte("backup"), clock.Now(), map[string]string{slugMetadata: "bbbb2222"})
+	storage.statErr = errors.New("metadata unavailable")
+
+	runSync(t, s)
+
+	if len(s.store.backups) != 2 {
+		t.Fatalf("tracking %d backups, want the copy matched to a backup in home assistant", len(s.store.backups))
+	}
+	for _, backup := range s.store.backups {
+		if _, uploaded := backup.Remotes["s3"]; uploaded != (backup.Slug == "bbbb2222") || backup.HA == nil {
+			t.Errorf("backup %s is in home assistant %t and remotes %v, want only bbbb2222 in the destination", backup.Slug, backup.HA != nil, backup.Remotes)
+		}
+	}
+}
+
+func TestParseObjectKey(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"Full Backup_aaaa1111.tar":     {"Full Backup", "aaaa1111"},
+		"Full Backup_aaaa1111.tar.enc": {"Full Backup", "aaaa1111"},
+		"Legacy.tar":                   {"Legacy", ""},
+		"Before_upgrade.tar":           {"Before_upgrade", ""},
+	} {
+		name, slug := parseObjectKey(key)
+		if name != want[0] || slug != want[1] {
+			t.Errorf("parseObjectKey(%q) = %q, %q, want %q, %q", key, name, slug, want[0], want[1])
+		}
+	}
+}
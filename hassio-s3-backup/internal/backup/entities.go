@@ -0,0 +1,172 @@
+package backup
+
+import (
+	"context"
+	"hassio-proton-drive-backup/internal/hassio"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IDs of the entities published in Home Assistant
+const (
+	entityLastBackup = "sensor.s3_backup_last_backup"   // Date of the newest backup in a destination
+	entityLastSync   = "sensor.s3_backup_last_sync"     // Time the last sync finished
+	entityNextBackup = "sensor.s3_backup_next_backup"   // Time of the next scheduled backup
+	entityCount      = "sensor.s3_backup_backups"       // Number of backups in the destinations
+	entitySize       = "sensor.s3_backup_size"          // Total size of the backups in the destinations
+	entityFailed     = "binary_sensor.s3_backup_failed" // On while any backup is failed or corrupt
+)
+
+// coreAPITimeout is how long a request to the Core API of Home Assistant may take
+const coreAPITimeout = 10 * time.Second
+
+// entity is the state of an entity to publish in Home Assistant
+type entity struct {
+	id    string
+	state hassio.State
+}
+
+// entityPublisher publishes the latest entity states in the background, so backups never wait on Home Assistant.
+// States set while a previous set is being published replace each other, only the latest is published.
+type entityPublisher struct {
+	client  supervisor
+	mutex   sync.Mutex
+	pending []entity
+	wake    chan struct{}
+}
+
+// newEntityPublisher returns a publisher setting states through the client
+func newEntityPublisher(client supervisor) *entityPublisher {
+	return &entityPublisher{
+		client: client,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// set replaces the states waiting to be published
+func (p *entityPublisher) set(entities []entity) {
+	p.mutex.Lock()
+	p.pending = entities
+	p.mutex.Unlock()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run publishes states as they're set until done is closed
+func (p *entityPublisher) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-p.wake:
+		case <-done:
+			return
+		}
+
+		p.mutex.Lock()
+		entities := p.pending
+		p.pending = nil
+		p.mutex.Unlock()
+
+		for _, e := range entities {
+			ctx, cancel := context.WithTimeout(context.Background(), coreAPITimeout)
+			err := p.client.SetState(ctx, e.id, e.state)
+			cancel()
+
+			if err != nil {
+				slog.Warn("failed to publish entity in home assistant", "entity", e.id, "error", err)
+			}
+		}
+	}
+}
+
+// updateEntities publishes the state of the backups as entities in Home Assistant,
+// with the same values as ListBackups and NextBackup. The lock of the store must be held.
+func (s *Service) updateEntities() {
+	var lastBackup *Backup
+	var count int
+	var size float64
+	failed := []string{}
+
+	for _, backup := range s.store.backups {
+		if backup.Status == StatusFailed || backup.Status == StatusCorrupt {
+			failed = append(failed, backup.Name)
+		}
+
+		if len(backup.Remotes) == 0 {
+			continue
+		}
+
+		count++
+		size += backup.remoteSize()
+
+		if backup.Status != StatusCorrupt && (lastBackup == nil || backup.Date.After(lastBackup.Date)) {
+			lastBackup = backup
+		}
+	}
+
+	next, profile := s.NextBackup()
+
+	lastBackupState := hassio.State{State: "unknown", Attributes: entityAttributes("Last S3 Backup", "mdi:cloud-check", "timestamp", "")}
+	if lastBackup != nil {
+		lastBackupState.State = lastBackup.Date.Format(time.RFC3339)
+		lastBackupState.Attributes["backup_name"] = lastBackup.Name
+	}
+
+	lastSyncState := hassio.State{State: "unknown", Attributes: entityAttributes("Last S3 Sync", "mdi:cloud-sync", "timestamp", "")}
+	if !s.lastSync.IsZero() {
+		lastSyncState.State = s.lastSync.Format(time.RFC3339)
+	}
+
+	nextBackupState := hassio.State{State: "unknown", Attributes: entityAttributes("Next S3 Backup", "mdi:calendar-clock", "timestamp", "")}
+	if !next.IsZero() {
+		nextBackupState.State = next.Format(time.RFC3339)
+		nextBackupState.Attributes["profile"] = profile
+	}
+
+	failedState := hassio.State{State: "off", Attributes: entityAttributes("S3 Backup Failed", "mdi:cloud-alert", "problem", "")}
+	if len(failed) > 0 {
+		failedState.State = "on"
+	}
+	failedState.Attributes["backups"] = failed
+
+	s.entities.set([]entity{
+		{id: entityLastBackup, state: lastBackupState},
+		{id: entityLastSync, state: lastSyncState},
+		{id: entityNextBackup, state: nextBackupState},
+		{id: entityCount, state: hassio.State{State: strconv.Itoa(count), Attributes: entityAttributes("S3 Backups", "mdi:cloud-upload", "", "backups")}},
+		{id: entitySize, state: hassio.State{State: strconv.FormatFloat(size, 'f', 1, 64), Attributes: entityAttributes("S3 Backup Size", "mdi:harddisk", "data_size", "MB")}},
+		{id: entityFailed, state: failedState},
+	})
+}
+
+// remoteSize returns the size of the backup in its destinations in MB, the largest if they differ
+func (b *Backup) remoteSize() float64 {
+	var size float64
+	for _, remote := range b.Remotes {
+		size = max(size, remote.Size)
+	}
+
+	return size
+}
+
+// entityAttributes returns the attributes describing an entity, the device class and unit are left out if empty
+func entityAttributes(name string, icon string, deviceClass string, unit string) map[string]interface{} {
+	attributes := map[string]interface{}{
+		"friendly_name": name,
+		"icon":          icon,
+	}
+
+	if deviceClass != "" {
+		attributes["device_class"] = deviceClass
+	}
+
+	if unit != "" {
+		attributes["unit_of_measurement"] = unit
+	}
+
+	return attributes
+}
@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"encoding/json"
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"testing"
+	"time"
+)
+
+func TestUpdateEntities(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{Timezone: time.UTC}, nil)
+
+	supervisor, client := newFakeSupervisor(t)
+	s.entities = newEntityPublisher(client)
+	go s.entities.run(s.scheduler.Done())
+
+	s.store.backups = []*Backup{
+		{ID: "newest", Name: "Newest", Status: StatusSynced, Date: clock.Now(), Remotes: map[string]*s3.Object{
+			"s3":  {Key: "Newest.tar", Size: 100},
+			"nas": {Key: "Newest.tar", Size: 100},
+		}},
+		{ID: "older", Name: "Older", Status: StatusS3Only, Date: clock.Now().AddDate(0, 0, -1), Remotes: map[string]*s3.Object{
+			"s3": {Key: "Older.tar", Size: 50.5},
+		}},
+		{ID: "failed", Name: "Failed", Status: StatusFailed, Date: clock.Now().Add(time.Hour)},
+	}
+	s.nextBackup, s.nextBackupProfile = clock.Now().AddDate(0, 0, 1), config.DefaultProfile
+
+	s.store.Lock()
+	s.updateEntities()
+	s.store.Unlock()
+
+	states := make(map[string]hassio.State)
+	for _, id := range []string{entityLastBackup, entityLastSync, entityNextBackup, entityCount, entitySize, entityFailed} {
+		requests := supervisor.waitForRequests(t, "/core/api/states/"+id, 1)
+		var state hassio.State
+		if err := json.Unmarshal(requests[0].Body, &state); err != nil {
+			t.Fatalf("state of %s isn't JSON: %v", id, err)
+		}
+		if requests[0].Method != "POST" || state.Attributes["friendly_name"] == nil {
+			t.Errorf("%s set with %s and attributes %v, want a POST with a friendly name", id, requests[0].Method, state.Attributes)
+		}
+		states[id] = state
+	}
+
+	// The newest backup in a destination is the last one, the failed backup never reached one
+	if state := states[entityLastBackup]; state.State != "2024-09-25T12:00:00Z" || state.Attributes["backup_name"] != "Newest" || state.Attributes["device_class"] != "timestamp" {
+		t.Errorf("last backup is %q with attributes %v, want the newest backup", state.State, state.Attributes)
+	}
+	if state := states[entityLastSync]; state.State != "unknown" {
+		t.Errorf("last sync is %q before any sync, want unknown", state.State)
+	}
+	if state := states[entityNextBackup]; state.State != "2024-09-26T12:00:00Z" || state.Attributes["profile"] != config.DefaultProfile {
+		t.Errorf("next backup is %q with attributes %v, want the scheduled backup", state.State, state.Attributes)
+	}
+	if state := states[entityCount]; state.State != "2" || state.Attributes["unit_of_measurement"] != "backups" {
+		t.Errorf("%q backups with attributes %v, want 2", state.State, state.Attributes)
+	}
+
+	// Copies of a backup in several destinations count once
+	if state := states[entitySize]; state.State != "150.5" || state.Attributes["unit_of_measurement"] != "MB" {
+		t.Errorf("size is %q with attributes %v, want 150.5 MB", state.State, state.Attributes)
+	}
+
+	state := states[entityFailed]
+	failed, _ := state.Attributes["backups"].([]interface{})
+	if state.State != "on" || len(failed) != 1 || failed[0] != "Failed" {
+		t.Errorf("failed is %q with backups %v, want on with the failed backup", state.State, state.Attributes["backups"])
+	}
+}
@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change an event reports
+type EventType string
+
+const (
+	EventStatus        EventType = "status"         // Backup changed status
+	EventProgress      EventType = "progress"       // Upload or download of a backup progressed
+	EventSyncStarted   EventType = "sync_started"   // Sync with Home Assistant and the destinations started
+	EventSyncFinished  EventType = "sync_finished"  // Sync with Home Assistant and the destinations finished
+	EventConfigChanged EventType = "config_changed" // Configuration was updated
+	EventJob           EventType = "job"            // Job was queued, started, finished or cancelled, with the job as data
+)
+
+// eventBuffer is how many events a subscriber can fall behind before events are dropped for it
+const eventBuffer = 64
+
+// Event is a change in the state of the service, published to subscribers as it happens
+type Event struct {
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// StatusEvent is the data of an EventStatus event
+type StatusEvent struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Status   status `json:"status"`
+	Previous status `json:"previous"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ProgressEvent is the data of an EventProgress event
+type ProgressEvent struct {
+	ID               string  `json:"id"`
+	Name             string  `json:"name"`
+	Progress         float64 `json:"progress"`         // Percent
+	BytesTransferred int64   `json:"bytesTransferred"` // Bytes
+	BytesTotal       int64   `json:"bytesTotal"`       // Bytes
+	Throughput       float64 `json:"throughput"`       // Bytes per second
+}
+
+// SyncEvent is the data of an EventSyncFinished event
+type SyncEvent struct {
+	Changed bool   `json:"changed"` // Whether the sync changed any backup
+	Error   string `json:"error,omitempty"`
+}
+
+// eventBus delivers published events to every subscriber.
+// Publishing never blocks, a subscriber that doesn't keep up misses events rather than holding up backups.
+type eventBus struct {
+	clock       Clock
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// newEventBus returns an event bus timestamping events with the given clock
+func newEventBus(clock Clock) *eventBus {
+	return &eventBus{
+		clock:       clock,
+		subscribers: make(map[chan Event]struct{}),
+	}
+}
+
+// publish sends an event to every subscriber with room for it
+func (b *eventBus) publish(eventType EventType, data any) {
+	event := Event{Type: eventType, Time: b.clock.Now(), Data: data}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel receiving the events published from now on,
+// and a function that unsubscribes and closes the channel. The channel is closed right away once the bus is closed.
+func (b *eventBus) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if _, exists := b.subscribers[ch]; exists {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// close closes the channels of all subscribers and of the ones subscribing later
+func (b *eventBus) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Subscribe returns a channel receiving the events of the service, and a function to unsubscribe
+func (s *Service) Subscribe() (<-chan Event, func()) {
+	return s.events.subscribe()
+}
+
+// CloseSubscriptions closes the channels of all subscribers, so long-lived event streams end when the server shuts down
+func (s *Service) CloseSubscriptions() {
+	s.events.close()
+}
+
+// setStatus updates the status of a backup, publishes the transition and reports backups that failed or succeeded
+// to Home Assistant. The lock of the store must be held.
+func (s *Service) setStatus(backup *Backup, status status) {
+	previous := backup.Status
+	backup.UpdateStatus(status)
+
+	if previous == status {
+		return
+	}
+
+	s.events.publish(EventStatus, StatusEvent{
+		ID:       backup.ID,
+		Name:     backup.Name,
+		Status:   status,
+		Previous: previous,
+		Error:    backup.ErrorMessage,
+	})
+
+	s.reportOutcome(backup, previous)
+}
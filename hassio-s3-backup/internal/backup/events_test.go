@@ -0,0 +1,166 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"hassio-proton-drive-backup/internal/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	bus := newEventBus(clock)
+
+	events, unsubscribe := bus.subscribe()
+	bus.publish(EventSyncStarted, nil)
+
+	event := <-events
+	if event.Type != EventSyncStarted || !event.Time.Equal(clock.Now()) {
+		t.Errorf("received %s event at %s, want %s at %s", event.Type, event.Time, EventSyncStarted, clock.Now())
+	}
+
+	// A subscriber that doesn't keep up misses the events it has no room for, without blocking the publisher
+	for i := 0; i < eventBuffer+10; i++ {
+		bus.publish(EventProgress, i)
+	}
+	if len(events) != eventBuffer {
+		t.Errorf("%d events buffered for a slow subscriber, want %d", len(events), eventBuffer)
+	}
+	if event := <-events; event.Data != 0 {
+		t.Errorf("first buffered event has data %v, want the oldest event", event.Data)
+	}
+
+	unsubscribe()
+	if _, open := drain(events); open {
+		t.Error("channel is open after unsubscribing")
+	}
+	unsubscribe()
+
+	// Closing the bus ends current and later subscriptions
+	events, _ = bus.subscribe()
+	bus.close()
+	if _, open := drain(events); open {
+		t.Error("channel is open after the bus was closed")
+	}
+
+	events, _ = bus.subscribe()
+	if _, open := drain(events); open {
+		t.Error("channel of a subscription after the bus was closed is open")
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{Timezone: time.UTC}, nil)
+
+	backup := &Backup{ID: "backup", Name: "Full Backup", Status: StatusPending, Profile: config.DefaultProfile, Date: clock.Now()}
+	s.store.add(backup)
+
+	mux := http.NewServeMux()
+	RegisterBackupRoutes(mux, s)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("event stream has content type %q, want text/event-stream", contentType)
+	}
+
+	// The first comment is flushed right away, once it's read the stream is subscribed
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || line != ": connected\n" {
+		t.Fatalf("event stream started with %q and error %v, want the connected comment", line, err)
+	}
+
+	s.store.Lock()
+	s.setStatus(backup, StatusSyncing)
+	s.store.Unlock()
+
+	progress := newProgressReader(bytes.NewReader(make([]byte, 100)), &s.store, s.events, backup, 0, 100)
+	if _, err := io.Copy(io.Discard, progress); err != nil {
+		t.Fatal(err)
+	}
+
+	var status StatusEvent
+	readEvent(t, reader, EventStatus, &status)
+	if status.ID != "backup" || status.Status != StatusSyncing || status.Previous != StatusPending {
+		t.Errorf("status event %+v, want the backup going from pending to syncing", status)
+	}
+
+	var transferred ProgressEvent
+	readEvent(t, reader, EventProgress, &transferred)
+	if transferred.ID != "backup" || transferred.BytesTotal != 100 || transferred.BytesTransferred == 0 {
+		t.Errorf("progress event %+v, want the progress of the backup", transferred)
+	}
+
+	// The stream ends once the subscriptions are closed
+	s.CloseSubscriptions()
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		t.Errorf("reading the rest of the stream returned error: %v", err)
+	}
+	if strings.Contains(string(rest), "event: "+string(EventStatus)) {
+		t.Errorf("stream sent another status event before ending: %q", rest)
+	}
+}
+
+// readEvent reads the next event of the given type from a Server-Sent Events stream, skipping others, and decodes its data
+func readEvent(t *testing.T, reader *bufio.Reader, eventType EventType, data any) {
+	t.Helper()
+
+	var name string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended before a %s event: %v", eventType, err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && name == string(eventType):
+			var event struct {
+				Type EventType       `json:"type"`
+				Data json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("event data isn't JSON: %v", err)
+			}
+			if event.Type != eventType {
+				t.Fatalf("%s event has type %s in its data", eventType, event.Type)
+			}
+			if err := json.Unmarshal(event.Data, data); err != nil {
+				t.Fatalf("could not decode %s event: %v", eventType, err)
+			}
+			return
+		}
+	}
+}
+
+// drain reads the events left in a channel, and returns them and whether the channel is still open
+func drain(events <-chan Event) ([]Event, bool) {
+	drained := []Event{}
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				return drained, false
+			}
+			drained = append(drained, event)
+		default:
+			return drained, true
+		}
+	}
+}
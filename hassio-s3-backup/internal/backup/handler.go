@@ -2,11 +2,18 @@ package backup
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hassio-proton-drive-backup/internal/config"
+	"io"
 	"log/slog"
 	"net/http"
+	"time"
 )
 
+// eventKeepAlive is the time between comments sent on an idle event stream
+const eventKeepAlive = 15 * time.Second
+
 // backupHandler is a router for backup-related routes.
 type backupHandler struct {
 	backupService *Service
@@ -14,7 +21,13 @@ type backupHandler struct {
 
 // backupRequest represents the expected JSON structure for backup requests.
 type backupRequest struct {
-	Name string `json:"name"`
+	Name    string `json:"name"`
+	Profile string `json:"profile"`
+}
+
+// restoreRequest represents the optional JSON body of restore requests.
+type restoreRequest struct {
+	Password string `json:"password"` // Password of a protected backup, the configured one if empty
 }
 
 // newBackupHandler creates and returns a new backupHandler instance.
@@ -43,11 +56,16 @@ func (h *backupHandler) handleListBackups(w http.ResponseWriter, r *http.Request
 // handleTimerRequest handles requests to get the time until the next backup.
 func (h *backupHandler) handleTimerRequest(w http.ResponseWriter, r *http.Request) {
 	milliseconds := h.backupService.TimeUntilNextBackup()
+	next, profile := h.backupService.NextBackup()
 
 	response := struct {
-		Milliseconds int64 `json:"milliseconds"`
+		Milliseconds int64     `json:"milliseconds"`
+		NextBackup   time.Time `json:"nextBackup"`
+		Profile      string    `json:"profile"`
 	}{
 		Milliseconds: milliseconds,
+		NextBackup:   next,
+		Profile:      profile,
 	}
 
 	jsonBytes, err := json.Marshal(response)
@@ -60,6 +78,31 @@ func (h *backupHandler) handleTimerRequest(w http.ResponseWriter, r *http.Reques
 	w.Write(jsonBytes)
 }
 
+// handleRetentionPreviewRequest handles requests to show what retention would delete with a new config.
+func (h *backupHandler) handleRetentionPreviewRequest(w http.ResponseWriter, r *http.Request) {
+	var requestBody config.Options
+
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	deletions, err := h.backupService.PreviewRetention(requestBody)
+	if err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	jsonData, err := json.Marshal(deletions)
+	if err != nil {
+		handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
 // handleBackupRequest handles requests to perform a backup.
 func (h *backupHandler) handleBackupRequest(w http.ResponseWriter, r *http.Request) {
 	var requestBody backupRequest
@@ -70,59 +113,203 @@ func (h *backupHandler) handleBackupRequest(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if h.backupService.NameExists(requestBody.Name) {
+	if h.backupService.NameExists(requestBody.Name, requestBody.Profile) {
 		handleError(w, fmt.Errorf("a backup with the name \"%s\" already exists", requestBody.Name), http.StatusBadRequest)
 		return
 	}
 
-	go func() {
-		slog.Info("backup request received", "name", requestBody.Name)
-		err := h.backupService.PerformBackup(requestBody.Name)
-		if err != nil {
-			slog.Error("error performing backup", "error", err)
-		}
-	}()
+	slog.Info("backup request received", "name", requestBody.Name, "profile", requestBody.Profile)
+	job := h.backupService.QueueBackup(requestBody.Name, requestBody.Profile)
 
-	w.WriteHeader(http.StatusAccepted)
+	writeJob(w, job, http.StatusAccepted)
 }
 
 // handleDeleteBackupRequest handles requests to delete a backup.
 func (h *backupHandler) handleDeleteBackupRequest(w http.ResponseWriter, r *http.Request) {
+	h.queueOperation(w, r, OperationDelete)
+}
+
+// handleRestoreBackupRequest handles requests to restore a backup.
+func (h *backupHandler) handleRestoreBackupRequest(w http.ResponseWriter, r *http.Request) {
+	var requestBody restoreRequest
+
+	// The body is optional, without it the configured password is used
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
 	id := r.PathValue("id")
+	slog.Debug("received request to restore backup", "id", id)
 
-	err := h.backupService.DeleteBackup(id)
+	job, err := h.backupService.QueueRestore(id, requestBody.Password)
+	if errors.Is(err, errBackupNotFound) {
+		handleError(w, err, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		handleError(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	writeJob(w, job, http.StatusAccepted)
 }
 
-// handleRestoreBackupRequest handles requests to restore a backup.
-func (h *backupHandler) handleRestoreBackupRequest(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
+// handleListInstanceBackupsRequest handles requests to list the backups of other instances.
+func (h *backupHandler) handleListInstanceBackupsRequest(w http.ResponseWriter, r *http.Request) {
+	backups, err := h.backupService.ListInstanceBackups(r.Context())
+	if err != nil {
+		handleError(w, err, http.StatusInternalServerError)
+		return
+	}
 
-	err := h.backupService.RestoreBackup(id)
+	jsonData, err := json.Marshal(backups)
 	if err != nil {
 		handleError(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
 }
 
 // handleDownloadBackupRequest handles requests to download a backup.
 func (h *backupHandler) handleDownloadBackupRequest(w http.ResponseWriter, r *http.Request) {
+	h.queueOperation(w, r, OperationDownload)
+}
+
+// handleVerifyBackupRequest handles requests to verify a backup against its checksum.
+func (h *backupHandler) handleVerifyBackupRequest(w http.ResponseWriter, r *http.Request) {
+	h.queueOperation(w, r, OperationVerify)
+}
+
+// handleUploadBackupRequest handles requests to upload a backup to the destinations missing it.
+func (h *backupHandler) handleUploadBackupRequest(w http.ResponseWriter, r *http.Request) {
+	h.queueOperation(w, r, OperationUpload)
+}
+
+// queueOperation queues an operation on the backup in the path and responds with its job.
+func (h *backupHandler) queueOperation(w http.ResponseWriter, r *http.Request, operation Operation) {
 	id := r.PathValue("id")
+	slog.Debug("received request to queue backup operation", "operation", operation, "id", id)
 
-	err := h.backupService.DownloadBackup(id)
+	job, err := h.backupService.QueueOperation(operation, id)
+	if errors.Is(err, errBackupNotFound) {
+		handleError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	writeJob(w, job, http.StatusAccepted)
+}
+
+// handleListJobsRequest handles requests to list the queued, running and recently finished jobs.
+func (h *backupHandler) handleListJobsRequest(w http.ResponseWriter, r *http.Request) {
+	jsonData, err := json.Marshal(h.backupService.ListJobs())
 	if err != nil {
 		handleError(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// handleGetJobRequest handles requests to get a job.
+func (h *backupHandler) handleGetJobRequest(w http.ResponseWriter, r *http.Request) {
+	job, exists := h.backupService.GetJob(r.PathValue("id"))
+	if !exists {
+		handleError(w, errJobNotFound, http.StatusNotFound)
+		return
+	}
+
+	writeJob(w, job, http.StatusOK)
+}
+
+// handleCancelJobRequest handles requests to cancel a job.
+func (h *backupHandler) handleCancelJobRequest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	slog.Debug("received request to cancel job", "id", id)
+
+	err := h.backupService.CancelJob(id)
+	switch {
+	case errors.Is(err, errJobNotFound):
+		handleError(w, err, http.StatusNotFound)
+		return
+	case errors.Is(err, errJobFinished):
+		handleError(w, err, http.StatusConflict)
+		return
+	case err != nil:
+		handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// writeJob writes a job as JSON with the given status code.
+func writeJob(w http.ResponseWriter, job Job, statusCode int) {
+	jsonData, err := json.Marshal(job)
+	if err != nil {
+		handleError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(jsonData)
+}
+
+// handleEventsRequest streams the events of the backup service as Server-Sent Events until the client disconnects.
+func (h *backupHandler) handleEventsRequest(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := h.backupService.Subscribe()
+	defer unsubscribe()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keep proxies, like the ingress of Home Assistant, from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
 	w.WriteHeader(http.StatusOK)
+
+	// Send a comment right away so the response starts, then regularly so idle connections aren't closed
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	fmt.Fprint(w, ": connected\n\n")
+	if err := rc.Flush(); err != nil {
+		slog.Error("event stream can't be flushed", "error", err)
+		return
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("failed to marshal event", "type", event.Type, "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
 }
 
 // handlePinBackupRequest handles requests to pin a backup.
@@ -155,13 +342,10 @@ func (h *backupHandler) handleUnpinBackupRequest(w http.ResponseWriter, r *http.
 
 // handleResetBackupsRequest handles requests to reset backups.
 func (h *backupHandler) handleResetBackupsRequest(w http.ResponseWriter, r *http.Request) {
-	err := h.backupService.ResetBackups()
-	if err != nil {
-		handleError(w, err, http.StatusInternalServerError)
-		return
-	}
+	slog.Debug("received request to reset backups")
+	job := h.backupService.QueueReset()
 
-	w.WriteHeader(http.StatusOK)
+	writeJob(w, job, http.StatusAccepted)
 }
 
 // handleError handles errors by logging them and writing an error response to the client.
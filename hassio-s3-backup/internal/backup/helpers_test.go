@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/hassio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestService returns a service with the given config and storages, talking to an empty fake Supervisor
+// and keeping its files in temporary directories. Its scheduler and job queue are stopped when the test is done.
+func newTestService(t *testing.T, clock *fakeClock, options *config.Options, storages map[string]Storage) *Service {
+	t.Helper()
+
+	if storages == nil {
+		storages = make(map[string]Storage)
+	}
+
+	_, client := newFakeSupervisor(t)
+	scheduler := NewScheduler(clock)
+	events := newEventBus(clock)
+	s := &Service{
+		storages:       storages,
+		hassioClient:   client,
+		configService:  config.NewService(options),
+		store:          store{path: filepath.Join(t.TempDir(), "backups.json")},
+		backupDir:      t.TempDir(),
+		scheduler:      scheduler,
+		events:         events,
+		entities:       newEntityPublisher(client),
+		notifier:       newNotifier(client, func() config.NotificationOptions { return options.Notifications }),
+		webhooks:       newWebhookNotifier(func() []config.WebhookOptions { return options.Webhooks }, scheduler.Done()),
+		jobs:           newJobQueue(clock, events),
+		invalidObjects: make(map[string]struct{}),
+	}
+
+	t.Cleanup(func() {
+		s.jobs.stop(context.Background())
+		scheduler.Stop(context.Background())
+	})
+
+	return s
+}
+
+// runSync runs a sync like the queued ones do
+func runSync(t *testing.T, s *Service) {
+	t.Helper()
+
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	if err := s.syncBackups(context.Background()); err != nil {
+		t.Fatalf("syncBackups returned error: %v", err)
+	}
+}
+
+// fakeSupervisor serves the backups of the Supervisor API from memory, and accepts and records any other request
+type fakeSupervisor struct {
+	mutex    sync.Mutex
+	backups  map[string]*hassio.Backup // By slug
+	requests []supervisorRequest       // Other requests in the order they were received
+}
+
+// supervisorRequest is a request received by a fakeSupervisor
+type supervisorRequest struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// newFakeSupervisor starts a fake Supervisor that's stopped when the test is done, and returns a client for it
+func newFakeSupervisor(t *testing.T) (*fakeSupervisor, *hassio.Client) {
+	t.Helper()
+
+	f := &fakeSupervisor{backups: make(map[string]*hassio.Backup)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /backups", f.listBackups)
+	mux.HandleFunc("DELETE /backups/{slug}", f.deleteBackup)
+	mux.HandleFunc("/", f.recordRequest)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return f, hassio.NewClient(server.URL, "token")
+}
+
+// addBackup writes the tarball of a backup with the given manifest to dir and lists it as a backup in Home Assistant
+func (f *fakeSupervisor) addBackup(t *testing.T, dir string, manifest Manifest) *hassio.Backup {
+	t.Helper()
+
+	path := filepath.Join(dir, manifest.Slug+".tar")
+	if err := os.WriteFile(path, backupTarball(t, manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backup := &hassio.Backup{Slug: manifest.Slug, Name: manifest.Name, Date: manifest.Date, Type: manifest.Type, Content: manifest.content()}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.backups[backup.Slug] = backup
+
+	return backup
+}
+
+// has returns true if Home Assistant has the backup with the given slug
+func (f *fakeSupervisor) has(slug string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	_, exists := f.backups[slug]
+	return exists
+}
+
+func (f *fakeSupervisor) listBackups(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	backups := []*hassio.Backup{}
+	for _, backup := range f.backups {
+		backups = append(backups, backup)
+	}
+	f.mutex.Unlock()
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Slug < backups[j].Slug
+	})
+
+	writeSupervisorResponse(w, map[string]interface{}{"backups": backups})
+}
+
+func (f *fakeSupervisor) recordRequest(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mutex.Lock()
+	f.requests = append(f.requests, supervisorRequest{Method: r.Method, Path: r.URL.Path, Body: body})
+	f.mutex.Unlock()
+
+	writeSupervisorResponse(w, nil)
+}
+
+// received returns the recorded requests to the given path
+func (f *fakeSupervisor) received(path string) []supervisorRequest {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	requests := []supervisorRequest{}
+	for _, request := range f.requests {
+		if request.Path == path {
+			requests = append(requests, request)
+		}
+	}
+
+	return requests
+}
+
+// waitForRequests waits until the given number of requests to the path were received and returns them
+func (f *fakeSupervisor) waitForRequests(t *testing.T, path string, count int) []supervisorRequest {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		requests := f.received(path)
+		if len(requests) >= count {
+			return requests
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("received %d requests to %s, want %d", len(requests), path, count)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (f *fakeSupervisor) deleteBackup(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	delete(f.backups, r.PathValue("slug"))
+	f.mutex.Unlock()
+
+	writeSupervisorResponse(w, nil)
+}
+
+// writeSupervisorResponse writes a successful response of the Supervisor with the given data
+func writeSupervisorResponse(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hassio.BaseResponse{Result: "ok", Data: data})
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
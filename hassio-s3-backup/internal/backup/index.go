@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"log/slog"
+)
+
+// backupIndex matches backups in Home Assistant and remote objects to the backups being tracked,
+// and collects where they were found. The sync releases the lock to list and look up backups, so the tracked
+// backups keep where they were found before until the sync applies the new locations all at once.
+type backupIndex struct {
+	bySlug  map[string]*Backup
+	byName  map[string][]*Backup
+	byKey   map[string]*Backup // Objects found in the previous sync by destination and key
+	ha      map[*Backup]*hassio.Backup
+	remotes map[*Backup]map[string]*s3.Object
+}
+
+// newBackupIndex indexes the tracked backups by where they were found in the previous sync
+func newBackupIndex(backups []*Backup) *backupIndex {
+	index := &backupIndex{
+		bySlug:  make(map[string]*Backup),
+		byName:  make(map[string][]*Backup),
+		byKey:   make(map[string]*Backup),
+		ha:      make(map[*Backup]*hassio.Backup),
+		remotes: make(map[*Backup]map[string]*s3.Object),
+	}
+
+	for _, backup := range backups {
+		index.add(backup)
+
+		for destination, remote := range backup.Remotes {
+			index.byKey[destination+"/"+remote.Key] = backup
+		}
+	}
+
+	return index
+}
+
+// add indexes a backup by slug and name, it's safe to add a backup again once its slug is known
+func (i *backupIndex) add(backup *Backup) {
+	if backup.Slug != "" {
+		i.bySlug[backup.Slug] = backup
+	}
+
+	for _, b := range i.byName[backup.Name] {
+		if b == backup {
+			return
+		}
+	}
+	i.byName[backup.Name] = append(i.byName[backup.Name], backup)
+}
+
+// foundInHA records the Home Assistant backup of a tracked backup
+func (i *backupIndex) foundInHA(backup *Backup, haBackup *hassio.Backup) {
+	i.ha[backup] = haBackup
+}
+
+// foundInDestination records the object of a tracked backup in a destination
+func (i *backupIndex) foundInDestination(backup *Backup, destination string, object *s3.Object) {
+	if i.remotes[backup] == nil {
+		i.remotes[backup] = make(map[string]*s3.Object)
+	}
+	i.remotes[backup][destination] = object
+}
+
+// apply sets where each indexed backup was found, backups that weren't found anywhere lose their copies.
+// Backups tracked while the sync ran aren't indexed and keep theirs. The lock of the store must be held.
+func (i *backupIndex) apply() {
+	for _, backups := range i.byName {
+		for _, backup := range backups {
+			backup.HA = i.ha[backup]
+			backup.Remotes = i.remotes[backup]
+			if backup.Remotes == nil {
+				backup.Remotes = make(map[string]*s3.Object)
+			}
+		}
+	}
+}
+
+// match returns the backup with the given slug, falling back to the name for backups tracked or uploaded
+// before slugs were recorded. Backups with different slugs are never matched, even if they share a name.
+func (i *backupIndex) match(slug string, name string) *Backup {
+	if backup, exists := i.bySlug[slug]; exists && slug != "" {
+		return backup
+	}
+
+	for _, backup := range i.byName[name] {
+		if slug == "" || backup.Slug == "" {
+			return backup
+		}
+	}
+
+	return nil
+}
+
+// matchObject returns the backup a remote object belongs to and the slug recorded with the object
+func (s *Service) matchObject(ctx context.Context, index *backupIndex, destination string, object *s3.Object) (*Backup, string) {
+	if backup, exists := index.byKey[destination+"/"+object.Key]; exists {
+		return backup, backup.Slug
+	}
+
+	// Listing doesn't return the metadata of objects, so new objects are looked up one by one
+	name, keySlug := parseObjectKey(object.Key)
+	slug := ""
+	var attributes *s3.Object
+	var err error
+	s.store.unlocked(func() {
+		attributes, err = s.storages[destination].Stat(ctx, object.Key)
+	})
+	if err != nil {
+		// The slug in the key is only a fallback, renamed objects keep the slug of their metadata
+		slog.Warn("could not read object metadata, matching by key", "key", object.Key, "destination", destination, "error", err)
+		slug = keySlug
+	} else {
+		slug = attributes.Metadata[slugMetadata]
+		object.Metadata = attributes.Metadata
+	}
+
+	return index.match(slug, name), slug
+}
+
+// newBackupID returns an ID based on the backup name that isn't used by any other backup
+func (s *Service) newBackupID(name string) string {
+	id := base64.RawURLEncoding.EncodeToString([]byte(name))
+
+	for n := 2; ; n++ {
+		if _, backup := s.store.get(id); backup == nil {
+			return id
+		}
+
+		id = base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s#%d", name, n)))
+	}
+}
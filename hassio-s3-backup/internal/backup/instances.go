@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"hassio-proton-drive-backup/internal/crypt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InstanceBackup is a backup of another instance sharing a destination, it's only listed and never changed
+type InstanceBackup struct {
+	Instance    string    `json:"instance"`
+	Destination string    `json:"destination"`
+	Name        string    `json:"name"`
+	Key         string    `json:"key"`
+	Slug        string    `json:"slug,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	Date        time.Time `json:"date"`
+	Size        float64   `json:"size"`
+}
+
+// instanceCache holds the metadata of the objects of other instances, so each object is only looked up once
+type instanceCache struct {
+	mutex    sync.Mutex
+	metadata map[string]map[string]string // By instance, destination, key and modification time
+}
+
+// ListInstanceBackups returns the backups of the other instances whose key prefixes are configured to be viewed.
+// Listing doesn't return the metadata of objects, so it's looked up for new objects and cached.
+func (s *Service) ListInstanceBackups(ctx context.Context) ([]InstanceBackup, error) {
+	s.instanceCache.mutex.Lock()
+	defer s.instanceCache.mutex.Unlock()
+
+	backups := []InstanceBackup{}
+	metadata := make(map[string]map[string]string)
+
+	for prefix, storages := range s.instances {
+		for _, d := range s.config().Destinations {
+			storage, exists := storages[d.Name]
+			if !exists {
+				continue
+			}
+
+			objects, err := storage.List(ctx)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				slog.Warn("could not list backups of other instance", "instance", prefix, "destination", d.Name, "error", err)
+				continue
+			}
+
+			for _, object := range objects {
+				if !strings.HasSuffix(object.Key, ".tar") && !strings.HasSuffix(object.Key, ".tar"+crypt.Suffix) {
+					continue
+				}
+
+				name, _ := parseObjectKey(object.Key)
+				backup := InstanceBackup{
+					Instance:    strings.TrimSuffix(prefix, "/"),
+					Destination: d.Name,
+					Name:        name,
+					Key:         object.Key,
+					Date:        object.Modified,
+					Size:        object.Size,
+				}
+
+				id := fmt.Sprintf("%s%s/%s@%s", prefix, d.Name, object.Key, object.Modified)
+				attributes, cached := s.instanceCache.metadata[id]
+				if !cached {
+					stat, err := storage.Stat(ctx, object.Key)
+					if ctx.Err() != nil {
+						return nil, ctx.Err()
+					}
+					if err == nil {
+						attributes, cached = stat.Metadata, true
+					}
+				}
+
+				// Objects uploaded with metadata have the slug and date of the backup
+				if cached {
+					metadata[id] = attributes
+					backup.Slug = attributes[slugMetadata]
+					backup.Type = attributes[typeMetadata]
+					backup.Name = strings.TrimSuffix(backup.Name, "_"+backup.Slug)
+					if date, err := time.Parse(time.RFC3339, attributes[dateMetadata]); err == nil {
+						backup.Date = date.In(s.config().Timezone)
+					}
+				}
+
+				backups = append(backups, backup)
+			}
+		}
+	}
+
+	// Objects that are gone are forgotten
+	s.instanceCache.metadata = metadata
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Date.After(backups[j].Date)
+	})
+
+	return backups, nil
+}
@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"hassio-proton-drive-backup/internal/config"
+	"testing"
+	"time"
+)
+
+func TestSyncIgnoresOtherInstances(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	bucket := newMemoryStorage(clock)
+	kitchenStorage := bucket.withPrefix("kitchen/")
+	options := &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3, Retention: config.Retention{MaxAge: 4}}},
+	}
+
+	// The other instance has a backup with the same key as the one of this instance, and one past the age limit
+	kitchenObjects := map[string]Manifest{
+		"Full Backup_aaaa1111.tar": {Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now().AddDate(0, 0, -1), Type: "full"},
+		"Kitchen_cccc3333.tar":     {Slug: "cccc3333", Name: "Kitchen", Date: clock.Now().AddDate(0, 0, -2), Type: "full"},
+		"Kitchen_dddd4444.tar":     {Slug: "dddd4444", Name: "Kitchen", Date: clock.Now().AddDate(0, 0, -6), Type: "full"},
+	}
+	for key, manifest := range kitchenObjects {
+		kitchenStorage.putObject(key, backupTarball(t, manifest), clock.Now(), map[string]string{slugMetadata: manifest.Slug})
+	}
+	bucket.putObject("Old_bbbb2222.tar", backupTarball(t, Manifest{Slug: "bbbb2222", Name: "Old", Date: clock.Now().AddDate(0, 0, -5), Type: "full"}), clock.Now(), nil)
+
+	s := newTestService(t, clock, options, map[string]Storage{"s3": bucket})
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+	supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now(), Type: "full"})
+
+	runSync(t, s)
+
+	// Only the objects directly under the key prefix of this instance are tracked, uploaded to and deleted
+	tracked := make(map[string]string)
+	for _, backup := range s.store.backups {
+		for _, remote := range backup.Remotes {
+			tracked[backup.Slug] = remote.Key
+		}
+	}
+	if len(tracked) != 1 || tracked["aaaa1111"] != "Full Backup_aaaa1111.tar" {
+		t.Errorf("tracking %v in s3, want only the backup of this instance", tracked)
+	}
+	if len(bucket.deleted) != 1 || bucket.deleted[0] != "Old_bbbb2222.tar" {
+		t.Errorf("deleted %v, want only the old backup of this instance", bucket.deleted)
+	}
+
+	data, err := kitchenStorage.data("Full Backup_aaaa1111.tar")
+	if err != nil || string(data) != string(backupTarball(t, kitchenObjects["Full Backup_aaaa1111.tar"])) {
+		t.Errorf("backup of the other instance with the same key was changed, error: %v", err)
+	}
+
+	// The other instance doesn't see the backups of this one either, retention only deletes its own
+	kitchen := newTestService(t, clock, options, map[string]Storage{"s3": kitchenStorage})
+	runSync(t, kitchen)
+
+	if len(kitchen.store.backups) != 2 {
+		t.Errorf("other instance tracks %d backups, want its 2 backups within the age limit", len(kitchen.store.backups))
+	}
+	if len(kitchenStorage.deleted) != 1 || kitchenStorage.deleted[0] != "Kitchen_dddd4444.tar" {
+		t.Errorf("other instance deleted %v, want only its backup past the age limit", kitchenStorage.deleted)
+	}
+	for _, key := range []string{"Full Backup_aaaa1111.tar", "kitchen/Full Backup_aaaa1111.tar"} {
+		if _, exists := bucket.objects[key]; !exists {
+			t.Errorf("%s was deleted from the bucket", key)
+		}
+	}
+}
+
+func TestListInstanceBackups(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	bucket := newMemoryStorage(clock)
+	kitchenStorage := bucket.withPrefix("kitchen/")
+	options := &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+	}
+	s := newTestService(t, clock, options, map[string]Storage{"s3": bucket})
+	s.instances = map[string]map[string]ViewStorage{"kitchen/": {"s3": kitchenStorage}}
+
+	date := clock.Now().AddDate(0, 0, -1)
+	kitchenStorage.putObject("Kitchen_cccc3333.tar", []byte("backup"), clock.Now(), map[string]string{
+		slugMetadata: "cccc3333",
+		typeMetadata: "full",
+		dateMetadata: date.Format(time.RFC3339),
+	})
+	kitchenStorage.putObject("Legacy.tar", []byte("backup"), clock.Now().AddDate(0, 0, -2), nil)
+	kitchenStorage.putObject("notes.txt", []byte("notes"), clock.Now(), nil)
+	kitchenStorage.withPrefix("garage/").putObject("Garage_eeee5555.tar", []byte("backup"), clock.Now(), nil)
+	bucket.putObject("Own_aaaa1111.tar", []byte("backup"), clock.Now(), nil)
+
+	backups, err := s.ListInstanceBackups(context.Background())
+	if err != nil {
+		t.Fatalf("ListInstanceBackups returned error: %v", err)
+	}
+
+	// Only backups directly under the prefix are listed, with the details from their metadata
+	if len(backups) != 2 {
+		t.Fatalf("listed %+v, want the 2 backups of the other instance", backups)
+	}
+	if b := backups[0]; b.Instance != "kitchen" || b.Name != "Kitchen" || b.Slug != "cccc3333" || b.Type != "full" || !b.Date.Equal(date) {
+		t.Errorf("listed %+v, want the details from the metadata of the backup", b)
+	}
+	if b := backups[1]; b.Name != "Legacy" || b.Slug != "" || !b.Date.Equal(clock.Now().AddDate(0, 0, -2)) {
+		t.Errorf("listed %+v, want the name from the key and the modification date of the object", b)
+	}
+	if kitchenStorage.stats != 2 {
+		t.Errorf("looked up metadata %d times, want once for each backup", kitchenStorage.stats)
+	}
+
+	// The metadata is cached until an object changes or is gone
+	if _, err := s.ListInstanceBackups(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if kitchenStorage.stats != 2 {
+		t.Errorf("looked up metadata %d times after listing again, want the cached metadata used", kitchenStorage.stats)
+	}
+
+	clock.Advance(time.Hour)
+	kitchenStorage.putObject("Legacy.tar", []byte("replaced"), clock.Now(), nil)
+	if err := kitchenStorage.Delete(context.Background(), "Kitchen_cccc3333.tar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ListInstanceBackups(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if kitchenStorage.stats != 3 {
+		t.Errorf("looked up metadata %d times after an object was replaced, want it looked up again", kitchenStorage.stats)
+	}
+	if len(s.instanceCache.metadata) != 1 {
+		t.Errorf("cached metadata of %d objects, want the deleted object forgotten", len(s.instanceCache.metadata))
+	}
+
+	// Metadata that can't be read isn't cached, so it's looked up again next time
+	clock.Advance(time.Hour)
+	kitchenStorage.putObject("Kitchen_ffff6666.tar", []byte("backup"), clock.Now(), map[string]string{slugMetadata: "ffff6666"})
+	kitchenStorage.statErr = errors.New("access denied")
+	backups, err = s.ListInstanceBackups(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Name != "Kitchen" || backups[0].Slug != "" {
+		t.Errorf("listed %+v without metadata, want the name from the key", backups)
+	}
+
+	kitchenStorage.statErr = nil
+	backups, err = s.ListInstanceBackups(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Name != "Kitchen" || backups[0].Slug != "ffff6666" {
+		t.Errorf("listed %+v once the metadata can be read, want the details from the metadata", backups)
+	}
+}
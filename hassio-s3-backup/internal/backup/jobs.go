@@ -0,0 +1,388 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Operation is what a job does
+type Operation string
+
+const (
+	OperationCreate   Operation = "create"   // Create a backup in Home Assistant and upload it
+	OperationUpload   Operation = "upload"   // Upload a backup to the destinations missing it
+	OperationDownload Operation = "download" // Download a backup from a destination to Home Assistant
+	OperationDelete   Operation = "delete"   // Delete a backup everywhere
+	OperationVerify   Operation = "verify"   // Verify a backup against its checksum
+	OperationRestore  Operation = "restore"  // Restore Home Assistant to a backup
+	OperationSync     Operation = "sync"     // Sync with Home Assistant and the destinations, retrying failed backups
+	OperationReset    Operation = "reset"    // Forget the tracked backups and sync them again
+)
+
+// jobState is the state of a job
+type jobState string
+
+const (
+	JobQueued    jobState = "QUEUED"    // Job is waiting for the jobs before it
+	JobRunning   jobState = "RUNNING"   // Job is running
+	JobSucceeded jobState = "SUCCEEDED" // Job is done
+	JobFailed    jobState = "FAILED"    // Job returned an error
+	JobCancelled jobState = "CANCELLED" // Job was cancelled before it was done
+)
+
+// maxFinishedJobs is how many finished jobs are kept to be listed
+const maxFinishedJobs = 50
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobFinished = errors.New("job is already finished")
+)
+
+// Job is an operation run by the job queue
+type Job struct {
+	ID        string     `json:"id"`
+	Operation Operation  `json:"operation"`
+	BackupID  string     `json:"backupId,omitempty"` // Backup the job operates on, set once it's known for new backups
+	State     jobState   `json:"state"`
+	Error     string     `json:"error,omitempty"`
+	Created   time.Time  `json:"created"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+
+	run    func(ctx context.Context) error
+	cancel context.CancelFunc
+}
+
+// finished returns true if the job won't run anymore
+func (j *Job) finished() bool {
+	return j.State != JobQueued && j.State != JobRunning
+}
+
+// jobKey is the context key of the ID of the running job
+type jobKey struct{}
+
+// jobQueue runs jobs one at a time in the order they were queued
+type jobQueue struct {
+	clock   Clock
+	events  *eventBus
+	mutex   sync.Mutex
+	wake    *sync.Cond
+	jobs    []*Job // Oldest first
+	nextID  int
+	stopped bool
+	done    chan struct{} // Closed once the worker returns
+}
+
+// newJobQueue returns a job queue publishing the changes of its jobs, and starts its worker
+func newJobQueue(clock Clock, events *eventBus) *jobQueue {
+	q := &jobQueue{
+		clock:  clock,
+		events: events,
+		done:   make(chan struct{}),
+	}
+	q.wake = sync.NewCond(&q.mutex)
+
+	go q.work()
+
+	return q
+}
+
+// add queues a job running f and returns a copy of it
+func (q *jobQueue) add(operation Operation, backupID string, f func(ctx context.Context) error) Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.nextID++
+	job := &Job{
+		ID:        strconv.Itoa(q.nextID),
+		Operation: operation,
+		BackupID:  backupID,
+		State:     JobQueued,
+		Created:   q.clock.Now(),
+		run:       f,
+	}
+
+	if q.stopped {
+		job.State = JobCancelled
+		job.Finished = &job.Created
+	}
+
+	q.jobs = append(q.jobs, job)
+	q.prune()
+	q.wake.Signal()
+	q.events.publish(EventJob, *job)
+
+	slog.Debug("job queued", "id", job.ID, "operation", operation, "backup", backupID)
+	return *job
+}
+
+// list returns copies of the queued, running and recently finished jobs
+func (q *jobQueue) list() []Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	jobs := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, *job)
+	}
+
+	return jobs
+}
+
+// get returns a copy of the job with the given ID
+func (q *jobQueue) get(id string) (Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if job := q.find(id); job != nil {
+		return *job, true
+	}
+
+	return Job{}, false
+}
+
+// cancel cancels a queued job, or the context of a running one
+func (q *jobQueue) cancel(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job := q.find(id)
+	if job == nil {
+		return errJobNotFound
+	}
+
+	switch job.State {
+	case JobQueued:
+		now := q.clock.Now()
+		job.State = JobCancelled
+		job.Finished = &now
+		q.events.publish(EventJob, *job)
+		slog.Info("job cancelled", "id", job.ID, "operation", job.Operation)
+	case JobRunning:
+		job.cancel()
+		slog.Info("cancelling job", "id", job.ID, "operation", job.Operation)
+	default:
+		return errJobFinished
+	}
+
+	return nil
+}
+
+// setBackup records the backup a running job operates on, for operations creating a new backup
+func (q *jobQueue) setBackup(ctx context.Context, backupID string) {
+	id, ok := ctx.Value(jobKey{}).(string)
+	if !ok {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if job := q.find(id); job != nil {
+		job.BackupID = backupID
+		q.events.publish(EventJob, *job)
+	}
+}
+
+// stop cancels the queued and running jobs and waits for the running one to return or the context to be done
+func (q *jobQueue) stop(ctx context.Context) error {
+	q.mutex.Lock()
+	if !q.stopped {
+		q.stopped = true
+		now := q.clock.Now()
+
+		for _, job := range q.jobs {
+			switch job.State {
+			case JobQueued:
+				job.State = JobCancelled
+				job.Finished = &now
+				q.events.publish(EventJob, *job)
+			case JobRunning:
+				job.cancel()
+			}
+		}
+
+		q.wake.Broadcast()
+	}
+	q.mutex.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// work runs queued jobs until the queue is stopped
+func (q *jobQueue) work() {
+	defer close(q.done)
+
+	for {
+		job, ctx := q.next()
+		if job == nil {
+			return
+		}
+
+		slog.Debug("job started", "id", job.ID, "operation", job.Operation)
+		err := job.run(ctx)
+		job.cancel()
+
+		q.mutex.Lock()
+		now := q.clock.Now()
+		job.Finished = &now
+
+		switch {
+		case err == nil:
+			job.State = JobSucceeded
+		case errors.Is(err, context.Canceled):
+			job.State = JobCancelled
+			job.Error = err.Error()
+		default:
+			job.State = JobFailed
+			job.Error = err.Error()
+		}
+		q.events.publish(EventJob, *job)
+		q.prune()
+		q.mutex.Unlock()
+
+		slog.Debug("job finished", "id", job.ID, "operation", job.Operation, "state", job.State)
+	}
+}
+
+// next waits for the oldest queued job and marks it as running, or returns nil once the queue is stopped.
+// The returned context is cancelled when the job is.
+func (q *jobQueue) next() (*Job, context.Context) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for !q.stopped {
+		for _, job := range q.jobs {
+			if job.State != JobQueued {
+				continue
+			}
+
+			ctx, cancel := context.WithCancel(context.WithValue(context.Background(), jobKey{}, job.ID))
+			now := q.clock.Now()
+			job.State = JobRunning
+			job.Started = &now
+			job.cancel = cancel
+			q.events.publish(EventJob, *job)
+
+			return job, ctx
+		}
+
+		q.wake.Wait()
+	}
+
+	return nil, nil
+}
+
+// find returns the job with the given ID. The mutex must be held.
+func (q *jobQueue) find(id string) *Job {
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+
+	return nil
+}
+
+// prune forgets the oldest finished jobs beyond the number kept. The mutex must be held.
+func (q *jobQueue) prune() {
+	finished := 0
+	for _, job := range q.jobs {
+		if job.finished() {
+			finished++
+		}
+	}
+
+	jobs := q.jobs[:0]
+	for _, job := range q.jobs {
+		if job.finished() && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+
+	q.jobs = jobs
+}
+
+// ListJobs returns the queued, running and recently finished jobs, oldest first
+func (s *Service) ListJobs() []Job {
+	return s.jobs.list()
+}
+
+// GetJob returns the job with the given ID
+func (s *Service) GetJob(id string) (Job, bool) {
+	return s.jobs.get(id)
+}
+
+// CancelJob cancels a queued or running job
+func (s *Service) CancelJob(id string) error {
+	return s.jobs.cancel(id)
+}
+
+// QueueBackup queues the creation of a backup using the given profile
+func (s *Service) QueueBackup(name string, profileName string) Job {
+	return s.jobs.add(OperationCreate, "", func(ctx context.Context) error {
+		return s.PerformBackup(ctx, name, profileName)
+	})
+}
+
+// QueueReset queues a reset of the tracked backups
+func (s *Service) QueueReset() Job {
+	return s.jobs.add(OperationReset, "", s.ResetBackups)
+}
+
+// QueueOperation queues an operation on an existing backup
+func (s *Service) QueueOperation(operation Operation, id string) (Job, error) {
+	s.store.RLock()
+	_, backup := s.store.get(id)
+	s.store.RUnlock()
+
+	if backup == nil {
+		return Job{}, errBackupNotFound
+	}
+
+	var run func(ctx context.Context, id string) error
+	switch operation {
+	case OperationUpload:
+		run = s.UploadBackup
+	case OperationDownload:
+		run = s.DownloadBackup
+	case OperationDelete:
+		run = s.DeleteBackup
+	case OperationVerify:
+		run = s.VerifyBackup
+	default:
+		return Job{}, fmt.Errorf("operation %q can't be queued for a backup", operation)
+	}
+
+	return s.jobs.add(operation, id, func(ctx context.Context) error {
+		return run(ctx, id)
+	}), nil
+}
+
+// QueueRestore queues a restore of Home Assistant to a backup.
+// Protected backups are restored with the given password, or the configured one if it's empty.
+func (s *Service) QueueRestore(id string, password string) (Job, error) {
+	s.store.RLock()
+	_, backup := s.store.get(id)
+	s.store.RUnlock()
+
+	if backup == nil {
+		return Job{}, errBackupNotFound
+	}
+
+	return s.jobs.add(OperationRestore, id, func(ctx context.Context) error {
+		return s.RestoreBackup(ctx, id, password)
+	}), nil
+}
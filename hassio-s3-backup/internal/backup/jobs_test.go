@@ -0,0 +1,149 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobQueueRunsJobsInOrder(t *testing.T) {
+	q := newTestJobQueue(t)
+
+	ran := make(chan string, 3)
+	for _, name := range []string{"first", "second", "third"} {
+		q.add(OperationSync, "", func(ctx context.Context) error {
+			ran <- name
+			return nil
+		})
+	}
+
+	for _, want := range []string{"first", "second", "third"} {
+		if got := <-ran; got != want {
+			t.Fatalf("ran %q, want %q", got, want)
+		}
+	}
+}
+
+func TestJobQueueStates(t *testing.T) {
+	q := newTestJobQueue(t)
+
+	succeeded := q.add(OperationSync, "", func(ctx context.Context) error { return nil })
+	failed := q.add(OperationVerify, "backup", func(ctx context.Context) error { return errors.New("checksum mismatch") })
+
+	waitForJob(t, q, succeeded.ID, JobSucceeded)
+	job := waitForJob(t, q, failed.ID, JobFailed)
+	if job.Error != "checksum mismatch" || job.BackupID != "backup" {
+		t.Errorf("failed job has error %q and backup %q", job.Error, job.BackupID)
+	}
+	if job.Started == nil || job.Finished == nil {
+		t.Errorf("failed job has no start or finish time")
+	}
+
+	if err := q.cancel(succeeded.ID); !errors.Is(err, errJobFinished) {
+		t.Errorf("cancelling a finished job returned %v, want %v", err, errJobFinished)
+	}
+	if err := q.cancel("unknown"); !errors.Is(err, errJobNotFound) {
+		t.Errorf("cancelling an unknown job returned %v, want %v", err, errJobNotFound)
+	}
+}
+
+func TestJobQueueCancel(t *testing.T) {
+	q := newTestJobQueue(t)
+
+	started := make(chan struct{})
+	running := q.add(OperationUpload, "running", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	queuedRan := false
+	queued := q.add(OperationDownload, "queued", func(ctx context.Context) error {
+		queuedRan = true
+		return nil
+	})
+
+	<-started
+
+	if err := q.cancel(queued.ID); err != nil {
+		t.Fatalf("cancelling the queued job returned error: %v", err)
+	}
+	waitForJob(t, q, queued.ID, JobCancelled)
+
+	if err := q.cancel(running.ID); err != nil {
+		t.Fatalf("cancelling the running job returned error: %v", err)
+	}
+	waitForJob(t, q, running.ID, JobCancelled)
+
+	// Jobs queued after the cancelled ones still run
+	next := q.add(OperationSync, "", func(ctx context.Context) error { return nil })
+	waitForJob(t, q, next.ID, JobSucceeded)
+
+	if queuedRan {
+		t.Error("cancelled job ran")
+	}
+}
+
+func TestJobQueueStop(t *testing.T) {
+	q := newJobQueue(SystemClock, newEventBus(SystemClock))
+
+	started := make(chan struct{})
+	running := q.add(OperationCreate, "", func(ctx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	queued := q.add(OperationDelete, "backup", func(ctx context.Context) error { return nil })
+
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.stop(ctx); err != nil {
+		t.Fatalf("stop returned error: %v", err)
+	}
+
+	for _, id := range []string{running.ID, queued.ID} {
+		if job, _ := q.get(id); job.State != JobCancelled {
+			t.Errorf("job %s is %s after stopping, want %s", id, job.State, JobCancelled)
+		}
+	}
+
+	if job := q.add(OperationSync, "", func(ctx context.Context) error { return nil }); job.State != JobCancelled {
+		t.Errorf("job queued after stopping is %s, want %s", job.State, JobCancelled)
+	}
+}
+
+// newTestJobQueue returns a job queue that's stopped when the test is done
+func newTestJobQueue(t *testing.T) *jobQueue {
+	t.Helper()
+
+	q := newJobQueue(SystemClock, newEventBus(SystemClock))
+	t.Cleanup(func() {
+		q.stop(context.Background())
+	})
+
+	return q
+}
+
+// waitForJob waits for a job to reach the given state and returns it
+func waitForJob(t *testing.T, q *jobQueue, id string, state jobState) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, exists := q.get(id)
+		if !exists {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.State == state {
+			return job
+		}
+		if job.finished() || time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.State, state)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,265 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hassio-proton-drive-backup/internal/crypt"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"hassio-proton-drive-backup/internal/throttle"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// manifestFile is the name of the file describing a Home Assistant backup inside its tarball
+const manifestFile = "backup.json"
+
+// errInvalidBackup is returned for tarballs that aren't valid Home Assistant backups
+var errInvalidBackup = errors.New("not a valid home assistant backup")
+
+// Manifest is the content of the backup.json file inside a Home Assistant backup
+type Manifest struct {
+	Slug          string                 `json:"slug"`
+	Name          string                 `json:"name"`
+	Date          time.Time              `json:"date"`
+	Type          string                 `json:"type"`
+	Compressed    bool                   `json:"compressed"`
+	Protected     bool                   `json:"protected"`
+	HomeAssistant *ManifestHomeAssistant `json:"homeassistant"`
+	Addons        []ManifestAddon        `json:"addons"`
+	Folders       []string               `json:"folders"`
+}
+
+// ManifestHomeAssistant describes the Home Assistant configuration in a backup
+type ManifestHomeAssistant struct {
+	Version string `json:"version"`
+}
+
+// ManifestAddon describes an add-on in a backup
+type ManifestAddon struct {
+	Slug    string `json:"slug"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// content returns what the backup contains in the same form Home Assistant reports it
+func (m *Manifest) content() hassio.Content {
+	content := hassio.Content{
+		HomeAssistant: m.HomeAssistant != nil,
+		Addons:        []string{},
+		Folders:       m.Folders,
+	}
+
+	for _, addon := range m.Addons {
+		content.Addons = append(content.Addons, addon.Slug)
+	}
+
+	return content
+}
+
+// readManifest reads the manifest of a backup tarball and checks that every part it lists is in the tarball
+func readManifest(r io.Reader) (*Manifest, error) {
+	var manifest *Manifest
+	entries := make(map[string]bool)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if manifest == nil && len(entries) == 0 {
+				return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+			}
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		entries[name] = true
+
+		if name != manifestFile {
+			continue
+		}
+
+		manifest = &Manifest{}
+		if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+			return nil, fmt.Errorf("%w: invalid %s: %v", errInvalidBackup, manifestFile, err)
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: %s is missing", errInvalidBackup, manifestFile)
+	}
+
+	if err := manifest.validate(entries); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+
+	return manifest, nil
+}
+
+// validate checks the manifest against the entries of the tarball
+func (m *Manifest) validate(entries map[string]bool) error {
+	if m.Slug == "" {
+		return fmt.Errorf("%s has no slug", manifestFile)
+	}
+
+	if m.Type != "full" && m.Type != "partial" {
+		return fmt.Errorf("unknown backup type %q", m.Type)
+	}
+
+	extension := ".tar"
+	if m.Compressed {
+		extension = ".tar.gz"
+	}
+
+	if m.HomeAssistant != nil {
+		if m.HomeAssistant.Version == "" {
+			return fmt.Errorf("%s has no home assistant version", manifestFile)
+		}
+		if !entries["homeassistant"+extension] {
+			return fmt.Errorf("home assistant %s is missing", m.HomeAssistant.Version)
+		}
+	}
+
+	for _, addon := range m.Addons {
+		if !entries[addon.Slug+extension] {
+			return fmt.Errorf("add-on %s is missing", addon.Slug)
+		}
+	}
+
+	for _, folder := range m.Folders {
+		if !entries[strings.ReplaceAll(folder, "/", "_")+extension] {
+			return fmt.Errorf("folder %s is missing", folder)
+		}
+	}
+
+	return nil
+}
+
+// inspectFile reads the manifest of a backup tarball on disk
+func inspectFile(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readManifest(file)
+}
+
+// inspectRemote reads the manifest of a backup in a destination.
+// Unencrypted tarballs are read by seeking past the content, encrypted ones have to be read in full.
+func (s *Service) inspectRemote(ctx context.Context, destination string, object *s3.Object) (*Manifest, error) {
+	r, err := s.storages[destination].Get(ctx, object.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var reader io.Reader = r
+	if strings.HasSuffix(object.Key, crypt.Suffix) {
+		secret, err := s.config().Encryption.Secret()
+		if err != nil {
+			return nil, err
+		}
+
+		reader, err = crypt.NewReader(throttle.NewReader(ctx, r, s.config().TransferLimit()), secret)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+		}
+	}
+
+	manifest, err := readManifest(reader)
+	if errors.Is(err, crypt.ErrAuthentication) || errors.Is(err, crypt.ErrInvalidFormat) {
+		return nil, fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+
+	return manifest, err
+}
+
+// isBackupObject checks if a remote object is a Home Assistant backup, reading its manifest if it's untracked
+func (s *Service) isBackupObject(ctx context.Context, destination string, object *s3.Object) (*Manifest, bool) {
+	if !strings.HasSuffix(object.Key, ".tar") && !strings.HasSuffix(object.Key, ".tar"+crypt.Suffix) {
+		return nil, false
+	}
+
+	id := fmt.Sprintf("%s/%s@%s", destination, object.Key, object.Modified)
+	if _, exists := s.invalidObjects[id]; exists {
+		return nil, false
+	}
+
+	var manifest *Manifest
+	var err error
+	s.store.unlocked(func() {
+		manifest, err = s.inspectRemote(ctx, destination, object)
+	})
+	if errors.Is(err, errInvalidBackup) {
+		slog.Warn("ignoring object that isn't a backup", "key", object.Key, "destination", destination, "error", err)
+		s.invalidObjects[id] = struct{}{}
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("could not inspect object in destination", "key", object.Key, "destination", destination, "error", err)
+		return nil, false
+	}
+
+	return manifest, true
+}
+
+// inspectHABackups reads the manifest of Home Assistant backups that haven't been inspected yet.
+// Backups that aren't valid are marked as failed so they're never synced.
+// The lock of the store must be held, it's released while the files are read.
+func (s *Service) inspectHABackups() {
+	paths := make(map[string]string) // By backup ID
+	for _, backup := range s.store.backups {
+		if backup.HA == nil || backup.Manifest != nil || backup.Status == StatusFailed {
+			continue
+		}
+
+		paths[backup.ID] = s.haBackupPath(backup)
+	}
+
+	for id, path := range paths {
+		var manifest *Manifest
+		var err error
+		s.store.unlocked(func() {
+			manifest, err = inspectFile(path)
+		})
+
+		// The backup may have changed while the lock was released
+		_, backup := s.store.get(id)
+		if backup == nil || backup.HA == nil || backup.Manifest != nil {
+			continue
+		}
+
+		if errors.Is(err, errInvalidBackup) {
+			slog.Error("backup in home assistant isn't valid", "name", backup.Name, "error", err)
+			backup.ErrorMessage = err.Error()
+			s.setStatus(backup, StatusFailed)
+			continue
+		}
+		if err != nil {
+			slog.Debug("could not inspect backup in home assistant", "name", backup.Name, "error", err)
+			continue
+		}
+
+		backup.Manifest = manifest
+	}
+}
+
+// haBackupDir is where Home Assistant keeps the tarballs of its backups
+const haBackupDir = "/backup"
+
+// haBackupPath returns the path of the tarball of a backup in Home Assistant
+func (s *Service) haBackupPath(backup *Backup) string {
+	return fmt.Sprintf("%s/%s.%s", s.backupDir, backup.HA.Slug, "tar")
+}
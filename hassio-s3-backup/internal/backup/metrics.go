@@ -0,0 +1,264 @@
+package backup
+
+import (
+	"context"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/metrics"
+	"hassio-proton-drive-backup/internal/s3"
+	"io"
+	"time"
+)
+
+var (
+	creationDuration   = metrics.NewHistogram("s3_backup_creation_duration_seconds", "Time Home Assistant took to create a backup.", metrics.DurationBuckets)
+	uploadDuration     = metrics.NewHistogram("s3_backup_upload_duration_seconds", "Time a successful upload of a backup to a destination took.", metrics.DurationBuckets, "destination")
+	uploadedBytes      = metrics.NewCounter("s3_backup_uploaded_bytes_total", "Bytes uploaded to a destination.", "destination")
+	syncDuration       = metrics.NewHistogram("s3_backup_sync_duration_seconds", "Time a sync with Home Assistant and the destinations took.", metrics.DurationBuckets)
+	syncErrors         = metrics.NewCounter("s3_backup_sync_errors_total", "Syncs with Home Assistant and the destinations that failed.")
+	storageDuration    = metrics.NewHistogram("s3_backup_destination_request_duration_seconds", "Latency of requests to a destination.", metrics.LatencyBuckets, "destination", "operation")
+	storageErrors      = metrics.NewCounter("s3_backup_destination_request_errors_total", "Requests to a destination that failed.", "destination", "operation")
+	supervisorDuration = metrics.NewHistogram("s3_backup_supervisor_request_duration_seconds", "Latency of requests to the Supervisor API.", metrics.LatencyBuckets, "operation")
+	supervisorErrors   = metrics.NewCounter("s3_backup_supervisor_request_errors_total", "Requests to the Supervisor API that failed.", "operation")
+)
+
+// registerMetrics registers the metrics collected from the state of the backups when they're served
+func (s *Service) registerMetrics() {
+	metrics.NewGaugeFunc("s3_backup_backups", "Number of backups by status.", []string{"status"}, func() []metrics.Sample {
+		counts := make(map[status]int)
+		for _, backup := range s.ListBackups() {
+			counts[backup.Status]++
+		}
+
+		samples := []metrics.Sample{}
+		for status, count := range counts {
+			samples = append(samples, metrics.Sample{Labels: []string{string(status)}, Value: float64(count)})
+		}
+
+		return samples
+	})
+
+	metrics.NewGaugeFunc("s3_backup_last_success_timestamp_seconds", "Time the newest backup in a destination was uploaded.", []string{"destination"}, func() []metrics.Sample {
+		last := make(map[string]time.Time)
+		for _, backup := range s.ListBackups() {
+			for destination, remote := range backup.Remotes {
+				if remote.Modified.After(last[destination]) {
+					last[destination] = remote.Modified
+				}
+			}
+		}
+
+		samples := []metrics.Sample{}
+		for destination, modified := range last {
+			samples = append(samples, metrics.Sample{Labels: []string{destination}, Value: float64(modified.Unix())})
+		}
+
+		return samples
+	})
+}
+
+// instrumentStorage returns a storage recording the latency and errors of the requests to a destination,
+// and the bytes uploaded to it
+func instrumentStorage(destination string, storage Storage) Storage {
+	instrumented := instrumentedStorage{Storage: storage, destination: destination}
+
+	if resumable, ok := storage.(ResumableStorage); ok {
+		return &instrumentedResumableStorage{instrumentedStorage: instrumented, resumable: resumable}
+	}
+
+	return &instrumented
+}
+
+// instrumentedStorage records the requests to a destination
+type instrumentedStorage struct {
+	Storage
+	destination string
+}
+
+// observe records a request that started at the given time
+func (st *instrumentedStorage) observe(operation string, started time.Time, err error) {
+	storageDuration.Observe(time.Since(started).Seconds(), st.destination, operation)
+	if err != nil {
+		storageErrors.Inc(st.destination, operation)
+	}
+}
+
+func (st *instrumentedStorage) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) (*s3.Object, error) {
+	started := time.Now()
+	object, err := st.Storage.Put(ctx, key, &countingReader{r: r, destination: st.destination}, size, metadata)
+	st.observe("put", started, err)
+	return object, err
+}
+
+func (st *instrumentedStorage) Stat(ctx context.Context, key string) (*s3.Object, error) {
+	started := time.Now()
+	object, err := st.Storage.Stat(ctx, key)
+	st.observe("stat", started, err)
+	return object, err
+}
+
+func (st *instrumentedStorage) List(ctx context.Context) ([]*s3.Object, error) {
+	started := time.Now()
+	objects, err := st.Storage.List(ctx)
+	st.observe("list", started, err)
+	return objects, err
+}
+
+func (st *instrumentedStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	started := time.Now()
+	r, err := st.Storage.Get(ctx, key)
+	st.observe("get", started, err)
+	return r, err
+}
+
+func (st *instrumentedStorage) Delete(ctx context.Context, key string) error {
+	started := time.Now()
+	err := st.Storage.Delete(ctx, key)
+	st.observe("delete", started, err)
+	return err
+}
+
+// instrumentedResumableStorage records the requests to a destination that can resume uploads
+type instrumentedResumableStorage struct {
+	instrumentedStorage
+	resumable ResumableStorage
+}
+
+func (st *instrumentedResumableStorage) PutResumable(ctx context.Context, key string, open func(offset int64) (io.ReadCloser, error), size int64, metadata map[string]string, upload *s3.Upload, save func(*s3.Upload)) (*s3.Object, error) {
+	counted := func(offset int64) (io.ReadCloser, error) {
+		r, err := open(offset)
+		if err != nil {
+			return nil, err
+		}
+
+		return &readCloser{Reader: &countingReader{r: r, destination: st.destination}, closers: []io.Closer{r}}, nil
+	}
+
+	started := time.Now()
+	object, err := st.resumable.PutResumable(ctx, key, counted, size, metadata, upload, save)
+	st.observe("put", started, err)
+	return object, err
+}
+
+func (st *instrumentedResumableStorage) IncompleteUploads(ctx context.Context) ([]*s3.Upload, error) {
+	started := time.Now()
+	uploads, err := st.resumable.IncompleteUploads(ctx)
+	st.observe("incomplete_uploads", started, err)
+	return uploads, err
+}
+
+func (st *instrumentedResumableStorage) AbortUpload(ctx context.Context, key string, id string) error {
+	started := time.Now()
+	err := st.resumable.AbortUpload(ctx, key, id)
+	st.observe("abort_upload", started, err)
+	return err
+}
+
+// countingReader counts the bytes read through it as uploaded to a destination
+type countingReader struct {
+	r           io.Reader
+	destination string
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		uploadedBytes.Add(float64(n), cr.destination)
+	}
+
+	return n, err
+}
+
+// instrumentSupervisor returns a Supervisor client recording the latency and errors of its requests
+func instrumentSupervisor(client supervisor) supervisor {
+	return &instrumentedSupervisor{client: client}
+}
+
+// instrumentedSupervisor records the requests to the Supervisor API
+type instrumentedSupervisor struct {
+	client supervisor
+}
+
+// observe records a request that started at the given time
+func (is *instrumentedSupervisor) observe(operation string, started time.Time, err error) {
+	supervisorDuration.Observe(time.Since(started).Seconds(), operation)
+	if err != nil {
+		supervisorErrors.Inc(operation)
+	}
+}
+
+func (is *instrumentedSupervisor) ListBackups(ctx context.Context) ([]*hassio.Backup, error) {
+	started := time.Now()
+	backups, err := is.client.ListBackups(ctx)
+	is.observe("list_backups", started, err)
+	return backups, err
+}
+
+func (is *instrumentedSupervisor) BackupFull(ctx context.Context, request hassio.BackupRequest) (string, error) {
+	started := time.Now()
+	slug, err := is.client.BackupFull(ctx, request)
+	is.observe("create_backup", started, err)
+	return slug, err
+}
+
+func (is *instrumentedSupervisor) BackupPartial(ctx context.Context, request hassio.PartialBackupRequest) (string, error) {
+	started := time.Now()
+	slug, err := is.client.BackupPartial(ctx, request)
+	is.observe("create_backup", started, err)
+	return slug, err
+}
+
+func (is *instrumentedSupervisor) UploadBackup(ctx context.Context, data io.Reader) error {
+	started := time.Now()
+	err := is.client.UploadBackup(ctx, data)
+	is.observe("upload_backup", started, err)
+	return err
+}
+
+func (is *instrumentedSupervisor) DeleteBackup(ctx context.Context, slug string) error {
+	started := time.Now()
+	err := is.client.DeleteBackup(ctx, slug)
+	is.observe("delete_backup", started, err)
+	return err
+}
+
+func (is *instrumentedSupervisor) RestoreBackup(ctx context.Context, slug string, password string) error {
+	started := time.Now()
+	err := is.client.RestoreBackup(ctx, slug, password)
+	is.observe("restore_backup", started, err)
+	return err
+}
+
+func (is *instrumentedSupervisor) RestorePartialBackup(ctx context.Context, slug string, request hassio.PartialRestoreRequest) error {
+	started := time.Now()
+	err := is.client.RestorePartialBackup(ctx, slug, request)
+	is.observe("restore_backup", started, err)
+	return err
+}
+
+func (is *instrumentedSupervisor) SetState(ctx context.Context, entityID string, state hassio.State) error {
+	started := time.Now()
+	err := is.client.SetState(ctx, entityID, state)
+	is.observe("core_states", started, err)
+	return err
+}
+
+func (is *instrumentedSupervisor) CreateNotification(ctx context.Context, id string, title string, message string) error {
+	started := time.Now()
+	err := is.client.CreateNotification(ctx, id, title, message)
+	is.observe("core_services", started, err)
+	return err
+}
+
+func (is *instrumentedSupervisor) DismissNotification(ctx context.Context, id string) error {
+	started := time.Now()
+	err := is.client.DismissNotification(ctx, id)
+	is.observe("core_services", started, err)
+	return err
+}
+
+func (is *instrumentedSupervisor) FireEvent(ctx context.Context, eventType string, data interface{}) error {
+	started := time.Now()
+	err := is.client.FireEvent(ctx, eventType, data)
+	is.observe("core_events", started, err)
+	return err
+}
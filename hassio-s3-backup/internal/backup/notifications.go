@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"hassio-proton-drive-backup/internal/config"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Types of the events fired in Home Assistant for the outcome of backups
+const (
+	eventBackupFailed    = "s3_backup_failed"
+	eventBackupSucceeded = "s3_backup_succeeded"
+)
+
+// failureNotificationID is the ID of the persistent notification about failed backups, a later success dismisses it
+const failureNotificationID = "s3_backup_failed"
+
+// outcome is the result of a backup reported to Home Assistant, also the data of the events fired for it
+type outcome struct {
+	ID     string    `json:"id"`
+	Name   string    `json:"name"`
+	Slug   string    `json:"slug,omitempty"`
+	Date   time.Time `json:"date"`
+	Error  string    `json:"error,omitempty"`
+	failed bool
+}
+
+// notifier reports the outcome of backups to Home Assistant in the background, in the order they happened
+type notifier struct {
+	client  supervisor
+	options func() config.NotificationOptions // Read for every outcome, so changes apply right away
+	mutex   sync.Mutex
+	pending []outcome
+	wake    chan struct{}
+}
+
+// newNotifier returns a notifier reporting through the client as configured by the current options
+func newNotifier(client supervisor, options func() config.NotificationOptions) *notifier {
+	return &notifier{
+		client:  client,
+		options: options,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// add queues an outcome to be reported
+func (n *notifier) add(o outcome) {
+	n.mutex.Lock()
+	n.pending = append(n.pending, o)
+	n.mutex.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run reports outcomes as they're queued until done is closed
+func (n *notifier) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-n.wake:
+		case <-done:
+			return
+		}
+
+		n.mutex.Lock()
+		outcomes := n.pending
+		n.pending = nil
+		n.mutex.Unlock()
+
+		for _, o := range outcomes {
+			n.report(o)
+		}
+	}
+}
+
+// report fires the event of an outcome and creates or dismisses the failure notification, as configured
+func (n *notifier) report(o outcome) {
+	ctx, cancel := context.WithTimeout(context.Background(), coreAPITimeout)
+	defer cancel()
+
+	options := n.options()
+
+	eventType := eventBackupSucceeded
+	if o.failed {
+		eventType = eventBackupFailed
+	}
+
+	if options.Events {
+		if err := n.client.FireEvent(ctx, eventType, o); err != nil {
+			slog.Warn("failed to fire event in home assistant", "event", eventType, "name", o.Name, "error", err)
+		}
+	}
+
+	if !options.Failure {
+		return
+	}
+
+	var err error
+	if o.failed {
+		message := fmt.Sprintf("Backup **%s** failed: %s", o.Name, o.Error)
+		err = n.client.CreateNotification(ctx, failureNotificationID, "S3 Backup failed", message)
+	} else {
+		err = n.client.DismissNotification(ctx, failureNotificationID)
+	}
+	if err != nil {
+		slog.Warn("failed to update notification in home assistant", "name", o.Name, "error", err)
+	}
+}
+
+// reportOutcome reports a backup that failed for good, or that was synced after being created, uploaded or retried,
+// to Home Assistant and the webhooks. Cancelled backups aren't reported. The lock of the store must be held.
+func (s *Service) reportOutcome(backup *Backup, previous status) {
+	o := outcome{
+		ID:   backup.ID,
+		Name: backup.Name,
+		Slug: backup.Slug,
+		Date: backup.Date,
+	}
+
+	event := webhookSynced
+	switch {
+	case backup.Status == StatusFailed && backup.ErrorMessage != errorCancelled:
+		o.failed = true
+		o.Error = backup.ErrorMessage
+		event = webhookFailed
+	case backup.Status == StatusSynced && (previous == StatusSyncing || previous == StatusRetrying || previous == StatusFailed):
+	default:
+		return
+	}
+
+	s.notifier.add(o)
+	s.webhooks.notify(event, backup, "", "")
+}
@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"encoding/json"
+	"hassio-proton-drive-backup/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	failedEventPath    = "/core/api/events/" + eventBackupFailed
+	succeededEventPath = "/core/api/events/" + eventBackupSucceeded
+	createPath         = "/core/api/services/persistent_notification/create"
+	dismissPath        = "/core/api/services/persistent_notification/dismiss"
+)
+
+func TestNotifierReportsOutcomes(t *testing.T) {
+	supervisor, client := newFakeSupervisor(t)
+	n := newNotifier(client, func() config.NotificationOptions {
+		return config.NotificationOptions{Failure: true, Events: true}
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go n.run(done)
+
+	date := time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC)
+	n.add(outcome{ID: "backup", Name: "Full Backup", Date: date, Error: "upload to s3 failed", failed: true})
+
+	// A failure fires an event and creates the notification
+	event := supervisor.waitForRequests(t, failedEventPath, 1)[0]
+	var data outcome
+	if err := json.Unmarshal(event.Body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ID != "backup" || data.Name != "Full Backup" || !data.Date.Equal(date) || data.Error != "upload to s3 failed" {
+		t.Errorf("failure event has data %+v, want the failed backup", data)
+	}
+
+	created := supervisor.waitForRequests(t, createPath, 1)[0]
+	var notification map[string]string
+	if err := json.Unmarshal(created.Body, &notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification["notification_id"] != failureNotificationID || !strings.Contains(notification["message"], "Full Backup") || !strings.Contains(notification["message"], "upload to s3 failed") {
+		t.Errorf("created notification %v, want the failure notification naming the backup and error", notification)
+	}
+
+	// A later success fires an event and dismisses the notification
+	n.add(outcome{ID: "next", Name: "Next Backup", Slug: "aaaa1111", Date: date.Add(time.Hour)})
+
+	supervisor.waitForRequests(t, succeededEventPath, 1)
+	dismissed := supervisor.waitForRequests(t, dismissPath, 1)[0]
+	if err := json.Unmarshal(dismissed.Body, &notification); err != nil {
+		t.Fatal(err)
+	}
+	if notification["notification_id"] != failureNotificationID {
+		t.Errorf("dismissed notification %v, want the failure notification", notification)
+	}
+}
+
+func TestNotifierReadsCurrentOptions(t *testing.T) {
+	supervisor, client := newFakeSupervisor(t)
+
+	options := config.NotificationOptions{}
+	n := newNotifier(client, func() config.NotificationOptions { return options })
+
+	n.report(outcome{ID: "first", Name: "First", failed: true})
+	if len(supervisor.received(failedEventPath)) != 0 || len(supervisor.received(createPath)) != 0 {
+		t.Error("failure reported with notifications and events disabled")
+	}
+
+	// Options changed since the notifier was created apply to the next outcome
+	options = config.NotificationOptions{Events: true}
+	n.report(outcome{ID: "second", Name: "Second", failed: true})
+	if len(supervisor.received(failedEventPath)) != 1 || len(supervisor.received(createPath)) != 0 {
+		t.Error("failure not reported with only events enabled")
+	}
+}
+
+func TestReportOutcome(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{
+		Timezone:      time.UTC,
+		Notifications: config.NotificationOptions{Failure: true, Events: true},
+	}, nil)
+
+	supervisor, client := newFakeSupervisor(t)
+	s.notifier = newNotifier(client, func() config.NotificationOptions { return s.config().Notifications })
+	go s.notifier.run(s.scheduler.Done())
+
+	cancelled := &Backup{ID: "cancelled", Name: "Cancelled", Status: StatusSyncing, ErrorMessage: errorCancelled}
+	synced := &Backup{ID: "synced", Name: "Synced", Status: StatusSyncing}
+	failed := &Backup{ID: "failed", Name: "Failed", Status: StatusRetrying, ErrorMessage: "backup creation in home assistant failed"}
+
+	s.store.Lock()
+	s.setStatus(cancelled, StatusFailed) // Cancelled backups aren't reported
+	s.setStatus(synced, StatusSynced)
+	s.setStatus(failed, StatusFailed)
+	s.store.Unlock()
+
+	supervisor.waitForRequests(t, succeededEventPath, 1)
+	failures := supervisor.waitForRequests(t, failedEventPath, 1)
+	if len(failures) != 1 {
+		t.Fatalf("%d failure events fired, want only the one of the failed backup", len(failures))
+	}
+
+	var data outcome
+	if err := json.Unmarshal(failures[0].Body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ID != "failed" || data.Error != "backup creation in home assistant failed" {
+		t.Errorf("failure event has data %+v, want the failed backup", data)
+	}
+}
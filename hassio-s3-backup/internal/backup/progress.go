@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// progressReader reports the bytes read through it on the backup being transferred
+type progressReader struct {
+	r         io.Reader
+	store     *store // The transfer runs without holding the lock, so the progress is updated through the store
+	events    *eventBus
+	backup    *Backup
+	started   time.Time
+	offset    int64 // Bytes transferred before this reader, not counted for throughput
+	logged    int   // Last logged progress in percent
+	published int   // Last published progress in whole percent
+}
+
+// newProgressReader sets the transfer progress of the backup to the offset and returns a reader reporting to it,
+// publishing the progress every whole percent. It must be created and read without holding the lock of the store.
+func newProgressReader(r io.Reader, st *store, events *eventBus, backup *Backup, offset int64, total int64) *progressReader {
+	var progress float64
+	if total > 0 {
+		progress = float64(offset) / float64(total) * 100
+	}
+
+	st.update(func() {
+		backup.BytesTotal = total
+		backup.BytesTransferred = offset
+		backup.Progress = progress
+		backup.Throughput = 0
+	})
+
+	return &progressReader{
+		r:         r,
+		store:     st,
+		events:    events,
+		backup:    backup,
+		started:   time.Now(),
+		offset:    offset,
+		logged:    int(progress) / 10 * 10,
+		published: int(progress),
+	}
+}
+
+// Read reads from the underlying reader and updates the progress
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	var progress, throughput float64
+	var event ProgressEvent
+	pr.store.update(func() {
+		b := pr.backup
+		b.BytesTransferred += int64(n)
+
+		if elapsed := time.Since(pr.started).Seconds(); elapsed > 0 {
+			b.Throughput = float64(b.BytesTransferred-pr.offset) / elapsed
+		}
+
+		if b.BytesTotal > 0 {
+			b.Progress = float64(b.BytesTransferred) / float64(b.BytesTotal) * 100
+		}
+
+		progress, throughput = b.Progress, b.Throughput
+		event = ProgressEvent{
+			ID:               b.ID,
+			Name:             b.Name,
+			Progress:         b.Progress,
+			BytesTransferred: b.BytesTransferred,
+			BytesTotal:       b.BytesTotal,
+			Throughput:       b.Throughput,
+		}
+	})
+
+	if percent := int(progress); percent > pr.published {
+		pr.published = percent
+		pr.events.publish(EventProgress, event)
+	}
+
+	// Log every 10 percent so long transfers show up in the logs
+	if percent := int(progress) / 10 * 10; percent > pr.logged {
+		pr.logged = percent
+		slog.Info("transfer progress", "name", pr.backup.Name, "progress", percent, "throughput", formatThroughput(throughput))
+	}
+
+	return n, err
+}
+
+// clearProgress removes the transfer progress from the backup once the transfer is done. The lock must be held.
+func clearProgress(backup *Backup) {
+	backup.BytesTotal = 0
+	backup.BytesTransferred = 0
+	backup.Progress = 0
+	backup.Throughput = 0
+}
+
+// formatThroughput formats bytes per second in MB/s
+func formatThroughput(bytesPerSecond float64) string {
+	return strconv.FormatFloat(bytesPerSecond/(1024*1024), 'f', 2, 64) + " MB/s"
+}
@@ -0,0 +1,96 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"hassio-proton-drive-backup/internal/config"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestProgressReader(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{Timezone: time.UTC}, nil)
+
+	backup := &Backup{ID: "backup", Name: "Full Backup", Profile: config.DefaultProfile, Date: clock.Now()}
+	s.store.add(backup)
+
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	// A resumed transfer starts at the progress of the bytes transferred before
+	pr := newProgressReader(bytes.NewReader(make([]byte, 600)), &s.store, s.events, backup, 400, 1000)
+
+	s.store.RLock()
+	if backup.Progress != 40 || backup.BytesTransferred != 400 || backup.BytesTotal != 1000 {
+		t.Errorf("resumed transfer at %v%% with %d of %d bytes, want 40%% with 400 of 1000", backup.Progress, backup.BytesTransferred, backup.BytesTotal)
+	}
+	s.store.RUnlock()
+
+	// Reads smaller than a percent only publish the progress once it reaches the next whole percent
+	buf := make([]byte, 7)
+	for {
+		_, err := pr.Read(buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	published, _ := drain(events)
+	if len(published) != 60 {
+		t.Fatalf("published %d progress events, want one for every percent from 41 to 100", len(published))
+	}
+	for i, event := range published {
+		progress := event.Data.(ProgressEvent)
+		if want := 41 + i; event.Type != EventProgress || int(progress.Progress) != want {
+			t.Errorf("event %d is %s at %v%%, want progress at %d%%", i, event.Type, progress.Progress, want)
+		}
+	}
+
+	// The last event is the completed transfer
+	final := published[len(published)-1].Data.(ProgressEvent)
+	if final.Progress != 100 || final.BytesTransferred != 1000 || final.BytesTotal != 1000 || final.ID != "backup" {
+		t.Errorf("final progress event %+v, want all 1000 bytes of the backup transferred", final)
+	}
+
+	s.store.Lock()
+	if backup.Progress != 100 || backup.BytesTransferred != 1000 {
+		t.Errorf("transferred backup at %v%% with %d bytes, want 100%% with 1000", backup.Progress, backup.BytesTransferred)
+	}
+	clearProgress(backup)
+	if backup.Progress != 0 || backup.BytesTransferred != 0 || backup.BytesTotal != 0 || backup.Throughput != 0 {
+		t.Errorf("backup has progress %+v after it was cleared", backup)
+	}
+	s.store.Unlock()
+}
+
+func TestProgressReaderWithoutTotal(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{Timezone: time.UTC}, nil)
+
+	backup := &Backup{ID: "backup", Name: "Full Backup", Profile: config.DefaultProfile, Date: clock.Now()}
+	s.store.add(backup)
+
+	events, unsubscribe := s.events.subscribe()
+	defer unsubscribe()
+
+	// Without a known size the bytes are counted, but there's no percentage to publish
+	pr := newProgressReader(bytes.NewReader(make([]byte, 100)), &s.store, s.events, backup, 0, 0)
+	if _, err := io.Copy(io.Discard, pr); err != nil {
+		t.Fatal(err)
+	}
+
+	if published, _ := drain(events); len(published) != 0 {
+		t.Errorf("published %d progress events without a total, want none", len(published))
+	}
+
+	s.store.RLock()
+	defer s.store.RUnlock()
+	if backup.Progress != 0 || backup.BytesTransferred != 100 {
+		t.Errorf("backup at %v%% with %d bytes, want 0%% with 100", backup.Progress, backup.BytesTransferred)
+	}
+}
@@ -0,0 +1,260 @@
+package backup
+
+import (
+	"fmt"
+	"hassio-proton-drive-backup/internal/config"
+	"sort"
+	"time"
+)
+
+// LocationHA is the retention location of backups in Home Assistant, other locations are destination names
+const LocationHA = "homeassistant"
+
+// RetentionDeletion describes a backup that retention removes from a location and why
+type RetentionDeletion struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Profile  string    `json:"profile"`
+	Location string    `json:"location"`
+	Date     time.Time `json:"date"`
+	Reason   string    `json:"reason"`
+}
+
+// retentionPolicy decides which backups are kept in a single location
+type retentionPolicy struct {
+	keep      int
+	retention config.Retention
+}
+
+// expiredBackup is a backup the policy doesn't keep
+type expiredBackup struct {
+	backup *Backup
+	reason string
+}
+
+// expired returns the backups the policy doesn't keep, backups must be sorted newest first.
+// A backup is kept if it's one of the newest keep backups or selected by any of the tiers,
+// as long as it's within the age limit and the total size of the newer backups.
+func (p retentionPolicy) expired(backups []*Backup, now time.Time, timezone *time.Location, size func(*Backup) float64) []expiredBackup {
+	expired := []expiredBackup{}
+	remaining := backups
+
+	if p.keep > 0 || p.retention.Tiered() {
+		kept := make(map[*Backup]bool)
+		for i := 0; i < p.keep && i < len(backups); i++ {
+			kept[backups[i]] = true
+		}
+
+		keepPeriods(kept, backups, timezone, p.retention.Daily, func(t time.Time) string {
+			return t.Format("2006-01-02")
+		})
+		keepPeriods(kept, backups, timezone, p.retention.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		})
+		keepPeriods(kept, backups, timezone, p.retention.Monthly, func(t time.Time) string {
+			return t.Format("2006-01")
+		})
+		keepPeriods(kept, backups, timezone, p.retention.Yearly, func(t time.Time) string {
+			return t.Format("2006")
+		})
+
+		reason := fmt.Sprintf("exceeds the %d backups to keep", p.keep)
+		if p.retention.Tiered() {
+			reason = "not kept by the backups to keep or any retention tier"
+		}
+
+		remaining = []*Backup{}
+		for _, backup := range backups {
+			if kept[backup] {
+				remaining = append(remaining, backup)
+			} else {
+				expired = append(expired, expiredBackup{backup: backup, reason: reason})
+			}
+		}
+	}
+
+	if p.retention.MaxAge > 0 {
+		cutoff := now.AddDate(0, 0, -p.retention.MaxAge)
+		reason := fmt.Sprintf("older than %d days", p.retention.MaxAge)
+
+		newer := []*Backup{}
+		for _, backup := range remaining {
+			if backup.Date.Before(cutoff) {
+				expired = append(expired, expiredBackup{backup: backup, reason: reason})
+			} else {
+				newer = append(newer, backup)
+			}
+		}
+		remaining = newer
+	}
+
+	return append(expired, oversized(remaining, p.retention.MaxSize, size)...)
+}
+
+// oversized returns the oldest backups that don't fit within the size limit in GB, backups must be sorted newest first.
+// The newest backup is always kept, a limit of 0 keeps all.
+func oversized(backups []*Backup, maxSize float64, size func(*Backup) float64) []expiredBackup {
+	expired := []expiredBackup{}
+	if maxSize <= 0 {
+		return expired
+	}
+
+	limit := maxSize * 1024
+	reason := fmt.Sprintf("total size exceeds %g GB", maxSize)
+
+	total := 0.0
+	for i, backup := range backups {
+		total += size(backup)
+		if i > 0 && total > limit {
+			expired = append(expired, expiredBackup{backup: backup, reason: reason})
+		}
+	}
+
+	return expired
+}
+
+// keepPeriods keeps the newest backup of each of the latest count periods that have a backup
+func keepPeriods(kept map[*Backup]bool, backups []*Backup, timezone *time.Location, count int, period func(time.Time) string) {
+	seen := make(map[string]bool)
+
+	for _, backup := range backups {
+		if len(seen) >= count {
+			return
+		}
+
+		key := period(backup.Date.In(timezone))
+		if !seen[key] {
+			seen[key] = true
+			kept[backup] = true
+		}
+	}
+}
+
+// retentionPlan returns the backups to delete from each location according to the given config.
+// The retention of each profile is applied first, then the size limit of each location to the backups of all profiles
+// left in it, deleting the oldest first. The size limit of a profile only applies to its own backups.
+// Pinned and failed backups are never deleted and don't count towards any limit. The lock of the store must be held.
+func (s *Service) retentionPlan(options *config.Options) []RetentionDeletion {
+	deletions := []RetentionDeletion{}
+	now := s.scheduler.Now()
+
+	locations := []string{LocationHA}
+	limits := map[string]float64{LocationHA: options.RetentionInHA.MaxSize}
+	for _, d := range options.Destinations {
+		locations = append(locations, d.Name)
+		limits[d.Name] = d.Retention.MaxSize
+	}
+
+	// Backups each location keeps after the retention of their profile
+	kept := make(map[string][]*Backup)
+	apply := func(location string, policy retentionPolicy, backups []*Backup) {
+		expired := make(map[*Backup]bool)
+		for _, e := range policy.expired(backups, now, options.Timezone, locationSize(location)) {
+			expired[e.backup] = true
+			deletions = append(deletions, newRetentionDeletion(e, location))
+		}
+
+		for _, backup := range backups {
+			if !expired[backup] {
+				kept[location] = append(kept[location], backup)
+			}
+		}
+	}
+
+	for _, profile := range options.AllProfiles() {
+		backups := []*Backup{}
+		for _, backup := range s.store.backups {
+			if backup.Profile == profile.Name && !backup.Pinned && backup.Status != StatusFailed {
+				backups = append(backups, backup)
+			}
+		}
+
+		// Sort newest first so the most recent backups are kept
+		sortNewestFirst(backups)
+
+		haBackups := []*Backup{}
+		for _, backup := range backups {
+			if backup.HA != nil && backup.HA.Slug != "" {
+				haBackups = append(haBackups, backup)
+			}
+		}
+		apply(LocationHA, profilePolicy(profile, profile.BackupsInHA, profile.RetentionInHA), haBackups)
+
+		for _, d := range options.Destinations {
+			remoteBackups := []*Backup{}
+			for _, backup := range backups {
+				if _, exists := backup.Remotes[d.Name]; exists {
+					remoteBackups = append(remoteBackups, backup)
+				}
+			}
+			apply(d.Name, profilePolicy(profile, profile.Keep(d.Name), profile.Retention(d.Name)), remoteBackups)
+		}
+	}
+
+	for _, location := range locations {
+		backups := kept[location]
+		sortNewestFirst(backups)
+
+		for _, e := range oversized(backups, limits[location], locationSize(location)) {
+			deletions = append(deletions, newRetentionDeletion(e, location))
+		}
+	}
+
+	return deletions
+}
+
+// profilePolicy returns the retention policy of a profile in a location.
+// The retention of the default profile is the retention of the location, whose size limit covers all profiles.
+func profilePolicy(profile config.Profile, keep int, retention config.Retention) retentionPolicy {
+	if profile.Name == config.DefaultProfile {
+		retention.MaxSize = 0
+	}
+
+	return retentionPolicy{keep: keep, retention: retention}
+}
+
+// locationSize returns the size of backups in a location in MB
+func locationSize(location string) func(*Backup) float64 {
+	if location == LocationHA {
+		return func(backup *Backup) float64 {
+			return backup.HA.Size
+		}
+	}
+
+	return func(backup *Backup) float64 {
+		return backup.Remotes[location].Size
+	}
+}
+
+// sortNewestFirst sorts backups by date, newest first
+func sortNewestFirst(backups []*Backup) {
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Date.After(backups[j].Date)
+	})
+}
+
+// newRetentionDeletion returns the deletion of an expired backup from a location
+func newRetentionDeletion(e expiredBackup, location string) RetentionDeletion {
+	return RetentionDeletion{
+		ID:       e.backup.ID,
+		Name:     e.backup.Name,
+		Profile:  e.backup.Profile,
+		Location: location,
+		Date:     e.backup.Date,
+		Reason:   e.reason,
+	}
+}
+
+// PreviewRetention returns what retention would delete if the requested config was saved
+func (s *Service) PreviewRetention(configRequest config.Options) ([]RetentionDeletion, error) {
+	options, err := s.configService.PreviewConfig(configRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	s.store.RLock()
+	defer s.store.RUnlock()
+
+	return s.retentionPlan(options), nil
+}
@@ -0,0 +1,254 @@
+package backup
+
+import (
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRetentionPolicyTiers(t *testing.T) {
+	now := time.Date(2024, 9, 25, 13, 0, 0, 0, time.UTC)
+	backups := dailyBackups(now, 90)
+
+	policy := retentionPolicy{retention: config.Retention{Daily: 3, Weekly: 2, Monthly: 2}}
+	expired := policy.expired(backups, now, time.UTC, func(*Backup) float64 { return 0 })
+
+	assertKept(t, backups, expired, "2024-09-25", "2024-09-24", "2024-09-23", "2024-09-22", "2024-08-31")
+	for _, e := range expired {
+		if e.reason != "not kept by the backups to keep or any retention tier" {
+			t.Errorf("backup of %s expired because %q", e.backup.Name, e.reason)
+		}
+	}
+}
+
+func TestRetentionPolicyKeepsTiersInTimezone(t *testing.T) {
+	now := time.Date(2024, 9, 25, 13, 0, 0, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	// The same day in UTC, but 16:00 UTC is already the next day in Tokyo
+	backups := []*Backup{
+		{Name: "16:00", Date: time.Date(2024, 9, 24, 16, 0, 0, 0, time.UTC)},
+		{Name: "14:00", Date: time.Date(2024, 9, 24, 14, 0, 0, 0, time.UTC)},
+		{Name: "10:00", Date: time.Date(2024, 9, 24, 10, 0, 0, 0, time.UTC)},
+	}
+
+	policy := retentionPolicy{retention: config.Retention{Daily: 2}}
+
+	expired := policy.expired(backups, now, time.UTC, func(*Backup) float64 { return 0 })
+	assertKept(t, backups, expired, "16:00")
+
+	expired = policy.expired(backups, now, tokyo, func(*Backup) float64 { return 0 })
+	assertKept(t, backups, expired, "16:00", "14:00")
+}
+
+func TestRetentionPolicyLimits(t *testing.T) {
+	now := time.Date(2024, 9, 25, 13, 0, 0, 0, time.UTC)
+	size := func(*Backup) float64 { return 400 }
+
+	tests := []struct {
+		name   string
+		policy retentionPolicy
+		kept   []string
+		reason string
+	}{
+		{
+			name:   "keep",
+			policy: retentionPolicy{keep: 2},
+			kept:   []string{"2024-09-25", "2024-09-24"},
+			reason: "exceeds the 2 backups to keep",
+		},
+		{
+			name:   "age",
+			policy: retentionPolicy{keep: 5, retention: config.Retention{MaxAge: 2}},
+			kept:   []string{"2024-09-25", "2024-09-24"},
+			reason: "older than 2 days",
+		},
+		{
+			name:   "size",
+			policy: retentionPolicy{retention: config.Retention{MaxSize: 1}},
+			kept:   []string{"2024-09-25", "2024-09-24"},
+			reason: "total size exceeds 1 GB",
+		},
+		{
+			name:   "newest is kept above the size limit",
+			policy: retentionPolicy{retention: config.Retention{MaxSize: 0.1}},
+			kept:   []string{"2024-09-25"},
+			reason: "total size exceeds 0.1 GB",
+		},
+		{
+			name:   "no limits",
+			policy: retentionPolicy{},
+			kept:   []string{"2024-09-25", "2024-09-24", "2024-09-23", "2024-09-22", "2024-09-21"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backups := dailyBackups(now, 5)
+			expired := tt.policy.expired(backups, now, time.UTC, size)
+
+			assertKept(t, backups, expired, tt.kept...)
+			for _, e := range expired {
+				if e.reason != tt.reason {
+					t.Errorf("backup of %s expired because %q, want %q", e.backup.Name, e.reason, tt.reason)
+				}
+			}
+		})
+	}
+}
+
+func TestRetentionPlanKeepAndTiers(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 13, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{
+		Timezone: time.UTC,
+		Destinations: []config.DestinationOptions{
+			{Name: "s3", Type: config.DestinationS3, Keep: 1, Retention: config.Retention{Weekly: 2}},
+		},
+	}, nil)
+
+	for _, backup := range dailyBackups(clock.Now(), 5) {
+		backup.ID = backup.Name
+		backup.Profile = config.DefaultProfile
+		backup.Remotes = map[string]*s3.Object{"s3": {Key: backup.Name + ".tar"}}
+		s.store.backups = append(s.store.backups, backup)
+	}
+
+	s.store.RLock()
+	deletions := s.retentionPlan(s.config())
+	s.store.RUnlock()
+
+	// The backups to keep and the tiers add up, the weekly tier keeps the backup of the week before on top of the 1 to keep
+	want := map[string]bool{"2024-09-24": true, "2024-09-23": true, "2024-09-21": true}
+	if len(deletions) != len(want) {
+		t.Fatalf("retention deletes %+v, want %v", deletions, want)
+	}
+	for _, deletion := range deletions {
+		if !want[deletion.ID] || deletion.Reason != "not kept by the backups to keep or any retention tier" {
+			t.Errorf("retention deletes %s because %q", deletion.ID, deletion.Reason)
+		}
+	}
+}
+
+func TestRetentionPlanSizeAcrossProfiles(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 13, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{
+		Timezone: time.UTC,
+		Destinations: []config.DestinationOptions{
+			{Name: "s3", Type: config.DestinationS3, Retention: config.Retention{MaxSize: 1}},
+		},
+		Profiles: []config.Profile{
+			{Name: "addons", Partial: true, Addons: []string{"core_ssh"}, BackupsInDestinations: map[string]int{"s3": 1}},
+		},
+	}, nil)
+
+	add := func(name string, profile string, daysAgo int, size float64) *Backup {
+		backup := &Backup{
+			ID:      name,
+			Name:    name,
+			Profile: profile,
+			Date:    clock.Now().AddDate(0, 0, -daysAgo),
+			Remotes: map[string]*s3.Object{"s3": {Key: name + ".tar", Size: size}},
+		}
+		s.store.add(backup)
+		return backup
+	}
+
+	add("full-1", config.DefaultProfile, 1, 300)
+	add("addons-2", "addons", 2, 300)
+	add("full-3", config.DefaultProfile, 3, 300)
+	add("addons-4", "addons", 4, 300)
+	add("full-5", config.DefaultProfile, 5, 300)
+	add("pinned-6", config.DefaultProfile, 6, 5000).Pinned = true
+	add("failed-7", config.DefaultProfile, 7, 5000).Status = StatusFailed
+
+	s.store.RLock()
+	deletions := s.retentionPlan(s.config())
+	s.store.RUnlock()
+
+	// The add-ons profile keeps 1 backup, then the 1 GB limit of the destination covers the backups of both profiles
+	want := map[string]string{
+		"addons-4": "exceeds the 1 backups to keep",
+		"full-5":   "total size exceeds 1 GB",
+	}
+
+	if len(deletions) != len(want) {
+		t.Fatalf("retention deletes %+v, want %v", deletions, want)
+	}
+	for _, deletion := range deletions {
+		if reason, exists := want[deletion.ID]; !exists || deletion.Reason != reason || deletion.Location != "s3" {
+			t.Errorf("retention deletes %s from %s because %q", deletion.ID, deletion.Location, deletion.Reason)
+		}
+	}
+}
+
+func TestRetentionPlanHomeAssistant(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 13, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{
+		Timezone:      time.UTC,
+		BackupsInHA:   1,
+		RetentionInHA: config.Retention{MaxAge: 30},
+	}, nil)
+
+	for i, name := range []string{"newest", "older", "pending"} {
+		backup := &Backup{ID: name, Name: name, Profile: config.DefaultProfile, Date: clock.Now().AddDate(0, 0, -i)}
+		if name != "pending" {
+			backup.HA = &hassio.Backup{Slug: name}
+		} else {
+			// Backups being created have no slug yet
+			backup.HA = &hassio.Backup{}
+		}
+		s.store.add(backup)
+	}
+
+	s.store.RLock()
+	deletions := s.retentionPlan(s.config())
+	s.store.RUnlock()
+
+	if len(deletions) != 1 || deletions[0].ID != "older" || deletions[0].Location != LocationHA {
+		t.Fatalf("retention deletes %+v, want only the older backup from home assistant", deletions)
+	}
+}
+
+// dailyBackups returns a backup at noon of each of the given number of days up to now, newest first
+func dailyBackups(now time.Time, days int) []*Backup {
+	backups := []*Backup{}
+	for i := 0; i < days; i++ {
+		date := time.Date(now.Year(), now.Month(), now.Day()-i, 12, 0, 0, 0, now.Location())
+		backups = append(backups, &Backup{Name: date.Format("2006-01-02"), Date: date})
+	}
+
+	return backups
+}
+
+// assertKept checks that exactly the backups with the given names weren't expired
+func assertKept(t *testing.T, backups []*Backup, expired []expiredBackup, names ...string) {
+	t.Helper()
+
+	isExpired := make(map[*Backup]bool)
+	for _, e := range expired {
+		isExpired[e.backup] = true
+	}
+
+	kept := []string{}
+	for _, backup := range backups {
+		if !isExpired[backup] {
+			kept = append(kept, backup.Name)
+		}
+	}
+
+	want := append([]string{}, names...)
+	sort.Strings(kept)
+	sort.Strings(want)
+
+	if len(kept) != len(want) {
+		t.Fatalf("kept %v, want %v", kept, want)
+	}
+	for i := range kept {
+		if kept[i] != want[i] {
+			t.Fatalf("kept %v, want %v", kept, want)
+		}
+	}
+}
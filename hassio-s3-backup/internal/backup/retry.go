@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hassio-proton-drive-backup/internal/crypt"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	maxRetryBackoff = 6 * time.Hour // Longest delay between two attempts
+	retryJitter     = 0.25          // Retries are spread out by up to a quarter of the backoff in either direction
+)
+
+// isPermanent returns true for errors that retrying won't fix
+func isPermanent(err error) bool {
+	var requestErr *hassio.RequestError
+	if errors.As(err, &requestErr) {
+		switch requestErr.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return true
+		}
+		return false
+	}
+
+	return s3.IsPermanent(err) || errors.Is(err, os.ErrNotExist) || errors.Is(err, crypt.ErrInvalidFormat) ||
+		errors.Is(err, errInvalidBackup)
+}
+
+// retryBackoff returns the delay before the given attempt, doubling for every attempt with some jitter
+func retryBackoff(initial time.Duration, attempt int) time.Duration {
+	backoff := initial
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+
+	jitter := (rand.Float64()*2 - 1) * retryJitter
+	return time.Duration(float64(backoff) * (1 + jitter))
+}
+
+// waitingForRetry returns true if the backup has a retry scheduled that isn't due yet
+func (b *Backup) waitingForRetry(now time.Time) bool {
+	return b.NextRetry != nil && now.Before(*b.NextRetry)
+}
+
+// clearRetry resets the retry state of a backup that succeeded
+func (b *Backup) clearRetry() {
+	b.Attempts = 0
+	b.NextRetry = nil
+	b.ErrorMessage = ""
+}
+
+// errorCancelled is the error message of a backup whose operation was cancelled
+const errorCancelled = "Cancelled"
+
+// scheduleRetry records a failed attempt and schedules a retry with exponential backoff.
+// Backups failing with a permanent error or out of retries are marked as failed instead.
+func (s *Service) scheduleRetry(backup *Backup, err error) {
+	// Cancelled operations aren't retried, the backup is left for the user to upload again
+	if errors.Is(err, context.Canceled) {
+		backup.NextRetry = nil
+		backup.ErrorMessage = errorCancelled
+		s.setStatus(backup, StatusFailed)
+		slog.Info("backup operation cancelled", "name", backup.Name)
+		s.resetRetryTimer()
+		return
+	}
+
+	backup.Attempts++
+	backup.NextRetry = nil
+
+	switch {
+	case isPermanent(err):
+		backup.ErrorMessage = fmt.Sprintf("%v (permanent error, not retrying)", err)
+		s.setStatus(backup, StatusFailed)
+		slog.Error("backup failed with a permanent error", "name", backup.Name, "error", err)
+	case backup.Attempts > s.config().Retry.Attempts:
+		backup.ErrorMessage = fmt.Sprintf("%v (gave up after %d attempts)", err, backup.Attempts)
+		s.setStatus(backup, StatusFailed)
+		slog.Error("backup failed, no retries left", "name", backup.Name, "attempts", backup.Attempts, "error", err)
+	default:
+		next := s.scheduler.Now().Add(retryBackoff(s.config().Retry.Backoff, backup.Attempts))
+		backup.NextRetry = &next
+		backup.ErrorMessage = err.Error()
+		s.setStatus(backup, StatusRetrying)
+		slog.Warn("backup failed, retrying later", "name", backup.Name, "attempt", backup.Attempts, "retry", next, "error", err)
+	}
+
+	s.resetRetryTimer()
+}
+
+// resetRetryTimer sets the retry timer to the earliest scheduled retry. Uploads whose retry is already due
+// are left to the sync that's running or clears them, only creations are retried right away.
+func (s *Service) resetRetryTimer() {
+	now := s.scheduler.Now()
+
+	var next *time.Time
+	for _, backup := range s.store.backups {
+		if backup.NextRetry == nil || (!backup.CreationFailed && !backup.waitingForRetry(now)) {
+			continue
+		}
+
+		if next == nil || backup.NextRetry.Before(*next) {
+			next = backup.NextRetry
+		}
+	}
+
+	if next == nil {
+		s.scheduler.Cancel(jobRetry)
+		return
+	}
+
+	s.scheduler.Schedule(jobRetry, max(next.Sub(now), 0), s.queueRetries)
+}
+
+// clearStaleRetries gives up the retries of uploads that were due but that the sync didn't attempt, because newer
+// backups are enough to keep in every destination or the copy in Home Assistant is gone.
+// Left due, they would queue a retry right away, over and over. The lock of the store must be held.
+func (s *Service) clearStaleRetries() {
+	now := s.scheduler.Now()
+	for _, backup := range s.store.backups {
+		if backup.NextRetry == nil || backup.CreationFailed || backup.waitingForRetry(now) {
+			continue
+		}
+
+		slog.Info("not retrying backup that no longer needs to be uploaded", "name", backup.Name)
+		backup.NextRetry = nil
+
+		// Without a copy anywhere the backup is lost, it's kept as failed so the error is still shown
+		if backup.HA == nil && len(backup.Remotes) == 0 {
+			s.setStatus(backup, StatusFailed)
+			continue
+		}
+
+		backup.clearRetry()
+		s.updateStatus(backup)
+	}
+}
+
+// queueRetries queues the retries that are due, they run as part of a sync
+func (s *Service) queueRetries() {
+	s.jobs.add(OperationSync, "", s.retryBackups)
+}
+
+// retryBackups retries the backups whose retry is due
+func (s *Service) retryBackups(ctx context.Context) error {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	// Backups whose creation in Home Assistant failed are created again, the sync uploads the rest.
+	// Backups whose copy in Home Assistant was deleted since aren't created again.
+	due := []*Backup{}
+	for _, backup := range s.store.backups {
+		if backup.CreationFailed && backup.NextRetry != nil && !backup.waitingForRetry(s.scheduler.Now()) {
+			due = append(due, backup)
+		}
+	}
+
+	for _, backup := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Backups may be deleted while another one is being created
+		if _, tracked := s.store.get(backup.ID); tracked != nil {
+			s.retryBackupCreation(ctx, backup)
+		}
+	}
+
+	if err := s.syncBackups(ctx); err != nil {
+		slog.Error("error syncing backups", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// retryBackupCreation creates a backup that failed to be created in Home Assistant again
+func (s *Service) retryBackupCreation(ctx context.Context, backup *Backup) {
+	profile, exists := s.config().GetProfile(backup.Profile)
+	if !exists {
+		backup.NextRetry = nil
+		backup.ErrorMessage = fmt.Sprintf("profile \"%s\" doesn't exist anymore", backup.Profile)
+		s.setStatus(backup, StatusFailed)
+		return
+	}
+
+	if !s.ongoing.start(backup.ID, false) {
+		return
+	}
+	defer s.ongoing.finish(backup.ID)
+
+	slog.Info("retrying backup creation", "name", backup.Name, "attempt", backup.Attempts+1)
+	s.setStatus(backup, StatusRunning)
+
+	var slug string
+	var err error
+	s.store.unlocked(func() {
+		slug, err = s.createHABackup(ctx, backup, profile)
+	})
+	if err != nil {
+		s.scheduleRetry(backup, fmt.Errorf("backup creation in home assistant failed: %w", err))
+		return
+	}
+
+	backup.HA = &hassio.Backup{Slug: slug}
+	backup.Slug = slug
+	backup.NextRetry = nil
+	backup.CreationFailed = false
+	s.webhooks.notify(webhookCreated, backup, "", "")
+
+	if err := s.syncBackupToS3(ctx, backup); err != nil {
+		slog.Error("error syncing retried backup", "name", backup.Name, "error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/s3"
+	"testing"
+	"time"
+)
+
+func TestRetryNotNeededIsCleared(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	storage := newMemoryStorage(clock)
+	s := newTestService(t, clock, &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3, Keep: 1}},
+	}, map[string]Storage{"s3": storage})
+
+	supervisor, client := newFakeSupervisor(t)
+	s.hassioClient = client
+
+	// The newer backup is already uploaded, so the destination has the 1 backup to keep
+	newerHA := supervisor.addBackup(t, s.backupDir, Manifest{Slug: "aaaa1111", Name: "Newer", Date: clock.Now().Add(-time.Hour), Type: "full"})
+	storage.putObject("Newer_aaaa1111.tar", []byte("backup"), clock.Now(), map[string]string{slugMetadata: "aaaa1111"})
+	newer := &Backup{ID: "newer", Name: "Newer", Slug: "aaaa1111", HA: newerHA, Profile: config.DefaultProfile, Date: clock.Now().Add(-time.Hour)}
+
+	olderHA := supervisor.addBackup(t, s.backupDir, Manifest{Slug: "bbbb2222", Name: "Older", Date: clock.Now().AddDate(0, 0, -1), Type: "full"})
+	older := &Backup{
+		ID:           "older",
+		Name:         "Older",
+		Slug:         "bbbb2222",
+		HA:           olderHA,
+		Profile:      config.DefaultProfile,
+		Date:         clock.Now().AddDate(0, 0, -1),
+		Status:       StatusRetrying,
+		ErrorMessage: "upload to s3 failed: timeout",
+		Attempts:     1,
+		NextRetry:    timePtr(clock.Now().Add(time.Minute)),
+	}
+
+	s.store.Lock()
+	s.store.backups = []*Backup{newer, older}
+	s.resetRetryTimer()
+	s.store.Unlock()
+
+	clock.Advance(time.Minute)
+
+	jobs := s.jobs.list()
+	if len(jobs) != 1 {
+		t.Fatalf("%d jobs queued when the retry was due, want 1", len(jobs))
+	}
+	waitForJob(t, s.jobs, jobs[0].ID, JobSucceeded)
+
+	s.store.RLock()
+	if older.NextRetry != nil || older.Attempts != 0 || older.Status != StatusHAOnly || len(older.Remotes) != 0 {
+		t.Errorf("older backup is %s with retry %v after %d attempts and remotes %v, want it left in home assistant without retry",
+			older.Status, older.NextRetry, older.Attempts, older.Remotes)
+	}
+	s.store.RUnlock()
+
+	// Nothing is left to retry, so no more retries are queued
+	clock.Advance(time.Hour)
+	if jobs := s.jobs.list(); len(jobs) != 1 {
+		t.Fatalf("%d jobs queued after the retry, want only the retry", len(jobs))
+	}
+}
+
+func TestRetryDoesNotCreateDeletedBackupAgain(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+	}, map[string]Storage{"s3": newMemoryStorage(clock)})
+
+	// The upload failed, then the copy in Home Assistant was deleted
+	deleted := &Backup{
+		ID:           "deleted",
+		Name:         "Deleted",
+		Slug:         "aaaa1111",
+		Profile:      config.DefaultProfile,
+		Date:         clock.Now().AddDate(0, 0, -1),
+		Remotes:      map[string]*s3.Object{},
+		Status:       StatusRetrying,
+		ErrorMessage: "upload to s3 failed: timeout",
+		Attempts:     1,
+		NextRetry:    timePtr(clock.Now().Add(-time.Minute)),
+	}
+
+	s.store.Lock()
+	s.store.backups = []*Backup{deleted}
+	s.store.Unlock()
+
+	runSync(t, s)
+
+	s.store.RLock()
+	defer s.store.RUnlock()
+
+	if deleted.HA != nil || deleted.NextRetry != nil || deleted.Status != StatusFailed {
+		t.Errorf("deleted backup is %s in home assistant %v with retry %v, want it failed without retry", deleted.Status, deleted.HA, deleted.NextRetry)
+	}
+	if deleted.ErrorMessage != "upload to s3 failed: timeout" {
+		t.Errorf("deleted backup has error %q, want the error of the upload", deleted.ErrorMessage)
+	}
+}
@@ -11,10 +11,20 @@ func RegisterBackupRoutes(mux *http.ServeMux, bs *Service) {
 	mux.HandleFunc("GET /api/backups", h.handleListBackups)
 	mux.HandleFunc("GET /api/backups/{id}/download", h.handleDownloadBackupRequest)
 	mux.HandleFunc("GET /api/backups/timer", h.handleTimerRequest)
+	mux.HandleFunc("GET /api/backups/instances", h.handleListInstanceBackupsRequest)
 	mux.HandleFunc("POST /api/backups/reset", h.handleResetBackupsRequest)
+	mux.HandleFunc("POST /api/backups/retention/preview", h.handleRetentionPreviewRequest)
 	mux.HandleFunc("POST /api/backups/new/full", h.handleBackupRequest)
 	mux.HandleFunc("POST /api/backups/{id}/pin", h.handlePinBackupRequest)
 	mux.HandleFunc("POST /api/backups/{id}/unpin", h.handleUnpinBackupRequest)
+	mux.HandleFunc("POST /api/backups/{id}/verify", h.handleVerifyBackupRequest)
+	mux.HandleFunc("POST /api/backups/{id}/upload", h.handleUploadBackupRequest)
 	mux.HandleFunc("POST /api/backups/{id}/restore", h.handleRestoreBackupRequest)
 	mux.HandleFunc("DELETE /api/backups/{id}", h.handleDeleteBackupRequest)
+
+	mux.HandleFunc("GET /api/events", h.handleEventsRequest)
+
+	mux.HandleFunc("GET /api/jobs", h.handleListJobsRequest)
+	mux.HandleFunc("GET /api/jobs/{id}", h.handleGetJobRequest)
+	mux.HandleFunc("DELETE /api/jobs/{id}", h.handleCancelJobRequest)
 }
@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and runs functions after a delay, so scheduling can be driven by something else than real time
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a function scheduled by a Clock
+type Timer interface {
+	Stop() bool
+}
+
+// systemClock is the Clock of the system
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SystemClock is the Clock backed by the real time
+var SystemClock Clock = systemClock{}
+
+// Names of the jobs run by the scheduler
+const (
+	jobBackup = "backup" // Next scheduled backup
+	jobSync   = "sync"   // Periodic sync with Home Assistant and the destinations
+	jobVerify = "verify" // Next scheduled verification
+	jobRetry  = "retry"  // Earliest retry of a failed backup
+)
+
+// Scheduler runs named jobs after a delay, scheduling a job again replaces its previous run
+type Scheduler struct {
+	clock   Clock
+	mutex   sync.Mutex
+	jobs    map[string]*scheduledJob
+	running sync.WaitGroup
+	done    chan struct{}
+	stopped bool
+}
+
+// scheduledJob is a job waiting for its timer to fire
+type scheduledJob struct {
+	timer Timer
+}
+
+// NewScheduler returns a scheduler using the given clock
+func NewScheduler(clock Clock) *Scheduler {
+	return &Scheduler{
+		clock: clock,
+		jobs:  make(map[string]*scheduledJob),
+		done:  make(chan struct{}),
+	}
+}
+
+// Now returns the current time of the clock
+func (s *Scheduler) Now() time.Time {
+	return s.clock.Now()
+}
+
+// Schedule runs f after the given delay, replacing the pending run of the job with the same name
+func (s *Scheduler) Schedule(name string, delay time.Duration, f func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	if job, exists := s.jobs[name]; exists {
+		job.timer.Stop()
+	}
+
+	job := &scheduledJob{}
+	job.timer = s.clock.AfterFunc(delay, func() {
+		s.mutex.Lock()
+		// A job that was replaced or cancelled after its timer fired doesn't run
+		if s.stopped || s.jobs[name] != job {
+			s.mutex.Unlock()
+			return
+		}
+		delete(s.jobs, name)
+		s.running.Add(1)
+		s.mutex.Unlock()
+
+		defer s.running.Done()
+		f()
+	})
+	s.jobs[name] = job
+}
+
+// Cancel removes the pending run of a job
+func (s *Scheduler) Cancel(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if job, exists := s.jobs[name]; exists {
+		job.timer.Stop()
+		delete(s.jobs, name)
+	}
+}
+
+// Done returns a channel that's closed once the scheduler is stopped
+func (s *Scheduler) Done() <-chan struct{} {
+	return s.done
+}
+
+// Stop cancels all pending jobs and waits for running ones to finish or the context to be done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+
+		for name, job := range s.jobs {
+			job.timer.Stop()
+			delete(s.jobs, name)
+		}
+	}
+	s.mutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// operations tracks the backups with an ongoing operation, nothing else touches a backup in the meantime
+type operations struct {
+	mutex sync.Mutex
+	ids   map[string]bool // Whether the operation is exclusive by ID
+}
+
+// start marks an operation on a backup as ongoing, unless one already is.
+// An exclusive operation, like a sync touching every backup, only starts if there's no other ongoing operation
+// at all, and nothing else starts while it's ongoing.
+func (o *operations) start(id string, exclusive bool) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.ids == nil {
+		o.ids = make(map[string]bool)
+	}
+
+	if _, exists := o.ids[id]; exists || (exclusive && len(o.ids) > 0) {
+		return false
+	}
+
+	for _, ongoingExclusive := range o.ids {
+		if ongoingExclusive {
+			return false
+		}
+	}
+
+	o.ids[id] = exclusive
+	return true
+}
+
+// finish marks the operation on a backup as done
+func (o *operations) finish(id string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	delete(o.ids, id)
+}
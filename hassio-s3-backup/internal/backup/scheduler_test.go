@@ -0,0 +1,245 @@
+package backup
+
+import (
+	"context"
+	"hassio-proton-drive-backup/internal/config"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when it's advanced, running the functions that became due
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// fakeTimer is a function scheduled by a fakeClock
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	f     func()
+	done  bool // Fired or stopped
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	timer := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+
+	return timer
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+
+	stopped := !t.done
+	t.done = true
+
+	return stopped
+}
+
+// Advance moves the time forward and runs the functions that became due in order, on the calling goroutine
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	c.mutex.Unlock()
+
+	for {
+		c.mutex.Lock()
+		due := []*fakeTimer{}
+		for _, timer := range c.timers {
+			if !timer.done && !timer.at.After(end) {
+				due = append(due, timer)
+			}
+		}
+		if len(due) == 0 {
+			c.now = end
+			c.mutex.Unlock()
+			return
+		}
+
+		sort.SliceStable(due, func(i, j int) bool {
+			return due[i].at.Before(due[j].at)
+		})
+		timer := due[0]
+		timer.done = true
+		c.now = timer.at
+		c.mutex.Unlock()
+
+		timer.f()
+	}
+}
+
+func TestSchedulerRunsJobWhenDue(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	scheduler := NewScheduler(clock)
+
+	runs := 0
+	scheduler.Schedule(jobSync, time.Hour, func() { runs++ })
+
+	clock.Advance(59 * time.Minute)
+	if runs != 0 {
+		t.Fatalf("job ran %d times before it was due", runs)
+	}
+
+	clock.Advance(time.Minute)
+	if runs != 1 {
+		t.Fatalf("job ran %d times once due, want 1", runs)
+	}
+
+	clock.Advance(24 * time.Hour)
+	if runs != 1 {
+		t.Fatalf("job ran %d times, want it to run once", runs)
+	}
+}
+
+func TestSchedulerReplacesJob(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	scheduler := NewScheduler(clock)
+
+	ran := []string{}
+	scheduler.Schedule(jobBackup, time.Hour, func() { ran = append(ran, "first") })
+	scheduler.Schedule(jobBackup, 2*time.Hour, func() { ran = append(ran, "second") })
+	scheduler.Schedule(jobSync, 30*time.Minute, func() { ran = append(ran, "sync") })
+	scheduler.Cancel(jobSync)
+
+	clock.Advance(3 * time.Hour)
+	if len(ran) != 1 || ran[0] != "second" {
+		t.Fatalf("ran %v, want only the replacing job", ran)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	scheduler := NewScheduler(clock)
+
+	runs := 0
+	scheduler.Schedule(jobVerify, time.Hour, func() { runs++ })
+
+	if err := scheduler.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop returned error: %v", err)
+	}
+
+	select {
+	case <-scheduler.Done():
+	default:
+		t.Fatal("Done isn't closed after stopping")
+	}
+
+	scheduler.Schedule(jobRetry, time.Minute, func() { runs++ })
+	clock.Advance(2 * time.Hour)
+	if runs != 0 {
+		t.Fatalf("%d jobs ran after stopping", runs)
+	}
+}
+
+func TestNextBackup(t *testing.T) {
+	now := time.Date(2024, 9, 25, 1, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		options     config.Options
+		latest      *time.Time
+		wantIn      time.Duration
+		wantProfile string
+	}{
+		{
+			name:        "first backup of an interval",
+			options:     config.Options{BackupInterval: 3},
+			wantIn:      time.Second,
+			wantProfile: config.DefaultProfile,
+		},
+		{
+			name:        "interval after the latest backup",
+			options:     config.Options{BackupInterval: 3},
+			latest:      timePtr(now.Add(-24 * time.Hour)),
+			wantIn:      48 * time.Hour,
+			wantProfile: config.DefaultProfile,
+		},
+		{
+			name:        "schedule",
+			options:     config.Options{BackupSchedule: "0 3 * * *"},
+			latest:      timePtr(now.Add(-22 * time.Hour)),
+			wantIn:      2 * time.Hour,
+			wantProfile: config.DefaultProfile,
+		},
+		{
+			name:        "missed schedule runs right away",
+			options:     config.Options{BackupSchedule: "0 3 * * *", MissedBackups: config.MissedBackupsRun},
+			latest:      timePtr(now.Add(-48 * time.Hour)),
+			wantIn:      time.Second,
+			wantProfile: config.DefaultProfile,
+		},
+		{
+			name:        "missed schedule is skipped",
+			options:     config.Options{BackupSchedule: "0 3 * * *", MissedBackups: config.MissedBackupsSkip},
+			latest:      timePtr(now.Add(-48 * time.Hour)),
+			wantIn:      2 * time.Hour,
+			wantProfile: config.DefaultProfile,
+		},
+		{
+			name: "earliest profile",
+			options: config.Options{
+				BackupInterval: 1,
+				Profiles:       []config.Profile{{Name: "addons", Partial: true, Addons: []string{"core_ssh"}, Schedule: "30 1 * * *"}},
+			},
+			latest:      timePtr(now.Add(-12 * time.Hour)),
+			wantIn:      30 * time.Minute,
+			wantProfile: "addons",
+		},
+		{
+			name:        "nothing scheduled",
+			options:     config.Options{},
+			wantIn:      24 * time.Hour,
+			wantProfile: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.Timezone = time.UTC
+			s := newTestService(t, newFakeClock(now), &tt.options, nil)
+
+			if tt.latest != nil {
+				for _, profile := range tt.options.AllProfiles() {
+					s.store.add(&Backup{ID: profile.Name, Name: profile.Name, Profile: profile.Name, Date: *tt.latest})
+				}
+			}
+
+			in, profile := s.calculateDurationUntilNextBackup()
+			if in != tt.wantIn || profile != tt.wantProfile {
+				t.Errorf("next backup in %s of profile %q, want %s of profile %q", in, profile, tt.wantIn, tt.wantProfile)
+			}
+		})
+	}
+}
+
+func TestNextBackupIsScheduled(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 1, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{BackupSchedule: "0 3 * * *", Timezone: time.UTC}, nil)
+	s.store.add(&Backup{ID: "latest", Profile: config.DefaultProfile, Date: clock.Now().Add(-time.Hour)})
+
+	s.resetTimerForNextBackup()
+
+	at, profile := s.NextBackup()
+	if want := time.Date(2024, 9, 25, 3, 0, 0, 0, time.UTC); !at.Equal(want) || profile != config.DefaultProfile {
+		t.Fatalf("next backup at %s of profile %q, want %s of the default profile", at, profile, want)
+	}
+}
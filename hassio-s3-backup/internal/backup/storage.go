@@ -0,0 +1,39 @@
+package backup
+
+import (
+	"context"
+	"hassio-proton-drive-backup/internal/s3"
+	"io"
+)
+
+// Storage is a remote target that backups are synchronized to
+type Storage interface {
+	// Put stores the content of the reader under the given key, along with the metadata
+	Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) (*s3.Object, error)
+	// Stat returns the attributes and metadata of the object with the given key
+	Stat(ctx context.Context, key string) (*s3.Object, error)
+	// List returns all objects in the storage
+	List(ctx context.Context) ([]*s3.Object, error)
+	// Get returns a reader for the object with the given key, an io.ReadSeekCloser if the storage can seek
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object with the given key
+	Delete(ctx context.Context, key string) error
+}
+
+// ViewStorage is the read-only part of a storage, used for the backups of other instances
+type ViewStorage interface {
+	// Stat returns the attributes and metadata of the object with the given key
+	Stat(ctx context.Context, key string) (*s3.Object, error)
+	// List returns all objects in the storage
+	List(ctx context.Context) ([]*s3.Object, error)
+}
+
+// ResumableStorage is a storage that uploads in parts and can continue an interrupted upload
+type ResumableStorage interface {
+	// PutResumable stores the content returned by open, continuing the given upload if possible
+	PutResumable(ctx context.Context, key string, open func(offset int64) (io.ReadCloser, error), size int64, metadata map[string]string, upload *s3.Upload, save func(*s3.Upload)) (*s3.Object, error)
+	// IncompleteUploads returns the uploads that were never completed or aborted
+	IncompleteUploads(ctx context.Context) ([]*s3.Upload, error)
+	// AbortUpload aborts an upload and removes its parts
+	AbortUpload(ctx context.Context, key string, id string) error
+}
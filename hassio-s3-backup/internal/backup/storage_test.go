@@ -0,0 +1,195 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hassio-proton-drive-backup/internal/s3"
+	"io"
+	"io/fs"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStorage is a Storage keeping objects in memory
+type memoryStorage struct {
+	mutex   *sync.Mutex // Shared with the storages of other prefixes
+	clock   Clock
+	objects map[string]*memoryObject // By key including the prefix
+	prefix  string                   // Prepended to every key, like the key prefix of an instance
+	deleted []string                 // Keys in the order they were deleted
+	stats   int                      // Number of times Stat was called
+	statErr error                    // Returned by Stat when set, like a destination that can't read metadata
+	putErr  error                    // Returned by Put when set, like a destination that can't be reached
+}
+
+// memoryObject is an object in a memoryStorage
+type memoryObject struct {
+	data     []byte
+	modified time.Time
+	metadata map[string]string
+}
+
+func newMemoryStorage(clock Clock) *memoryStorage {
+	return &memoryStorage{mutex: &sync.Mutex{}, clock: clock, objects: make(map[string]*memoryObject)}
+}
+
+// withPrefix returns a storage for the objects under a key prefix below this one, sharing the objects like a bucket
+func (m *memoryStorage) withPrefix(prefix string) *memoryStorage {
+	return &memoryStorage{mutex: m.mutex, clock: m.clock, objects: m.objects, prefix: m.prefix + prefix}
+}
+
+func (m *memoryStorage) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) (*s3.Object, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.putErr != nil {
+		return nil, m.putErr
+	}
+
+	object := &memoryObject{data: data, modified: m.clock.Now(), metadata: maps.Clone(metadata)}
+	m.objects[m.prefix+key] = object
+
+	return object.info(key, false), nil
+}
+
+func (m *memoryStorage) Stat(ctx context.Context, key string) (*s3.Object, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.stats++
+	if m.statErr != nil {
+		return nil, m.statErr
+	}
+
+	object, exists := m.objects[m.prefix+key]
+	if !exists {
+		return nil, fmt.Errorf("stat %s: %w", key, fs.ErrNotExist)
+	}
+
+	return object.info(key, true), nil
+}
+
+func (m *memoryStorage) List(ctx context.Context) ([]*s3.Object, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Like S3, only objects directly under the prefix are listed
+	objects := []*s3.Object{}
+	for key, object := range m.objects {
+		relative, found := strings.CutPrefix(key, m.prefix)
+		if !found || strings.Contains(relative, "/") {
+			continue
+		}
+		objects = append(objects, object.info(relative, false))
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return objects, nil
+}
+
+func (m *memoryStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	object, exists := m.objects[m.prefix+key]
+	if !exists {
+		return nil, fmt.Errorf("get %s: %w", key, fs.ErrNotExist)
+	}
+
+	return memoryReader{bytes.NewReader(object.data)}, nil
+}
+
+func (m *memoryStorage) Delete(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.objects, m.prefix+key)
+	m.deleted = append(m.deleted, key)
+
+	return nil
+}
+
+// putObject stores an object directly, like another client or an earlier version of the add-on did
+func (m *memoryStorage) putObject(key string, data []byte, modified time.Time, metadata map[string]string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.objects[m.prefix+key] = &memoryObject{data: data, modified: modified, metadata: metadata}
+}
+
+// info returns the attributes of the object, listing doesn't return the metadata like S3
+func (o *memoryObject) info(key string, withMetadata bool) *s3.Object {
+	info := &s3.Object{
+		Key:      key,
+		Modified: o.modified,
+		Size:     float64(len(o.data)) / (1024 * 1024),
+	}
+	if withMetadata {
+		info.Metadata = maps.Clone(o.metadata)
+	}
+
+	return info
+}
+
+// memoryReader reads and seeks an object of a memoryStorage
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+// backupTarball returns a Home Assistant backup tarball with the given manifest and the parts it lists
+func backupTarball(t *testing.T, manifest Manifest) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extension := ".tar"
+	if manifest.Compressed {
+		extension = ".tar.gz"
+	}
+
+	files := map[string][]byte{"./" + manifestFile: data}
+	if manifest.HomeAssistant != nil {
+		files["./homeassistant"+extension] = []byte("configuration")
+	}
+	for _, addon := range manifest.Addons {
+		files["./"+addon.Slug+extension] = []byte("add-on")
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,194 @@
+package backup
+
+import (
+	"encoding/json"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"os"
+	"sort"
+	"sync"
+)
+
+// stateFile is where the tracked backups are persisted
+const stateFile = "/data/backups.json"
+
+// store holds the tracked backups. Operations hold its lock for as long as they read or change backups,
+// and release it around slow I/O so the API stays responsive. Readers outside the service get copies.
+type store struct {
+	sync.RWMutex
+	backups []*Backup
+	path    string // File the backups are persisted to
+}
+
+// snapshot returns a copy of every backup that isn't affected by changes made afterwards
+func (st *store) snapshot() []*Backup {
+	st.RLock()
+	defer st.RUnlock()
+
+	backups := make([]*Backup, 0, len(st.backups))
+	for _, backup := range st.backups {
+		backups = append(backups, backup.clone())
+	}
+
+	return backups
+}
+
+// update runs f while holding the lock, for changes made while the lock is released for I/O
+func (st *store) update(f func()) {
+	st.Lock()
+	defer st.Unlock()
+
+	f()
+}
+
+// unlocked releases the lock held by the caller while f runs slow I/O.
+// Other operations may run in the meantime, so f may only read the backup the caller has an ongoing operation on,
+// and has to change it through update.
+func (st *store) unlocked(f func()) {
+	st.Unlock()
+	defer st.Lock()
+
+	f()
+}
+
+// add starts tracking a backup, newest first. The lock must be held.
+func (st *store) add(backup *Backup) {
+	st.backups = append([]*Backup{backup}, st.backups...)
+}
+
+// get returns the backup with the given ID and its index, or nil if it isn't tracked. The lock must be held.
+func (st *store) get(id string) (int, *Backup) {
+	for i, b := range st.backups {
+		if b.ID == id {
+			return i, b
+		}
+	}
+
+	return -1, nil
+}
+
+// remove stops tracking the backup with the given ID. The lock must be held.
+func (st *store) remove(id string) {
+	if i, backup := st.get(id); backup != nil {
+		st.backups = append(st.backups[:i], st.backups[i+1:]...)
+	}
+}
+
+// retain stops tracking the backups that keep returns false for. The lock must be held.
+func (st *store) retain(keep func(*Backup) bool) {
+	backups := []*Backup{}
+	for _, backup := range st.backups {
+		if keep(backup) {
+			backups = append(backups, backup)
+		}
+	}
+
+	st.backups = backups
+}
+
+// reset stops tracking all backups. The lock must be held.
+func (st *store) reset() {
+	st.backups = []*Backup{}
+}
+
+// sort orders the backups newest first. The lock must be held.
+func (st *store) sort() {
+	sort.Slice(st.backups, func(i, j int) bool {
+		return st.backups[i].Date.After(st.backups[j].Date)
+	})
+}
+
+// load replaces the backups with the ones persisted on disk. The lock must be held.
+func (st *store) load() error {
+	data, err := os.ReadFile(st.path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &st.backups)
+}
+
+// save persists the backups to disk. The lock must be held, for reading at least.
+func (st *store) save() error {
+	data, err := json.Marshal(st.backups)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(st.path, data, 0644)
+}
+
+// clone returns a deep copy of the backup
+func (b *Backup) clone() *Backup {
+	c := *b
+
+	if b.Remotes != nil {
+		c.Remotes = make(map[string]*s3.Object, len(b.Remotes))
+		for destination, remote := range b.Remotes {
+			c.Remotes[destination] = cloneObject(remote)
+		}
+	}
+
+	c.HA = cloneHABackup(b.HA)
+
+	if b.Uploads != nil {
+		c.Uploads = make(map[string]*Upload, len(b.Uploads))
+		for destination, upload := range b.Uploads {
+			u := *upload
+			u.Multipart = cloneMultipart(upload.Multipart)
+			c.Uploads[destination] = &u
+		}
+	}
+
+	if b.VerifiedAt != nil {
+		verifiedAt := *b.VerifiedAt
+		c.VerifiedAt = &verifiedAt
+	}
+
+	if b.NextRetry != nil {
+		nextRetry := *b.NextRetry
+		c.NextRetry = &nextRetry
+	}
+
+	// Manifests are replaced as a whole and never changed, so they can be shared
+	return &c
+}
+
+// cloneObject returns a copy of a remote object with its own metadata
+func cloneObject(object *s3.Object) *s3.Object {
+	if object == nil {
+		return nil
+	}
+
+	c := *object
+	if object.Metadata != nil {
+		c.Metadata = make(map[string]string, len(object.Metadata))
+		for k, v := range object.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+
+	return &c
+}
+
+// cloneMultipart returns a copy of a multipart upload with its own parts
+func cloneMultipart(upload *s3.Upload) *s3.Upload {
+	if upload == nil {
+		return nil
+	}
+
+	c := *upload
+	c.Parts = append([]s3.Part(nil), upload.Parts...)
+
+	return &c
+}
+
+// cloneHABackup returns a copy of a backup in Home Assistant
+func cloneHABackup(backup *hassio.Backup) *hassio.Backup {
+	if backup == nil {
+		return nil
+	}
+
+	c := *backup
+	return &c
+}
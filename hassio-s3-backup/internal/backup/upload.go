@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"context"
+	"hassio-proton-drive-backup/internal/crypt"
+	"hassio-proton-drive-backup/internal/s3"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// staleUploadAge is how old an incomplete upload no backup is going to resume must be before it's aborted
+const staleUploadAge = 24 * time.Hour
+
+// Object metadata keys stored with every uploaded backup
+const (
+	checksumMetadata = "sha256" // SHA-256 checksum of the unencrypted backup
+	slugMetadata     = "slug"   // Slug of the backup in Home Assistant
+	dateMetadata     = "date"   // Date the backup was created
+	typeMetadata     = "type"   // Full or partial
+)
+
+// Upload is an interrupted upload of a backup to a destination, persisted so it can be resumed
+type Upload struct {
+	Multipart        *s3.Upload `json:"multipart"`
+	EncryptionHeader []byte     `json:"encryptionHeader,omitempty"`
+}
+
+// readCloser reads from a reader and closes every closer
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+// Close closes every closer, returning the first error
+func (rc *readCloser) Close() error {
+	var err error
+	for _, c := range rc.closers {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// openBackupAt opens a backup tarball at an offset of the uploaded data.
+// If a secret is given the data is encrypted as the stream with the given header.
+func openBackupAt(path string, secret []byte, header []byte, offset int64) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if secret == nil {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return file, nil
+	}
+
+	// Encrypt the backup on the fly
+	pr, pw := io.Pipe()
+
+	go func() {
+		cw, plainOffset, err := crypt.NewWriterAt(pw, secret, header, offset)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		if _, err := file.Seek(plainOffset, io.SeekStart); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		if _, err := io.Copy(cw, file); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		pw.CloseWithError(cw.Close())
+	}()
+
+	return &readCloser{Reader: pr, closers: []io.Closer{pr, file}}, nil
+}
+
+// abortStaleUploads aborts old incomplete uploads in each destination that no backup is going to resume,
+// for example those left behind when a backup was deleted before its upload finished
+func (s *Service) abortStaleUploads(ctx context.Context) {
+	for name, storage := range s.storages {
+		resumable, ok := storage.(ResumableStorage)
+		if !ok {
+			continue
+		}
+
+		var uploads []*s3.Upload
+		var err error
+		s.store.unlocked(func() {
+			uploads, err = resumable.IncompleteUploads(ctx)
+		})
+		if err != nil {
+			slog.Warn("could not list incomplete uploads", "destination", name, "error", err)
+			continue
+		}
+
+		for _, upload := range uploads {
+			if s.resumesUpload(name, upload.ID) || s.scheduler.Now().Sub(upload.Initiated) < staleUploadAge {
+				continue
+			}
+
+			s.store.unlocked(func() {
+				err = resumable.AbortUpload(ctx, upload.Key, upload.ID)
+			})
+			if err != nil {
+				slog.Warn("could not abort stale upload", "destination", name, "key", upload.Key, "error", err)
+				continue
+			}
+
+			slog.Info("aborted stale upload", "destination", name, "key", upload.Key, "initiated", upload.Initiated)
+		}
+	}
+}
+
+// resumesUpload returns true if a backup is going to resume the upload to a destination
+func (s *Service) resumesUpload(destination string, id string) bool {
+	for _, backup := range s.store.backups {
+		if upload, exists := backup.Uploads[destination]; exists && upload.Multipart != nil && upload.Multipart.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,271 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/crypt"
+	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/s3"
+	"io"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestUploadResumesAfterInterruption(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse battery staple"} {
+		t.Run(fmt.Sprintf("encrypted %t", passphrase != ""), func(t *testing.T) {
+			clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+			storage := newResumableMemoryStorage(clock, 512)
+			options := &config.Options{
+				Timezone:     time.UTC,
+				Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+				Encryption:   config.EncryptionOptions{Passphrase: passphrase},
+			}
+			s := newTestService(t, clock, options, map[string]Storage{"s3": storage})
+
+			manifest := Manifest{Slug: "aaaa1111", Name: "Full Backup", Date: clock.Now(), Type: "full", HomeAssistant: &ManifestHomeAssistant{Version: "2024.9.3"}}
+			tarball := backupTarball(t, manifest)
+			if err := os.WriteFile(s.backupDir+"/aaaa1111.tar", tarball, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			s.store.Lock()
+			backup := &Backup{ID: "backup", Name: "Full Backup", Slug: "aaaa1111", HA: &hassio.Backup{Slug: "aaaa1111"}, Profile: config.DefaultProfile, Date: clock.Now(), Remotes: map[string]*s3.Object{}}
+			s.store.add(backup)
+
+			// The connection drops after 2 parts
+			storage.failAfter = 2
+			err := s.syncBackupToDestination(context.Background(), backup, options.Destinations[0])
+			s.store.Unlock()
+
+			if err == nil {
+				t.Fatal("interrupted upload returned no error")
+			}
+
+			// The add-on restarts and resumes the upload it persisted
+			restarted := newTestService(t, clock, options, map[string]Storage{"s3": storage})
+			restarted.store.path = s.store.path
+			restarted.backupDir = s.backupDir
+
+			restarted.store.Lock()
+			defer restarted.store.Unlock()
+
+			restarted.loadBackupsFromFile()
+			_, backup = restarted.store.get("backup")
+			if upload := backup.Uploads["s3"]; upload == nil || upload.Multipart == nil || len(upload.Multipart.Parts) != 2 {
+				t.Fatalf("interrupted upload persisted as %+v, want its 2 parts", upload)
+			}
+
+			storage.failAfter = 0
+			storage.uploadedParts = 0
+			if err := restarted.syncBackupToDestination(context.Background(), backup, options.Destinations[0]); err != nil {
+				t.Fatalf("resumed upload returned error: %v", err)
+			}
+
+			if len(backup.Uploads) != 0 || backup.Remotes["s3"] == nil {
+				t.Fatalf("backup has uploads %v and remotes %v after resuming, want only the remote", backup.Uploads, backup.Remotes)
+			}
+			if len(storage.uploads) != 0 {
+				t.Errorf("%d uploads are left incomplete", len(storage.uploads))
+			}
+
+			data, err := storage.data(backup.Remotes["s3"].Key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := int64(len(tarball))
+			if passphrase != "" {
+				size = crypt.EncryptedSize(size)
+				if data, err = decryptData(data, passphrase); err != nil {
+					t.Fatalf("decrypting the uploaded backup returned error: %v", err)
+				}
+			}
+
+			if !bytes.Equal(data, tarball) {
+				t.Errorf("uploaded backup of %d bytes doesn't match the %d bytes of the tarball", len(data), len(tarball))
+			}
+			if parts := int((size + 511) / 512); storage.uploadedParts != parts-2 {
+				t.Errorf("resumed upload uploaded %d parts, want the %d parts left", storage.uploadedParts, parts-2)
+			}
+		})
+	}
+}
+
+func TestAbortStaleUploads(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	storage := newResumableMemoryStorage(clock, 512)
+	s := newTestService(t, clock, &config.Options{
+		Timezone:     time.UTC,
+		Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+	}, map[string]Storage{"s3": storage, "nas": newMemoryStorage(clock)})
+
+	storage.startUpload("stale", "Deleted.tar", clock.Now().Add(-48*time.Hour))
+	storage.startUpload("resumed", "Interrupted.tar", clock.Now().Add(-48*time.Hour))
+	storage.startUpload("recent", "Running.tar", clock.Now().Add(-time.Hour))
+
+	s.store.Lock()
+	s.store.add(&Backup{ID: "interrupted", Name: "Interrupted", Uploads: map[string]*Upload{
+		"s3": {Multipart: &s3.Upload{ID: "resumed", Key: "Interrupted.tar"}},
+	}})
+	s.abortStaleUploads(context.Background())
+	s.store.Unlock()
+
+	ids := []string{}
+	for id := range storage.uploads {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	if len(ids) != 2 || ids[0] != "recent" || ids[1] != "resumed" {
+		t.Errorf("uploads %v are left, want only the recent one and the one a backup resumes", ids)
+	}
+}
+
+// resumableMemoryStorage is a memoryStorage that uploads in parts of the given size, like S3 does,
+// and can fail after a number of parts to interrupt an upload
+type resumableMemoryStorage struct {
+	*memoryStorage
+	partSize      int64
+	uploads       map[string]*memoryUpload // By upload ID
+	nextID        int
+	failAfter     int // Parts uploaded before uploads fail, 0 for never
+	uploadedParts int // Parts uploaded since it was last reset
+}
+
+// memoryUpload is an incomplete upload to a resumableMemoryStorage
+type memoryUpload struct {
+	key       string
+	initiated time.Time
+	metadata  map[string]string
+	parts     map[int][]byte
+}
+
+func newResumableMemoryStorage(clock Clock, partSize int64) *resumableMemoryStorage {
+	return &resumableMemoryStorage{
+		memoryStorage: newMemoryStorage(clock),
+		partSize:      partSize,
+		uploads:       make(map[string]*memoryUpload),
+	}
+}
+
+func (m *resumableMemoryStorage) PutResumable(ctx context.Context, key string, open func(offset int64) (io.ReadCloser, error), size int64, metadata map[string]string, upload *s3.Upload, save func(*s3.Upload)) (*s3.Object, error) {
+	m.mutex.Lock()
+	if upload != nil {
+		if _, exists := m.uploads[upload.ID]; !exists || upload.Key != key || upload.Size != size {
+			upload = nil
+		}
+	}
+	if upload == nil {
+		m.nextID++
+		upload = &s3.Upload{ID: fmt.Sprint(m.nextID), Key: key, Size: size, PartSize: m.partSize, Initiated: m.clock.Now()}
+		m.uploads[upload.ID] = &memoryUpload{key: key, initiated: upload.Initiated, metadata: metadata, parts: make(map[int][]byte)}
+		m.mutex.Unlock()
+		save(upload)
+		m.mutex.Lock()
+	}
+	uploaded := m.uploads[upload.ID]
+	m.mutex.Unlock()
+
+	// Continue after the parts uploaded without gaps
+	number := 1
+	for _, part := range upload.Parts {
+		if part.Number == number {
+			number++
+		}
+	}
+	offset := int64(number-1) * upload.PartSize
+
+	r, err := open(offset)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for ; offset < size; number++ {
+		if m.failAfter > 0 && m.uploadedParts == m.failAfter {
+			return nil, errors.New("connection reset by peer")
+		}
+
+		buf := make([]byte, min(upload.PartSize, size-offset))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		offset += int64(len(buf))
+
+		m.mutex.Lock()
+		uploaded.parts[number] = buf
+		m.uploadedParts++
+		m.mutex.Unlock()
+
+		upload.Parts = append(upload.Parts, s3.Part{Number: number, ETag: fmt.Sprint(number), Size: int64(len(buf))})
+		save(upload)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var data []byte
+	for i := 1; i <= len(uploaded.parts); i++ {
+		data = append(data, uploaded.parts[i]...)
+	}
+	delete(m.uploads, upload.ID)
+
+	object := &memoryObject{data: data, modified: m.clock.Now(), metadata: uploaded.metadata}
+	m.objects[key] = object
+
+	return object.info(key, false), nil
+}
+
+func (m *resumableMemoryStorage) IncompleteUploads(ctx context.Context) ([]*s3.Upload, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	uploads := []*s3.Upload{}
+	for id, upload := range m.uploads {
+		uploads = append(uploads, &s3.Upload{ID: id, Key: upload.key, Initiated: upload.initiated})
+	}
+
+	return uploads, nil
+}
+
+func (m *resumableMemoryStorage) AbortUpload(ctx context.Context, key string, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.uploads, id)
+	return nil
+}
+
+// startUpload starts an upload that's never completed, like one left behind by a backup deleted during its upload
+func (m *resumableMemoryStorage) startUpload(id string, key string, initiated time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.uploads[id] = &memoryUpload{key: key, initiated: initiated, parts: make(map[int][]byte)}
+}
+
+// data returns the content of an object
+func (m *memoryStorage) data(key string) ([]byte, error) {
+	r, err := m.Get(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// decryptData decrypts data encrypted with the passphrase
+func decryptData(data []byte, passphrase string) ([]byte, error) {
+	r, err := crypt.NewReader(bytes.NewReader(data), []byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,224 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hassio-proton-drive-backup/internal/cron"
+	"hassio-proton-drive-backup/internal/crypt"
+	"hassio-proton-drive-backup/internal/s3"
+	"hassio-proton-drive-backup/internal/throttle"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var (
+	errChecksumMismatch = errors.New("checksum mismatch")
+	errNoChecksum       = errors.New("no checksum recorded")
+)
+
+// checksumFile returns the hex encoded SHA-256 checksum of a file
+func checksumFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// VerifyBackup downloads a backup from every destination that has it and compares it to its checksum.
+// The backup is marked as corrupt if any copy doesn't match.
+func (s *Service) VerifyBackup(ctx context.Context, id string) error {
+	s.store.Lock()
+	defer s.store.Unlock()
+
+	_, backup := s.store.get(id)
+	if backup == nil {
+		return errBackupNotFound
+	}
+
+	if len(backup.Remotes) == 0 {
+		return fmt.Errorf("backup isn't in any destination")
+	}
+
+	if !s.ongoing.start(backup.ID, false) {
+		return fmt.Errorf("backup is busy")
+	}
+	defer s.ongoing.finish(backup.ID)
+
+	// Corrupt backups show up in the failed entity right away
+	defer s.updateEntities()
+
+	slog.Info("verifying backup", "name", backup.Name)
+	previousStatus := backup.Status
+	s.setStatus(backup, StatusVerifying)
+
+	mismatches := []string{}
+	verified := 0
+	for _, d := range s.config().Destinations {
+		remote, exists := backup.Remotes[d.Name]
+		if !exists {
+			continue
+		}
+
+		var recorded string
+		var err error
+		expected := backup.Checksum
+		s.store.unlocked(func() {
+			recorded, err = s.verifyRemote(ctx, backup, d.Name, remote, expected)
+		})
+		clearProgress(backup)
+
+		// Backups found in a destination take their checksum from the object metadata
+		if backup.Checksum == "" && recorded != "" {
+			backup.Checksum = recorded
+		}
+
+		switch {
+		case err == nil:
+			verified++
+		case errors.Is(err, errChecksumMismatch), errors.Is(err, crypt.ErrAuthentication), errors.Is(err, crypt.ErrInvalidFormat):
+			slog.Error("backup failed verification", "name", backup.Name, "destination", d.Name, "error", err)
+			mismatches = append(mismatches, fmt.Sprintf("%s: %v", d.Name, err))
+		case errors.Is(err, errNoChecksum):
+			slog.Warn("backup has no checksum to verify against", "name", backup.Name, "destination", d.Name)
+		default:
+			slog.Error("failed to verify backup", "name", backup.Name, "destination", d.Name, "error", err)
+			s.setStatus(backup, previousStatus)
+			return err
+		}
+	}
+
+	if len(mismatches) > 0 {
+		backup.ErrorMessage = "Verification failed in " + strings.Join(mismatches, ", ")
+		s.setStatus(backup, StatusCorrupt)
+		s.webhooks.notify(webhookCorrupt, backup, "", "")
+		return s.saveBackupsToFile()
+	}
+
+	if verified > 0 {
+		now := s.scheduler.Now()
+		backup.VerifiedAt = &now
+		slog.Info("backup verified", "name", backup.Name)
+	}
+
+	// A backup that is no longer corrupt gets its status from where it's stored
+	if previousStatus == StatusCorrupt {
+		backup.ErrorMessage = ""
+		s.setStatus(backup, StatusPending)
+		s.updateStatus(backup)
+	} else {
+		s.setStatus(backup, previousStatus)
+	}
+
+	return s.saveBackupsToFile()
+}
+
+// verifyRemote streams a backup from a destination and compares its checksum to the expected one,
+// returning the checksum recorded in the object metadata. It runs without holding the lock of the store.
+func (s *Service) verifyRemote(ctx context.Context, backup *Backup, destination string, remote *s3.Object, expected string) (string, error) {
+	storage := s.storages[destination]
+
+	object, err := storage.Stat(ctx, remote.Key)
+	if err != nil {
+		return "", err
+	}
+
+	recorded := object.Metadata[checksumMetadata]
+	if recorded != "" {
+		if expected == "" {
+			expected = recorded
+		} else if recorded != expected {
+			return recorded, fmt.Errorf("%w: object metadata has %s, expected %s", errChecksumMismatch, recorded, expected)
+		}
+	}
+
+	if expected == "" {
+		return recorded, errNoChecksum
+	}
+
+	r, err := storage.Get(ctx, remote.Key)
+	if err != nil {
+		return recorded, err
+	}
+	defer r.Close()
+
+	var reader io.Reader = throttle.NewReader(ctx, r, s.config().TransferLimit())
+	reader = newProgressReader(reader, &s.store, s.events, backup, 0, int64(remote.Size*1024*1024))
+	if strings.HasSuffix(remote.Key, crypt.Suffix) {
+		secret, err := s.config().Encryption.Secret()
+		if err != nil {
+			return recorded, err
+		}
+
+		if reader, err = crypt.NewReader(reader, secret); err != nil {
+			return recorded, err
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return recorded, err
+	}
+
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return recorded, fmt.Errorf("%w: got %s, expected %s", errChecksumMismatch, actual, expected)
+	}
+
+	return recorded, nil
+}
+
+// verifyBackups queues a verification of every backup that is stored in a destination
+func (s *Service) verifyBackups() {
+	s.store.RLock()
+	ids := []string{}
+	for _, backup := range s.store.backups {
+		if len(backup.Remotes) > 0 {
+			ids = append(ids, backup.ID)
+		}
+	}
+	s.store.RUnlock()
+
+	for _, id := range ids {
+		if _, err := s.QueueOperation(OperationVerify, id); err != nil {
+			slog.Error("failed to queue backup verification", "id", id, "error", err)
+		}
+	}
+}
+
+// scheduleVerify schedules the next verification of all backups, if a verify schedule is configured
+func (s *Service) scheduleVerify() {
+	if s.config().VerifySchedule == "" {
+		return
+	}
+
+	schedule, err := cron.Parse(s.config().VerifySchedule)
+	if err != nil {
+		slog.Error("invalid verify schedule", "error", err)
+		return
+	}
+
+	now := s.scheduler.Now().In(s.config().Timezone)
+	next := schedule.Next(now)
+	if next.IsZero() {
+		slog.Warn("verify schedule never matches, scheduled verification is disabled")
+		return
+	}
+
+	s.scheduler.Schedule(jobVerify, next.Sub(now), func() {
+		slog.Info("performing scheduled backup verification")
+		s.verifyBackups()
+		s.scheduleVerify()
+	})
+}
@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/crypt"
+	"hassio-proton-drive-backup/internal/s3"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	const passphrase = "correct horse battery staple"
+	data := []byte("backup tarball")
+	checksum := sha256Hex(data)
+
+	tests := []struct {
+		name      string
+		encrypted bool
+		stored    func(data []byte) []byte // Changes the stored object, like a destination corrupting it
+		metadata  string                   // Checksum in the object metadata
+		expected  string                   // Checksum of the backup
+		previous  status
+		status    status
+		checksum  string // Checksum of the backup after the verification
+		verified  bool
+	}{
+		{name: "matches", metadata: checksum, expected: checksum, previous: StatusSynced, status: StatusSynced, checksum: checksum, verified: true},
+		{name: "tampered", stored: tamper, metadata: checksum, expected: checksum, previous: StatusSynced, status: StatusCorrupt, checksum: checksum},
+		{name: "metadata differs", metadata: sha256Hex([]byte("other")), expected: checksum, previous: StatusSynced, status: StatusCorrupt, checksum: checksum},
+		{name: "checksum from metadata", metadata: checksum, previous: StatusS3Only, status: StatusS3Only, checksum: checksum, verified: true},
+		{name: "no checksum", previous: StatusS3Only, status: StatusS3Only},
+		{name: "no checksum in metadata", expected: checksum, previous: StatusSynced, status: StatusSynced, checksum: checksum, verified: true},
+		{name: "corrupt backup passes", metadata: checksum, expected: checksum, previous: StatusCorrupt, status: StatusS3Only, checksum: checksum, verified: true},
+		{name: "encrypted", encrypted: true, metadata: checksum, expected: checksum, previous: StatusSynced, status: StatusSynced, checksum: checksum, verified: true},
+		{name: "encrypted and tampered", encrypted: true, stored: tamper, metadata: checksum, expected: checksum, previous: StatusSynced, status: StatusCorrupt, checksum: checksum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+			storage := newMemoryStorage(clock)
+			options := &config.Options{
+				Timezone:     time.UTC,
+				Destinations: []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+				Encryption:   config.EncryptionOptions{Passphrase: passphrase},
+			}
+			s := newTestService(t, clock, options, map[string]Storage{"s3": storage})
+
+			key, stored := "backup.tar", data
+			if tt.encrypted {
+				key, stored = key+crypt.Suffix, encryptData(t, data, passphrase)
+			}
+			if tt.stored != nil {
+				stored = tt.stored(stored)
+			}
+			metadata := map[string]string{}
+			if tt.metadata != "" {
+				metadata[checksumMetadata] = tt.metadata
+			}
+			storage.putObject(key, stored, clock.Now(), metadata)
+
+			backup := &Backup{
+				ID:       "backup",
+				Name:     "Full Backup",
+				Profile:  config.DefaultProfile,
+				Date:     clock.Now(),
+				Status:   tt.previous,
+				Checksum: tt.expected,
+				Remotes:  map[string]*s3.Object{"s3": {Key: key, Size: float64(len(stored)) / (1024 * 1024)}},
+			}
+			if tt.previous == StatusCorrupt {
+				backup.ErrorMessage = "Verification failed in s3"
+			}
+			s.store.add(backup)
+
+			if err := s.VerifyBackup(context.Background(), "backup"); err != nil {
+				t.Fatalf("VerifyBackup returned error: %v", err)
+			}
+
+			s.store.RLock()
+			defer s.store.RUnlock()
+
+			if backup.Status != tt.status {
+				t.Errorf("verified backup is %s, want %s", backup.Status, tt.status)
+			}
+			if backup.Checksum != tt.checksum {
+				t.Errorf("verified backup has checksum %q, want %q", backup.Checksum, tt.checksum)
+			}
+			if verified := backup.VerifiedAt != nil; verified != tt.verified {
+				t.Errorf("backup verified at %v, want verified %t", backup.VerifiedAt, tt.verified)
+			}
+			if corrupt := strings.HasPrefix(backup.ErrorMessage, "Verification failed in s3"); corrupt != (tt.status == StatusCorrupt) {
+				t.Errorf("verified backup has error message %q", backup.ErrorMessage)
+			}
+			if backup.Progress != 0 || backup.BytesTotal != 0 {
+				t.Errorf("verified backup has transfer progress %v%% of %d bytes, want it cleared", backup.Progress, backup.BytesTotal)
+			}
+		})
+	}
+}
+
+func TestVerifyBackupWithoutDestination(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	s := newTestService(t, clock, &config.Options{Timezone: time.UTC}, nil)
+
+	s.store.add(&Backup{ID: "backup", Name: "Full Backup", Profile: config.DefaultProfile, Date: clock.Now(), Status: StatusHAOnly})
+
+	if err := s.VerifyBackup(context.Background(), "backup"); err == nil {
+		t.Error("verifying a backup that isn't in any destination returned no error")
+	}
+	if err := s.VerifyBackup(context.Background(), "missing"); err != errBackupNotFound {
+		t.Errorf("verifying an unknown backup returned %v, want %v", err, errBackupNotFound)
+	}
+}
+
+func TestScheduleVerify(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC))
+	storage := newMemoryStorage(clock)
+	options := &config.Options{
+		Timezone:       time.UTC,
+		Destinations:   []config.DestinationOptions{{Name: "s3", Type: config.DestinationS3}},
+		VerifySchedule: "0 3 * * *",
+	}
+	s := newTestService(t, clock, options, map[string]Storage{"s3": storage})
+
+	data := []byte("backup tarball")
+	storage.putObject("stored.tar", data, clock.Now(), map[string]string{checksumMetadata: sha256Hex(data)})
+	storage.putObject("tampered.tar", tamper(data), clock.Now(), map[string]string{checksumMetadata: sha256Hex(data)})
+
+	for _, id := range []string{"stored", "tampered"} {
+		s.store.add(&Backup{ID: id, Name: id, Profile: config.DefaultProfile, Date: clock.Now(), Status: StatusS3Only, Remotes: map[string]*s3.Object{"s3": {Key: id + ".tar"}}})
+	}
+	s.store.add(&Backup{ID: "local", Name: "local", Profile: config.DefaultProfile, Date: clock.Now(), Status: StatusHAOnly})
+
+	s.scheduleVerify()
+
+	// Nothing is verified before the schedule matches
+	clock.Advance(14 * time.Hour)
+	if jobs := s.jobs.list(); len(jobs) != 0 {
+		t.Fatalf("%d jobs queued before the verify schedule, want none", len(jobs))
+	}
+
+	// Only backups stored in a destination are verified, and the next verification is scheduled
+	clock.Advance(time.Hour)
+	jobs := s.jobs.list()
+	if len(jobs) != 2 {
+		t.Fatalf("scheduled verification queued %d jobs, want one for each backup in a destination", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.Operation != OperationVerify {
+			t.Errorf("scheduled verification queued %s of %s", job.Operation, job.BackupID)
+		}
+		waitForJob(t, s.jobs, job.ID, JobSucceeded)
+	}
+
+	s.store.RLock()
+	for id, want := range map[string]status{"stored": StatusS3Only, "tampered": StatusCorrupt, "local": StatusHAOnly} {
+		if _, backup := s.store.get(id); backup.Status != want {
+			t.Errorf("backup %s is %s after the scheduled verification, want %s", id, backup.Status, want)
+		}
+	}
+	s.store.RUnlock()
+
+	clock.Advance(24 * time.Hour)
+	if jobs := s.jobs.list(); len(jobs) != 4 {
+		t.Errorf("%d jobs queued a day later, want the backups verified again", len(jobs))
+	}
+}
+
+// tamper returns a copy of the data with its last byte changed
+func tamper(data []byte) []byte {
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 0xff
+
+	return tampered
+}
+
+// sha256Hex returns the hex encoded SHA-256 checksum of the data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// encryptData encrypts the data like uploads to a destination are
+func encryptData(t *testing.T, data []byte, passphrase string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := crypt.NewWriter(&buf, []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hassio-proton-drive-backup/internal/config"
+	"log/slog"
+	"net/http"
+	"slices"
+	"text/template"
+	"time"
+)
+
+// Events posted to webhooks
+const (
+	webhookCreated = "created" // Backup was created in Home Assistant
+	webhookSynced  = "synced"  // Backup was synced after being created, uploaded or retried
+	webhookFailed  = "failed"  // Backup failed for good
+	webhookDeleted = "deleted" // Retention deleted a backup from Home Assistant or a destination
+	webhookCorrupt = "corrupt" // Backup didn't match its checksum when verified
+)
+
+// Delivery of webhooks
+const (
+	webhookAttempts = 5                // Attempts before a payload is dropped
+	webhookBackoff  = 5 * time.Second  // Delay before the first retry, doubled for every following retry
+	webhookTimeout  = 30 * time.Second // Time a single attempt may take
+)
+
+// webhookData is the payload of an event posted without a template
+type webhookData struct {
+	Event        string    `json:"event"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Slug         string    `json:"slug,omitempty"`
+	Date         time.Time `json:"date"`
+	Profile      string    `json:"profile"`
+	Status       status    `json:"status"`
+	ErrorMessage string    `json:"errorMessage,omitempty"`
+	Location     string    `json:"location,omitempty"` // Where retention deleted the backup, homeassistant or a destination
+	Reason       string    `json:"reason,omitempty"`   // Why retention deleted the backup
+}
+
+// webhookTemplateData is what webhook templates are executed with, a copy of the backup and the details of the event
+type webhookTemplateData struct {
+	*Backup
+	Event    string
+	Location string // Where retention deleted the backup, homeassistant or a destination
+	Reason   string // Why retention deleted the backup
+}
+
+// webhookFuncs are the functions available in webhook templates
+var webhookFuncs = template.FuncMap{
+	// json encodes a value as JSON, for strings in JSON payloads
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// ValidateWebhooks parses the template of every webhook, so invalid templates are found before any event is posted
+func ValidateWebhooks(webhooks []config.WebhookOptions) error {
+	for _, o := range webhooks {
+		if _, err := parseWebhookTemplate(o); err != nil {
+			return fmt.Errorf("invalid template of webhook %s: %w", o.URL, err)
+		}
+	}
+
+	return nil
+}
+
+// parseWebhookTemplate returns the parsed template of the payload, or nil if the event is posted as JSON
+func parseWebhookTemplate(o config.WebhookOptions) (*template.Template, error) {
+	if o.Template == "" {
+		return nil, nil
+	}
+
+	return template.New(o.URL).Funcs(webhookFuncs).Parse(o.Template)
+}
+
+// webhook is a configured webhook with its parsed template
+type webhook struct {
+	url         string
+	events      []string
+	template    *template.Template // Nil to post the event as JSON
+	contentType string
+}
+
+// webhookNotifier posts events about backups to the configured webhooks
+type webhookNotifier struct {
+	client   *http.Client
+	webhooks func() []config.WebhookOptions // Read for every event, so config changes apply to the next one
+	backoff  time.Duration                  // Delay before the first retry
+	done     <-chan struct{}                // Closed when retries should stop
+}
+
+// newWebhookNotifier returns a notifier for the webhooks returned by webhooks. Retries stop once done is closed.
+func newWebhookNotifier(webhooks func() []config.WebhookOptions, done <-chan struct{}) *webhookNotifier {
+	return &webhookNotifier{
+		client:   &http.Client{Timeout: webhookTimeout},
+		webhooks: webhooks,
+		backoff:  webhookBackoff,
+		done:     done,
+	}
+}
+
+// newWebhook returns the webhook for its options, with the content type defaulting to the kind of payload
+func newWebhook(o config.WebhookOptions) (webhook, error) {
+	t, err := parseWebhookTemplate(o)
+	if err != nil {
+		return webhook{}, err
+	}
+
+	w := webhook{
+		url:         o.URL,
+		events:      o.Events,
+		template:    t,
+		contentType: o.ContentType,
+	}
+
+	if w.contentType == "" {
+		w.contentType = "application/json"
+		if w.template != nil {
+			w.contentType = "text/plain"
+		}
+	}
+
+	return w, nil
+}
+
+// notify renders the payload of an event for every webhook subscribed to it and posts them in the background.
+// The payload is copied from the backup, so the lock of the store only has to be held while notify runs.
+func (n *webhookNotifier) notify(event string, backup *Backup, location string, reason string) {
+	options := n.webhooks()
+	if len(options) == 0 {
+		return
+	}
+
+	data := webhookTemplateData{Backup: backup.clone(), Event: event, Location: location, Reason: reason}
+
+	for _, o := range options {
+		if len(o.Events) > 0 && !slices.Contains(o.Events, event) {
+			continue
+		}
+
+		// Templates are validated when the add-on starts, this only fails if the config was built another way
+		w, err := newWebhook(o)
+		if err != nil {
+			slog.Error("invalid webhook template", "url", o.URL, "error", err)
+			continue
+		}
+
+		payload, err := w.render(data)
+		if err != nil {
+			slog.Error("failed to render webhook payload", "url", w.url, "event", event, "error", err)
+			continue
+		}
+
+		go n.deliver(w, event, payload)
+	}
+}
+
+// render returns the payload of the webhook for the data
+func (w webhook) render(data webhookTemplateData) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(webhookData{
+			Event:        data.Event,
+			ID:           data.ID,
+			Name:         data.Name,
+			Slug:         data.Slug,
+			Date:         data.Date,
+			Profile:      data.Profile,
+			Status:       data.Status,
+			ErrorMessage: data.ErrorMessage,
+			Location:     data.Location,
+			Reason:       data.Reason,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// deliver posts a payload to a webhook, retrying with exponential backoff until it's accepted or the attempts run out.
+// Client errors other than rate limiting aren't retried.
+func (n *webhookNotifier) deliver(w webhook, event string, payload []byte) {
+	backoff := n.backoff
+
+	for attempt := 1; ; attempt++ {
+		status, err := n.post(w, payload)
+		if err == nil {
+			slog.Debug("webhook delivered", "url", w.url, "event", event)
+			return
+		}
+
+		permanent := status >= 400 && status < 500 && status != http.StatusTooManyRequests
+		if permanent || attempt == webhookAttempts {
+			slog.Error("failed to deliver webhook", "url", w.url, "event", event, "attempts", attempt, "error", err)
+			return
+		}
+
+		slog.Warn("failed to deliver webhook, retrying", "url", w.url, "event", event, "attempt", attempt, "retry", backoff, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-n.done:
+			timer.Stop()
+			return
+		}
+		backoff *= 2
+	}
+}
+
+// post sends a payload to a webhook and returns the status code of the response
+func (n *webhookNotifier) post(w webhook, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", w.contentType)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
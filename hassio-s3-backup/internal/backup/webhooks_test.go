@@ -0,0 +1,211 @@
+package backup
+
+import (
+	"encoding/json"
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/s3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookRequest is a request received by a fake webhook
+type webhookRequest struct {
+	ContentType string
+	Body        []byte
+}
+
+// newFakeWebhook returns a server answering every request with the status returned by respond, and the requests it receives
+func newFakeWebhook(t *testing.T, respond func(attempt int) int) (*httptest.Server, <-chan webhookRequest) {
+	t.Helper()
+
+	requests := make(chan webhookRequest, 10)
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- webhookRequest{ContentType: r.Header.Get("Content-Type"), Body: body}
+		w.WriteHeader(respond(int(attempts.Add(1))))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+// nextRequest waits for the next request received by a fake webhook
+func nextRequest(t *testing.T, requests <-chan webhookRequest) webhookRequest {
+	t.Helper()
+
+	select {
+	case request := <-requests:
+		return request
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't called")
+		return webhookRequest{}
+	}
+}
+
+func TestWebhookNotifierPostsEvents(t *testing.T) {
+	ok := func(int) int { return http.StatusOK }
+	jsonServer, jsonRequests := newFakeWebhook(t, ok)
+	templateServer, templateRequests := newFakeWebhook(t, ok)
+
+	options := []config.WebhookOptions{{URL: jsonServer.URL}}
+	done := make(chan struct{})
+	defer close(done)
+	n := newWebhookNotifier(func() []config.WebhookOptions { return options }, done)
+
+	date := time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC)
+	backup := &Backup{
+		ID:      "backup",
+		Name:    `Full "Backup"`,
+		Slug:    "aaaa1111",
+		Date:    date,
+		Profile: config.DefaultProfile,
+		Status:  StatusSynced,
+		Remotes: map[string]*s3.Object{"s3": {Key: "backup.tar"}},
+		Uploads: map[string]*Upload{"s3": {EncryptionHeader: []byte("header")}},
+	}
+
+	// Without a template the event is posted as JSON, without the internal state of the backup
+	n.notify(webhookSynced, backup, "", "")
+
+	request := nextRequest(t, jsonRequests)
+	if request.ContentType != "application/json" {
+		t.Errorf("default payload has content type %q, want application/json", request.ContentType)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(request.Body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["event"] != webhookSynced || payload["id"] != "backup" || payload["name"] != backup.Name ||
+		payload["slug"] != "aaaa1111" || payload["date"] != "2024-09-25T12:00:00Z" || payload["status"] != string(StatusSynced) {
+		t.Errorf("default payload %v, want the synced event of the backup", payload)
+	}
+	for _, key := range []string{"remotes", "uploads", "ha", "manifest"} {
+		if _, found := payload[key]; found {
+			t.Errorf("default payload has %q, want only the details of the event", key)
+		}
+	}
+
+	// Webhooks changed since the notifier was created apply to the next event, and only get the events they're subscribed to.
+	// Templates are executed over the backup.
+	options = append(options, config.WebhookOptions{
+		URL:      templateServer.URL,
+		Events:   []string{webhookDeleted},
+		Template: `{"text": {{json (printf "%s deleted from %s: %s" .Name .Location .Reason)}}, "key": {{json (index .Remotes "s3").Key}}}`,
+	})
+	n.notify(webhookCorrupt, backup, "", "")
+	n.notify(webhookDeleted, backup, "s3", "retention")
+
+	nextRequest(t, jsonRequests)
+	nextRequest(t, jsonRequests)
+
+	request = nextRequest(t, templateRequests)
+	if request.ContentType != "text/plain" {
+		t.Errorf("templated payload has content type %q, want text/plain", request.ContentType)
+	}
+	var text map[string]string
+	if err := json.Unmarshal(request.Body, &text); err != nil {
+		t.Fatalf("templated payload %q isn't JSON: %v", request.Body, err)
+	}
+	if want := `Full "Backup" deleted from s3: retention`; text["text"] != want {
+		t.Errorf("templated payload has text %q, want %q", text["text"], want)
+	}
+	if text["key"] != "backup.tar" {
+		t.Errorf("templated payload has key %q, want the key of the backup in s3", text["key"])
+	}
+
+	select {
+	case request := <-templateRequests:
+		t.Errorf("webhook subscribed to deletions received %q", request.Body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestValidateWebhooks(t *testing.T) {
+	valid := []config.WebhookOptions{
+		{URL: "https://ntfy.sh/backups"},
+		{URL: "https://hooks.slack.com/services/T0/B0/X", Template: `{"text": {{json .Name}}}`},
+	}
+	if err := ValidateWebhooks(valid); err != nil {
+		t.Errorf("ValidateWebhooks returned error for valid webhooks: %v", err)
+	}
+
+	invalid := append(valid, config.WebhookOptions{URL: "https://gotify.example.com/message", Template: `{{.Name`})
+	if err := ValidateWebhooks(invalid); err == nil {
+		t.Error("ValidateWebhooks returned no error for an invalid template")
+	}
+}
+
+func TestWebhookDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		respond  func(attempt int) int
+		attempts int
+	}{
+		{"server error", func(int) int { return http.StatusInternalServerError }, webhookAttempts},
+		{"rate limited", func(int) int { return http.StatusTooManyRequests }, webhookAttempts},
+		{"client error", func(int) int { return http.StatusNotFound }, 1},
+		{"recovers", func(attempt int) int {
+			if attempt < 3 {
+				return http.StatusBadGateway
+			}
+			return http.StatusNoContent
+		}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newFakeWebhook(t, tt.respond)
+
+			done := make(chan struct{})
+			defer close(done)
+			n := newWebhookNotifier(nil, done)
+			n.backoff = time.Millisecond
+
+			w, err := newWebhook(config.WebhookOptions{URL: server.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.deliver(w, webhookFailed, []byte("{}"))
+
+			if len(requests) != tt.attempts {
+				t.Errorf("webhook called %d times, want %d", len(requests), tt.attempts)
+			}
+		})
+	}
+}
+
+func TestWebhookDeliveryStopsWhenDone(t *testing.T) {
+	server, requests := newFakeWebhook(t, func(int) int { return http.StatusServiceUnavailable })
+
+	done := make(chan struct{})
+	n := newWebhookNotifier(nil, done)
+	n.backoff = time.Hour
+
+	w, err := newWebhook(config.WebhookOptions{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := make(chan struct{})
+	go func() {
+		n.deliver(w, webhookFailed, []byte("{}"))
+		close(delivered)
+	}()
+
+	nextRequest(t, requests)
+	close(done)
+
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery kept waiting to retry after done was closed")
+	}
+	if len(requests) != 0 {
+		t.Errorf("webhook retried %d times after done was closed", len(requests))
+	}
+}
@@ -2,41 +2,310 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hassio-proton-drive-backup/internal/cron"
 	"hassio-proton-drive-backup/internal/hassio"
+	"hassio-proton-drive-backup/internal/throttle"
 	"log/slog"
+	"maps"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Options represents the addon options
 type Options struct {
 	Timezone         *time.Location
-	S3               S3Options
 	SupervisorToken  string
 	IngressPath      string
 	BackupNameFormat string `json:"backupNameFormat"`
 	LogLevel         slog.Level
-	BackupInterval   int `json:"backupInterval"`
-	BackupsInHA      int `json:"backupsInHA"`
-	BackupsInS3      int `json:"backupsInS3"`
+	Destinations     []DestinationOptions `json:"destinations"`
+	Encryption       EncryptionOptions    `json:"-"`
+	Upload           UploadOptions        `json:"-"`
+	Bandwidth        BandwidthOptions     `json:"-"`
+	Retry            RetryOptions         `json:"-"`
+	Notifications    NotificationOptions  `json:"-"`
+	Webhooks         []WebhookOptions     `json:"-"`
+	Metrics          bool                 `json:"-"` // Serve metrics on port 9101
+	VerifySchedule   string               `json:"-"`
+	KeyPrefix        string               `json:"-"`
+	DefaultKeyPrefix *string              `json:"defaultKeyPrefix,omitempty"` // Used when no key prefix is configured, decided once per install
+	ViewPrefixes     []string             `json:"-"`
+	BackupSettings   BackupSettings       `json:"backupSettings"`
+	Profiles         []Profile            `json:"profiles"`
+	BackupInterval   int                  `json:"backupInterval"`
+	BackupSchedule   string               `json:"backupSchedule"`
+	MissedBackups    string               `json:"missedBackups"`
+	BackupsInHA      int                  `json:"backupsInHA"`
+	RetentionInHA    Retention            `json:"retentionInHA"`
+
+	// Deprecated: replaced by DestinationOptions.Keep, only read to migrate old configs
+	BackupsInS3 int `json:"backupsInS3,omitempty"`
+}
+
+// Policies for scheduled backups that were missed, for example while the add-on wasn't running
+const (
+	MissedBackupsRun  = "run"  // Run a single backup as soon as possible
+	MissedBackupsSkip = "skip" // Wait for the next scheduled time
+)
+
+// DefaultProfile is the name of the full backup profile made up of the top level options
+const DefaultProfile = "default"
+
+// Profile represents a named kind of backup with its own content, schedule and retention
+type Profile struct {
+	Name                  string         `json:"name"`
+	NameFormat            string         `json:"nameFormat"`
+	Partial               bool           `json:"partial"`
+	HomeAssistant         bool           `json:"homeassistant"`
+	Addons                []string       `json:"addons"`
+	Folders               []string       `json:"folders"`
+	Interval              int            `json:"interval"`
+	Schedule              string         `json:"schedule"`
+	BackupsInHA           int            `json:"backupsInHA"`
+	BackupsInDestinations map[string]int `json:"backupsInDestinations"`
+
+	RetentionInHA           Retention            `json:"retentionInHA"`
+	RetentionInDestinations map[string]Retention `json:"retentionInDestinations"`
+}
+
+// Keep returns the number of backups of the profile to keep in a destination, 0 keeps all
+func (p Profile) Keep(destination string) int {
+	return p.BackupsInDestinations[destination]
+}
+
+// Retention returns the tiered retention of the profile in a destination
+func (p Profile) Retention(destination string) Retention {
+	return p.RetentionInDestinations[destination]
+}
+
+// Retention holds the limits of a location on top of the number of backups to keep.
+// The grandfather-father-son tiers keep the newest backup of each of the latest days, weeks,
+// months and years, the age and size limits delete backups even if a tier keeps them.
+// Limits set to 0 are disabled.
+type Retention struct {
+	Daily   int     `json:"daily"`
+	Weekly  int     `json:"weekly"`
+	Monthly int     `json:"monthly"`
+	Yearly  int     `json:"yearly"`
+	MaxAge  int     `json:"maxAge"`  // Days
+	MaxSize float64 `json:"maxSize"` // GB
+}
+
+// Tiered returns true if any grandfather-father-son tier is set
+func (r Retention) Tiered() bool {
+	return r.Daily > 0 || r.Weekly > 0 || r.Monthly > 0 || r.Yearly > 0
+}
+
+// validate makes sure no limit is negative
+func (r Retention) validate() error {
+	if r.Daily < 0 || r.Weekly < 0 || r.Monthly < 0 || r.Yearly < 0 {
+		return errors.New("retention tiers can't be negative")
+	}
+
+	if r.MaxAge < 0 || r.MaxSize < 0 {
+		return errors.New("retention age and size limits can't be negative")
+	}
+
+	return nil
+}
+
+// AllProfiles returns the default profile followed by any additional profiles
+func (o *Options) AllProfiles() []Profile {
+	keep := make(map[string]int)
+	retention := make(map[string]Retention)
+	for _, d := range o.Destinations {
+		keep[d.Name] = d.Keep
+		retention[d.Name] = d.Retention
+	}
+
+	defaultProfile := Profile{
+		Name:                    DefaultProfile,
+		NameFormat:              o.BackupNameFormat,
+		Interval:                o.BackupInterval,
+		Schedule:                o.BackupSchedule,
+		BackupsInHA:             o.BackupsInHA,
+		BackupsInDestinations:   keep,
+		RetentionInHA:           o.RetentionInHA,
+		RetentionInDestinations: retention,
+	}
+
+	profiles := []Profile{defaultProfile}
+	for _, p := range o.Profiles {
+		if p.NameFormat == "" {
+			p.NameFormat = p.Name + " {year}-{month}-{day} {hr24}:{min}:{sec}"
+		}
+		profiles = append(profiles, p)
+	}
+
+	return profiles
+}
+
+// GetProfile returns the profile with the given name, an empty name is the default profile
+func (o *Options) GetProfile(name string) (Profile, bool) {
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	for _, p := range o.AllProfiles() {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return Profile{}, false
+}
+
+// BackupSettings represents the settings used when creating backups in Home Assistant.
+// Backups are always created in /backup, the only backup location the add-on can read them from.
+type BackupSettings struct {
+	Password        string `json:"password,omitempty"`
+	HasPassword     bool   `json:"hasPassword,omitempty"`    // Returned by the API instead of the password
+	RemovePassword  bool   `json:"removePassword,omitempty"` // Requested through the API to remove the password
+	Compressed      bool   `json:"compressed"`
+	ExcludeDatabase bool   `json:"excludeDatabase"`
+}
+
+// UploadOptions represents the options for multipart uploads to S3
+type UploadOptions struct {
+	PartSize    int // Size of each part in MB, 0 lets the client decide
+	Concurrency int // Number of parts uploaded at the same time
+}
+
+// RetryOptions represents how failed backups are retried
+type RetryOptions struct {
+	Attempts int           // Number of retries before giving up, 0 disables retries
+	Backoff  time.Duration // Delay before the first retry, doubled for every following retry
 }
 
-// S3Options represents the S3 options
-type S3Options struct {
-	AccessKey string
-	SecretKey string
-	Bucket    string
-	Endpoint  string
+// NotificationOptions represents how Home Assistant is told about the outcome of backups
+type NotificationOptions struct {
+	Failure bool // Create a persistent notification when a backup fails, dismissed once a later backup succeeds
+	Events  bool // Fire an event in Home Assistant when a backup fails or succeeds
 }
 
-// Service represents the config service
+// WebhookOptions represents a URL that events about backups are posted to
+type WebhookOptions struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`       // Events posted to the URL, all of them if empty
+	Template    string   `json:"template"`     // Go template of the payload over the backup, the event as JSON if empty
+	ContentType string   `json:"content_type"` // Content type of the payload
+}
+
+// BandwidthOptions represents the rate limits of uploads and downloads
+type BandwidthOptions struct {
+	Limit        int // KB/s for uploads and downloads, 0 is unlimited
+	RestoreLimit int // KB/s for restores from a destination, replaces Limit when set
+	Windows      []throttle.Window
+}
+
+// TransferLimit returns the rate limit for uploads and other transfers, such as verifications
+func (o *Options) TransferLimit() throttle.Limit {
+	return o.bandwidthLimit(o.Bandwidth.Limit)
+}
+
+// RestoreLimit returns the rate limit for restores from a destination
+func (o *Options) RestoreLimit() throttle.Limit {
+	if o.Bandwidth.RestoreLimit > 0 {
+		return o.bandwidthLimit(o.Bandwidth.RestoreLimit)
+	}
+
+	return o.bandwidthLimit(o.Bandwidth.Limit)
+}
+
+// bandwidthLimit returns a limit of the given KB/s within the configured windows
+func (o *Options) bandwidthLimit(kilobytes int) throttle.Limit {
+	return throttle.Limit{
+		BytesPerSecond: int64(kilobytes) * 1024,
+		Windows:        o.Bandwidth.Windows,
+		Timezone:       o.Timezone,
+	}
+}
+
+// EncryptionOptions represents the options for encrypting backups before upload
+type EncryptionOptions struct {
+	Passphrase string
+	KeyFile    string
+}
+
+// Enabled returns true if a passphrase or key file is configured
+func (e EncryptionOptions) Enabled() bool {
+	return e.Passphrase != "" || e.KeyFile != ""
+}
+
+// Secret returns the secret the encryption key is derived from, preferring the key file
+func (e EncryptionOptions) Secret() ([]byte, error) {
+	if e.KeyFile != "" {
+		secret, err := os.ReadFile(e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read encryption key file: %w", err)
+		}
+
+		if len(secret) == 0 {
+			return nil, fmt.Errorf("encryption key file %s is empty", e.KeyFile)
+		}
+
+		return secret, nil
+	}
+
+	if e.Passphrase != "" {
+		return []byte(e.Passphrase), nil
+	}
+
+	return nil, fmt.Errorf("no encryption passphrase or key file configured")
+}
+
+// Destination types
+const (
+	DestinationS3    = "s3"
+	DestinationLocal = "local"
+)
+
+// DestinationOptions represents a remote that backups are replicated to
+type DestinationOptions struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Bucket    string    `json:"bucket,omitempty"`
+	AccessKey string    `json:"accessKey,omitempty"`
+	SecretKey string    `json:"secretKey,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Keep      int       `json:"keep"`
+	Retention Retention `json:"retention"`
+	Optional  bool      `json:"optional"`
+	Prefix    string    `json:"-"` // Key prefix of this instance, set from the key_prefix option
+}
+
+// addonDestination represents a destination as configured in the add-on options
+type addonDestination struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Endpoint  string `json:"endpoint"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	Path      string `json:"path"`
+	Optional  bool   `json:"optional"`
+}
+
+// Service represents the config service. The current config is never changed in place,
+// updates replace it with a changed copy so it can be read without locking.
 type Service struct {
-	Config           *Options
+	config           atomic.Pointer[Options]
+	mutex            sync.Mutex // Serializes updates
 	ConfigChangeChan chan *Options
 }
 
+// Config returns the current config, which must not be modified
+func (s *Service) Config() *Options {
+	return s.config.Load()
+}
+
 // logLevels maps string to slog.Level
 var logLevels map[string]slog.Level = map[string]slog.Level{
 	"Error": slog.LevelError,
@@ -45,25 +314,27 @@ var logLevels map[string]slog.Level = map[string]slog.Level{
 	"Debug": slog.LevelDebug,
 }
 
-// NewConfigService returns a new ConfigService
-func NewConfigService() *Service {
+// NewConfigService returns a new ConfigService, or an error if the add-on options are invalid
+func NewConfigService() (*Service, error) {
+	existing := existingInstall()
 	config, err := readConfigFromFile("/data/config.json")
 	if err != nil {
-		config = &Options{} // Initialize with an empty config
+		config = &Options{BackupSettings: BackupSettings{Compressed: true}} // Initialize with an empty config
 	}
 
 	// Set defaults or override with environment variables if they are set
 	config.SupervisorToken = getEnvOrDefault("SUPERVISOR_TOKEN", "", "")
 	config.BackupNameFormat = getEnvOrDefault("BACKUP_NAME_FORMAT", config.BackupNameFormat, "Full Backup {year}-{month}-{day} {hr24}:{min}:{sec}")
 	config.BackupsInHA = getEnvOrDefaultInt("BACKUPS_IN_HA", config.BackupsInHA, 0)
-	config.BackupsInS3 = getEnvOrDefaultInt("BACKUPS_IN_S3", config.BackupsInS3, 0)
 	config.BackupInterval = getEnvOrDefaultInt("BACKUP_INTERVAL", config.BackupInterval, 3)
+	config.BackupSchedule = getEnvOrDefault("BACKUP_SCHEDULE", config.BackupSchedule, "")
+	config.MissedBackups = getEnvOrDefault("MISSED_BACKUPS", config.MissedBackups, MissedBackupsRun)
 
 	defaultTimezone := "UTC"
 	timezoneStr := getEnvOrDefault("TZ", config.Timezone.String(), defaultTimezone)
 	config.Timezone, err = time.LoadLocation(timezoneStr)
 	if err != nil {
-		slog.Error("Invalid time zone, defaulting to UTC: %v", err)
+		slog.Error("Invalid time zone, defaulting to UTC", "error", err)
 		config.Timezone, _ = time.LoadLocation(defaultTimezone)
 	}
 
@@ -75,16 +346,62 @@ func NewConfigService() *Service {
 		config.LogLevel = logLevels["Info"]
 	}
 
-	// S3 Config
-	config.S3.AccessKey = getEnvOrDefault("S3_ACCESS_KEY", "", "")
-	config.S3.SecretKey = getEnvOrDefault("S3_SECRET_KEY", "", "")
-	config.S3.Bucket = getEnvOrDefault("S3_BUCKET_NAME", config.S3.Bucket, "")
-	config.S3.Endpoint = getEnvOrDefault("S3_ENDPOINT", config.S3.Endpoint, "")
+	// Destination config
+	config.Destinations, err = loadDestinations(config.Destinations, config.BackupsInS3)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destinations: %w", err)
+	}
+	config.BackupsInS3 = 0
+
+	// Encryption config
+	config.Encryption.Passphrase = getEnvOrDefault("ENCRYPTION_PASSPHRASE", "", "")
+	config.Encryption.KeyFile = getEnvOrDefault("ENCRYPTION_KEY_FILE", "", "")
+	config.Upload.PartSize = getEnvOrDefaultInt("UPLOAD_PART_SIZE", 0, 0)
+	config.Upload.Concurrency = getEnvOrDefaultInt("UPLOAD_CONCURRENCY", 0, 1)
+
+	// Retry config
+	config.Retry.Attempts = getEnvOrDefaultInt("RETRY_ATTEMPTS", 0, 5)
+	config.Retry.Backoff = time.Duration(getEnvOrDefaultInt("RETRY_BACKOFF", 0, 5)) * time.Minute
+
+	// Notification config
+	config.Notifications.Failure = getEnvOrDefaultBool("FAILURE_NOTIFICATION", true)
+	config.Notifications.Events = getEnvOrDefaultBool("BACKUP_EVENTS", true)
+
+	config.Webhooks, err = loadWebhooks()
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhooks: %w", err)
+	}
+
+	// Metrics config
+	config.Metrics = getEnvOrDefaultBool("METRICS", false)
+
+	// Verification config
+	config.VerifySchedule = getEnvOrDefault("VERIFY_SCHEDULE", "", "")
+	if _, err := cron.Parse(config.VerifySchedule); config.VerifySchedule != "" && err != nil {
+		slog.Error("Invalid verify schedule, scheduled verification is disabled", "error", err)
+		config.VerifySchedule = ""
+	}
+
+	// Bandwidth config
+	config.Bandwidth.Limit = getEnvOrDefaultInt("BANDWIDTH_LIMIT", 0, 0)
+	config.Bandwidth.RestoreLimit = getEnvOrDefaultInt("RESTORE_BANDWIDTH_LIMIT", 0, 0)
+	config.Bandwidth.Windows = loadBandwidthWindows()
+
+	// Key prefix config
+	if config.DefaultKeyPrefix == nil {
+		defaultPrefix := defaultKeyPrefix(config.SupervisorToken, existing)
+		config.DefaultKeyPrefix = &defaultPrefix
+	}
+	config.KeyPrefix = loadKeyPrefix(*config.DefaultKeyPrefix)
+	config.ViewPrefixes = loadViewPrefixes(config.KeyPrefix)
+	for i := range config.Destinations {
+		config.Destinations[i].Prefix = config.KeyPrefix
+	}
 
 	// Handle ingress entry
 	ingressEntry, err := hassio.GetIngressEntry(config.SupervisorToken)
 	if err != nil {
-		slog.Error("Error getting ingress entry: %v", err)
+		slog.Error("Error getting ingress entry", "error", err)
 		ingressEntry = ""
 	}
 	config.IngressPath = ingressEntry
@@ -92,13 +409,20 @@ func NewConfigService() *Service {
 	// Write config to file
 	err = writeConfigToFile(config)
 	if err != nil {
-		slog.Error("Error writing config to file: %v", err)
+		slog.Error("Error writing config to file", "error", err)
 	}
 
-	return &Service{
-		Config:           config,
+	return NewService(config), nil
+}
+
+// NewService returns a config service for the given options, without reading or writing the add-on config
+func NewService(options *Options) *Service {
+	service := &Service{
 		ConfigChangeChan: make(chan *Options),
 	}
+	service.config.Store(options)
+
+	return service
 }
 
 // NotifyConfigChange sends a new config to the configChangeChan
@@ -109,20 +433,344 @@ func (s *Service) NotifyConfigChange(newConfig *Options) {
 
 // UpdateConfigFromAPI updates the configuration with the provided settings from an API request
 func (s *Service) UpdateConfigFromAPI(configRequest Options) error {
-	s.Config.BackupNameFormat = configRequest.BackupNameFormat
-	s.Config.BackupInterval = configRequest.BackupInterval
-	s.Config.BackupsInHA = configRequest.BackupsInHA
-	s.Config.BackupsInS3 = configRequest.BackupsInS3
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	s.NotifyConfigChange(s.Config)
-	err := writeConfigToFile(s.Config)
+	updated := s.Config().clone()
+	if err := applyConfigRequest(updated, configRequest); err != nil {
+		return err
+	}
+
+	s.config.Store(updated)
+	s.NotifyConfigChange(updated)
+	err := writeConfigToFile(updated)
 	if err != nil {
-		slog.Error("Error writing config to file: %v", err)
+		slog.Error("Error writing config to file", "error", err)
 		return fmt.Errorf("failed to update config: %v", err)
 	}
 	return nil
 }
 
+// PreviewConfig returns a copy of the current configuration with the request applied, without saving it
+func (s *Service) PreviewConfig(configRequest Options) (*Options, error) {
+	preview := s.Config().clone()
+	if err := applyConfigRequest(preview, configRequest); err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// clone returns a copy of the options that shares nothing the API can change
+func (o *Options) clone() *Options {
+	c := *o
+	c.Destinations = append([]DestinationOptions{}, o.Destinations...)
+
+	c.Profiles = make([]Profile, len(o.Profiles))
+	for i, p := range o.Profiles {
+		p.Addons = append([]string{}, p.Addons...)
+		p.Folders = append([]string{}, p.Folders...)
+		p.BackupsInDestinations = maps.Clone(p.BackupsInDestinations)
+		p.RetentionInDestinations = maps.Clone(p.RetentionInDestinations)
+		c.Profiles[i] = p
+	}
+
+	return &c
+}
+
+// applyConfigRequest validates the settings that can be changed through the API and applies them to the config
+func applyConfigRequest(config *Options, configRequest Options) error {
+	if err := validateProfiles(configRequest.Profiles); err != nil {
+		return err
+	}
+
+	if err := configRequest.RetentionInHA.validate(); err != nil {
+		return err
+	}
+
+	for _, d := range configRequest.Destinations {
+		if err := d.Retention.validate(); err != nil {
+			return fmt.Errorf("destination \"%s\": %v", d.Name, err)
+		}
+	}
+
+	if configRequest.BackupSchedule != "" {
+		if _, err := cron.Parse(configRequest.BackupSchedule); err != nil {
+			return fmt.Errorf("invalid backup schedule: %v", err)
+		}
+	}
+
+	if configRequest.MissedBackups != MissedBackupsRun && configRequest.MissedBackups != MissedBackupsSkip {
+		configRequest.MissedBackups = MissedBackupsRun
+	}
+
+	config.BackupNameFormat = configRequest.BackupNameFormat
+	config.BackupInterval = configRequest.BackupInterval
+	config.BackupSchedule = configRequest.BackupSchedule
+	config.MissedBackups = configRequest.MissedBackups
+	config.BackupsInHA = configRequest.BackupsInHA
+	config.RetentionInHA = configRequest.RetentionInHA
+	config.Profiles = configRequest.Profiles
+
+	// The API never returns the password, so requests leave it out unless it's changed or removed
+	settings := configRequest.BackupSettings
+	if settings.Password == "" && !settings.RemovePassword {
+		settings.Password = config.BackupSettings.Password
+	}
+	settings.HasPassword = false
+	settings.RemovePassword = false
+	config.BackupSettings = settings
+
+	// Only the retention can be changed for existing destinations
+	for _, requested := range configRequest.Destinations {
+		for i := range config.Destinations {
+			if config.Destinations[i].Name == requested.Name {
+				config.Destinations[i].Keep = requested.Keep
+				config.Destinations[i].Retention = requested.Retention
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateProfiles makes sure every profile has a unique name and selects something to back up
+func validateProfiles(profiles []Profile) error {
+	names := map[string]bool{DefaultProfile: true}
+
+	for _, p := range profiles {
+		if p.Name == "" {
+			return errors.New("profile name can't be empty")
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("profile name \"%s\" is already in use", p.Name)
+		}
+		names[p.Name] = true
+
+		if p.Partial && !p.HomeAssistant && len(p.Addons) == 0 && len(p.Folders) == 0 {
+			return fmt.Errorf("partial profile \"%s\" doesn't select anything to back up", p.Name)
+		}
+
+		if p.Schedule != "" {
+			if _, err := cron.Parse(p.Schedule); err != nil {
+				return fmt.Errorf("invalid schedule for profile \"%s\": %v", p.Name, err)
+			}
+		}
+
+		if err := p.RetentionInHA.validate(); err != nil {
+			return fmt.Errorf("profile \"%s\": %v", p.Name, err)
+		}
+
+		for destination, r := range p.RetentionInDestinations {
+			if err := r.validate(); err != nil {
+				return fmt.Errorf("profile \"%s\", destination \"%s\": %v", p.Name, destination, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadDestinations builds the list of destinations from the environment, keeping previously saved retention settings.
+// Destination names are used as keys of the backups in each destination, so they have to be set and unique.
+func loadDestinations(saved []DestinationOptions, legacyKeep int) ([]DestinationOptions, error) {
+	destinations := []DestinationOptions{}
+
+	// The single S3 bucket configured by the original options
+	if endpoint := getEnvOrDefault("S3_ENDPOINT", "", ""); endpoint != "" {
+		destinations = append(destinations, DestinationOptions{
+			Name:      DestinationS3,
+			Type:      DestinationS3,
+			Endpoint:  endpoint,
+			Bucket:    getEnvOrDefault("S3_BUCKET_NAME", "", ""),
+			AccessKey: getEnvOrDefault("S3_ACCESS_KEY", "", ""),
+			SecretKey: getEnvOrDefault("S3_SECRET_KEY", "", ""),
+		})
+	}
+
+	// The single local directory configured by the original options
+	if path := getEnvOrDefault("LOCAL_PATH", "", ""); path != "" {
+		destinations = append(destinations, DestinationOptions{
+			Name: DestinationLocal,
+			Type: DestinationLocal,
+			Path: path,
+		})
+	}
+
+	// Any additional destinations
+	if value := getEnvOrDefault("DESTINATIONS", "", ""); value != "" && value != "null" {
+		var addonDestinations []addonDestination
+		if err := json.Unmarshal([]byte(value), &addonDestinations); err != nil {
+			return nil, fmt.Errorf("could not parse destinations: %v", err)
+		}
+
+		for _, d := range addonDestinations {
+			destinations = append(destinations, DestinationOptions{
+				Name:      d.Name,
+				Type:      d.Type,
+				Endpoint:  d.Endpoint,
+				Bucket:    d.Bucket,
+				AccessKey: d.AccessKey,
+				SecretKey: d.SecretKey,
+				Path:      d.Path,
+				Optional:  d.Optional,
+			})
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, d := range destinations {
+		if d.Name == "" {
+			return nil, errors.New("destination name can't be empty")
+		}
+
+		if names[d.Name] {
+			return nil, fmt.Errorf("destination name \"%s\" is already in use", d.Name)
+		}
+		names[d.Name] = true
+
+		if d.Type != DestinationS3 && d.Type != DestinationLocal {
+			return nil, fmt.Errorf("destination \"%s\" has unknown type \"%s\"", d.Name, d.Type)
+		}
+	}
+
+	// Restore retention settings by name, falling back to the old single S3 setting
+	for i := range destinations {
+		destinations[i].Keep = legacyKeep
+		for _, s := range saved {
+			if s.Name == destinations[i].Name {
+				destinations[i].Keep = s.Keep
+				destinations[i].Retention = s.Retention
+			}
+		}
+	}
+
+	return destinations, nil
+}
+
+// loadWebhooks parses the webhooks configured in the add-on options, their templates are parsed by the backup service
+func loadWebhooks() ([]WebhookOptions, error) {
+	webhooks := []WebhookOptions{}
+
+	value := getEnvOrDefault("WEBHOOKS", "", "")
+	if value == "" || value == "null" {
+		return webhooks, nil
+	}
+
+	if err := json.Unmarshal([]byte(value), &webhooks); err != nil {
+		return nil, fmt.Errorf("could not parse webhooks: %v", err)
+	}
+
+	for _, w := range webhooks {
+		if w.URL == "" {
+			return nil, errors.New("webhook url can't be empty")
+		}
+	}
+
+	return webhooks, nil
+}
+
+// loadBandwidthWindows parses the time windows the bandwidth limits apply in, invalid windows are skipped
+func loadBandwidthWindows() []throttle.Window {
+	windows := []throttle.Window{}
+
+	value := getEnvOrDefault("BANDWIDTH_LIMIT_WINDOWS", "", "")
+	if value == "" || value == "null" {
+		return windows
+	}
+
+	var addonWindows []string
+	if err := json.Unmarshal([]byte(value), &addonWindows); err != nil {
+		slog.Error("Error parsing bandwidth limit windows", "error", err)
+		return windows
+	}
+
+	for _, w := range addonWindows {
+		window, err := throttle.ParseWindow(w)
+		if err != nil {
+			slog.Error("Skipping bandwidth limit window", "error", err)
+			continue
+		}
+		windows = append(windows, window)
+	}
+
+	return windows
+}
+
+// loadKeyPrefix returns the key prefix of this instance, the default prefix unless configured.
+// A prefix of "/" keeps backups in the root of each destination.
+func loadKeyPrefix(defaultPrefix string) string {
+	if value, exists := os.LookupEnv("KEY_PREFIX"); exists && value != "" {
+		return normalizePrefix(value)
+	}
+
+	return defaultPrefix
+}
+
+// existingInstall returns true if an earlier version already stored config or backup state,
+// which means its backups are in the root of each destination
+func existingInstall() bool {
+	for _, path := range []string{"/data/config.json", "/data/backups.json"} {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// defaultKeyPrefix returns the prefix to use when none is configured. Existing installs keep
+// using the root of each destination so their backups aren't lost, new installs use the hostname.
+func defaultKeyPrefix(token string, existing bool) string {
+	if existing {
+		slog.Info("Existing install, using the root of each destination unless a key prefix is configured")
+		return ""
+	}
+
+	hostname, err := hassio.GetHostname(token)
+	if err != nil {
+		slog.Error("Error getting hostname for key prefix, using the root of each destination", "error", err)
+		return ""
+	}
+
+	return normalizePrefix(hostname)
+}
+
+// loadViewPrefixes parses the prefixes of other instances to list backups from
+func loadViewPrefixes(keyPrefix string) []string {
+	prefixes := []string{}
+
+	value := getEnvOrDefault("VIEW_KEY_PREFIXES", "", "")
+	if value == "" || value == "null" {
+		return prefixes
+	}
+
+	var addonPrefixes []string
+	if err := json.Unmarshal([]byte(value), &addonPrefixes); err != nil {
+		slog.Error("Error parsing key prefixes to view", "error", err)
+		return prefixes
+	}
+
+	for _, p := range addonPrefixes {
+		if prefix := normalizePrefix(p); prefix != keyPrefix {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
+
+// normalizePrefix returns the prefix without surrounding slashes and with a trailing slash, or empty for the root
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+
+	return prefix + "/"
+}
+
 // Helper function to get environment variable or return a default
 func getEnvOrDefault(key string, currentValue, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
@@ -151,6 +799,17 @@ func getEnvOrDefaultInt(key string, currentValue, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvOrDefaultBool returns the boolean value of an environment variable, or the default if it isn't set or valid
+func getEnvOrDefaultBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+
+	return defaultValue
+}
+
 // Helper function to convert slog.Level to string
 func stringFromSlogLevel(level slog.Level) string {
 	for k, v := range logLevels {
@@ -187,7 +846,8 @@ func readConfigFromFile(filePath string) (*Options, error) {
 	}
 	defer file.Close()
 
-	var config Options
+	// Backups are compressed unless explicitly disabled
+	config := Options{BackupSettings: BackupSettings{Compressed: true}}
 	if err := json.NewDecoder(file).Decode(&config); err != nil {
 		return nil, err
 	}
@@ -20,14 +20,36 @@ func newConfigHandler(cs *Service) *configHandler {
 // handleGetConfig handles GET requests to retrieve the current configuration.
 func (h *configHandler) handleGetConfig(w http.ResponseWriter, r *http.Request) {
 	// Retrieve the current configuration
-	conf := h.configService.Config
+	conf := h.configService.Config()
+
+	// Leave out connection details and credentials of the destinations
+	destinations := []DestinationOptions{}
+	for _, d := range conf.Destinations {
+		destinations = append(destinations, DestinationOptions{
+			Name:      d.Name,
+			Type:      d.Type,
+			Keep:      d.Keep,
+			Retention: d.Retention,
+			Optional:  d.Optional,
+		})
+	}
+
+	// Only tell whether a backup password is set
+	settings := conf.BackupSettings
+	settings.HasPassword = settings.Password != ""
+	settings.Password = ""
 
 	// Prepare the response with the configuration options
 	responseConfig := Options{
 		BackupNameFormat: conf.BackupNameFormat,
 		BackupInterval:   conf.BackupInterval,
+		BackupSchedule:   conf.BackupSchedule,
+		MissedBackups:    conf.MissedBackups,
 		BackupsInHA:      conf.BackupsInHA,
-		BackupsInS3:      conf.BackupsInS3,
+		RetentionInHA:    conf.RetentionInHA,
+		Destinations:     destinations,
+		BackupSettings:   settings,
+		Profiles:         conf.Profiles,
 	}
 
 	// Marshal the responseConfig struct to JSON
@@ -41,8 +63,10 @@ func (h *configHandler) handleGetConfig(w http.ResponseWriter, r *http.Request)
 
 // handleUpdateConfig handles POST requests to update the configuration.
 func (h *configHandler) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
-	// Define a variable to hold the request body
-	var requestBody Options
+	// Decode the request over the current backup settings, so settings it leaves out keep their value.
+	// The password is merged when the request is applied, since the API never returns it.
+	requestBody := Options{BackupSettings: h.configService.Config().BackupSettings}
+	requestBody.BackupSettings.Password = ""
 
 	// Decode the JSON request body into the requestBody struct
 	err := json.NewDecoder(r.Body).Decode(&requestBody)
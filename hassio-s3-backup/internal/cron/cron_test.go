@@ -0,0 +1,193 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	expressions := []string{
+		"",
+		"0 3 * *",
+		"0 3 * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+	}
+
+	for _, expression := range expressions {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Parse(%q) returned no error", expression)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		from       time.Time
+		want       []time.Time
+	}{
+		{
+			name:       "every 15 minutes",
+			expression: "*/15 * * * *",
+			from:       time.Date(2024, 9, 25, 10, 7, 30, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, 9, 25, 10, 15, 0, 0, time.UTC),
+				time.Date(2024, 9, 25, 10, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "strictly after",
+			expression: "0 3 * * *",
+			from:       time.Date(2024, 9, 25, 3, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, 9, 26, 3, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "day of week only",
+			expression: "0 0 * * 1",
+			from:       time.Date(2024, 9, 25, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, 9, 30, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 10, 7, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "day of month or day of week",
+			expression: "0 0 1 * 1",
+			from:       time.Date(2024, 9, 25, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, 9, 30, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 10, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 10, 7, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "sunday as 7",
+			expression: "0 12 * * 7",
+			from:       time.Date(2024, 9, 25, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2024, 9, 29, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "names",
+			expression: "0 12 * jan mon",
+			from:       time.Date(2024, 9, 25, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC),
+				time.Date(2025, 1, 13, 12, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "leap day",
+			expression: "0 0 29 2 *",
+			from:       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:       "impossible date",
+			expression: "0 0 30 2 *",
+			from:       time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			want:       []time.Time{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertNext(t, tt.expression, tt.from, tt.want)
+		})
+	}
+}
+
+func TestNextDaylightSavingTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+
+	cet := time.FixedZone("CET", 1*60*60)
+	cest := time.FixedZone("CEST", 2*60*60)
+
+	tests := []struct {
+		name       string
+		expression string
+		from       time.Time
+		want       []time.Time
+	}{
+		{
+			name:       "skipped time runs after the change",
+			expression: "30 2 * * *",
+			from:       time.Date(2024, 3, 30, 12, 0, 0, 0, berlin),
+			want: []time.Time{
+				time.Date(2024, 3, 31, 3, 0, 0, 0, cest),
+				time.Date(2024, 4, 1, 2, 30, 0, 0, cest),
+			},
+		},
+		{
+			name:       "skipped time within a matching hour",
+			expression: "30 1,2 * * *",
+			from:       time.Date(2024, 3, 31, 1, 30, 0, 0, berlin),
+			want: []time.Time{
+				time.Date(2024, 3, 31, 3, 0, 0, 0, cest),
+				time.Date(2024, 4, 1, 1, 30, 0, 0, cest),
+			},
+		},
+		{
+			name:       "repeated time runs once",
+			expression: "30 2 * * *",
+			from:       time.Date(2024, 10, 27, 2, 15, 0, 0, cest),
+			want: []time.Time{
+				time.Date(2024, 10, 27, 2, 30, 0, 0, cest),
+				time.Date(2024, 10, 28, 2, 30, 0, 0, cet),
+			},
+		},
+		{
+			name:       "hourly runs through the repeated hour",
+			expression: "0 * * * *",
+			from:       time.Date(2024, 10, 27, 1, 30, 0, 0, cest),
+			want: []time.Time{
+				time.Date(2024, 10, 27, 2, 0, 0, 0, cest),
+				time.Date(2024, 10, 27, 2, 0, 0, 0, cet),
+				time.Date(2024, 10, 27, 3, 0, 0, 0, cet),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertNext(t, tt.expression, tt.from.In(berlin), tt.want)
+		})
+	}
+}
+
+// assertNext checks the consecutive times a schedule returns, starting from the given time
+func assertNext(t *testing.T, expression string, from time.Time, want []time.Time) {
+	t.Helper()
+
+	schedule, err := Parse(expression)
+	if err != nil {
+		t.Fatalf("Parse(%q) returned error: %v", expression, err)
+	}
+
+	at := from
+	for _, w := range want {
+		at = schedule.Next(at)
+		if !at.Equal(w) {
+			t.Fatalf("Next of %q = %s, want %s", expression, at, w)
+		}
+		if !at.IsZero() && at.Location() != from.Location() {
+			t.Errorf("Next of %q returned a time in %s, want %s", expression, at.Location(), from.Location())
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Suffix is appended to the key of encrypted objects
+const Suffix = ".enc"
+
+// The encrypted format is a header followed by a sequence of AES-256-GCM sealed chunks.
+// Each chunk nonce is the random nonce prefix from the header, the chunk counter and a
+// flag marking the final chunk, which protects against reordering and truncation.
+//
+//	header: magic (8) | salt (16) | nonce prefix (7)
+//	chunk:  ciphertext of up to chunkSize bytes | tag (16)
+const (
+	magic           = "HAS3ENC1"
+	saltSize        = 16
+	noncePrefixSize = 7
+	headerSize      = len(magic) + saltSize + noncePrefixSize
+	chunkSize       = 64 * 1024
+	tagSize         = 16
+)
+
+// ErrInvalidFormat is returned when the data isn't in the expected encrypted format
+var ErrInvalidFormat = errors.New("invalid encrypted format")
+
+// ErrAuthentication is returned when a chunk can't be decrypted, either because of the wrong key or corrupt data
+var ErrAuthentication = errors.New("wrong key or corrupt data")
+
+// EncryptedSize returns the size of the encrypted output for a plaintext of the given size
+func EncryptedSize(size int64) int64 {
+	// There is always a final chunk, which is empty if the size is a multiple of the chunk size
+	chunks := size/chunkSize + 1
+	return int64(headerSize) + size + chunks*tagSize
+}
+
+// deriveKey derives the encryption key from the secret and salt
+func deriveKey(secret, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(secret, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive key: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// nonce builds the nonce for a chunk
+func nonce(prefix []byte, counter uint32, last bool) []byte {
+	n := make([]byte, 12)
+	copy(n, prefix)
+	binary.BigEndian.PutUint32(n[noncePrefixSize:], counter)
+	if last {
+		n[11] = 1
+	}
+
+	return n
+}
+
+// Writer encrypts everything written to it
+type Writer struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewWriter returns a writer that encrypts data with a key derived from the secret and writes it to w.
+// Close must be called to write the final chunk.
+func NewWriter(w io.Writer, secret []byte) (*Writer, error) {
+	header, err := NewHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	cw, _, err := NewWriterAt(w, secret, header, 0)
+	return cw, err
+}
+
+// NewHeader returns the header of a new encrypted stream, with a random salt and nonce prefix
+func NewHeader() ([]byte, error) {
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	if _, err := rand.Read(header[len(magic):]); err != nil {
+		return nil, err
+	}
+
+	return header, nil
+}
+
+// NewWriterAt returns a writer for the encrypted stream with the given header whose output to w starts at
+// the given offset of the encrypted data. It returns the plaintext offset the data written to it must start at,
+// which is the start of the chunk containing the offset. Used to resume an interrupted upload.
+func NewWriterAt(w io.Writer, secret []byte, header []byte, offset int64) (*Writer, int64, error) {
+	if len(header) != headerSize || !bytes.Equal(header[:len(magic)], []byte(magic)) {
+		return nil, 0, ErrInvalidFormat
+	}
+
+	salt := header[len(magic) : len(magic)+saltSize]
+	aead, err := deriveKey(secret, salt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cw := &Writer{
+		aead:   aead,
+		header: header,
+		prefix: header[len(magic)+saltSize:],
+		buf:    make([]byte, 0, chunkSize),
+	}
+
+	if offset < int64(headerSize) {
+		if _, err := w.Write(header[offset:]); err != nil {
+			return nil, 0, err
+		}
+
+		cw.w = w
+		return cw, 0, nil
+	}
+
+	// Start at the chunk containing the offset and skip the output before it
+	sealedSize := int64(chunkSize + tagSize)
+	chunk := (offset - int64(headerSize)) / sealedSize
+	skip := offset - int64(headerSize) - chunk*sealedSize
+
+	cw.w = &skipWriter{w: w, skip: skip}
+	cw.counter = uint32(chunk)
+
+	return cw, chunk * chunkSize, nil
+}
+
+// skipWriter discards the first bytes written to it
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+// Write writes everything after the skipped bytes
+func (sw *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if sw.skip > 0 {
+		if int64(n) <= sw.skip {
+			sw.skip -= int64(n)
+			return n, nil
+		}
+
+		p = p[sw.skip:]
+		sw.skip = 0
+	}
+
+	if _, err := sw.w.Write(p); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Write buffers data and writes complete chunks
+func (cw *Writer) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, errors.New("write to closed writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := copy(cw.buf[len(cw.buf):chunkSize], p)
+		cw.buf = cw.buf[:len(cw.buf)+n]
+		p = p[n:]
+		written += n
+
+		// Only flush full chunks when there's more data, the last chunk is written on close
+		if len(cw.buf) == chunkSize && len(p) > 0 {
+			if err := cw.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Close writes the final chunk
+func (cw *Writer) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+
+	// A full buffer isn't the last chunk, an empty final chunk follows it
+	if len(cw.buf) == chunkSize {
+		if err := cw.flush(false); err != nil {
+			return err
+		}
+	}
+
+	return cw.flush(true)
+}
+
+// flush seals the buffered data and writes it as a chunk
+func (cw *Writer) flush(last bool) error {
+	sealed := cw.aead.Seal(nil, nonce(cw.prefix, cw.counter, last), cw.buf, cw.header)
+	if _, err := cw.w.Write(sealed); err != nil {
+		return err
+	}
+
+	cw.counter++
+	cw.buf = cw.buf[:0]
+
+	return nil
+}
+
+// Reader decrypts data written by Writer
+type Reader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	chunk   []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// NewReader returns a reader that decrypts data from r with a key derived from the secret
+func NewReader(r io.Reader, secret []byte) (*Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrInvalidFormat
+	}
+
+	if !bytes.Equal(header[:len(magic)], []byte(magic)) {
+		return nil, ErrInvalidFormat
+	}
+
+	salt := header[len(magic) : len(magic)+saltSize]
+	aead, err := deriveKey(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		r:      bufio.NewReaderSize(r, chunkSize+tagSize+1),
+		aead:   aead,
+		header: header,
+		prefix: header[len(magic)+saltSize:],
+		chunk:  make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+// Read returns decrypted data, failing if any chunk doesn't authenticate
+func (cr *Reader) Read(p []byte) (int, error) {
+	for len(cr.plain) == 0 {
+		if cr.done {
+			return 0, io.EOF
+		}
+
+		if err := cr.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, cr.plain)
+	cr.plain = cr.plain[n:]
+
+	return n, nil
+}
+
+// next reads and decrypts the next chunk
+func (cr *Reader) next() error {
+	n, err := io.ReadFull(cr.r, cr.chunk)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			return fmt.Errorf("%w: missing final chunk", ErrInvalidFormat)
+		}
+		return err
+	}
+
+	// The chunk is the last one if nothing follows it
+	last := n < len(cr.chunk)
+	if !last {
+		if _, err := cr.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	plain, err := cr.aead.Open(nil, nonce(cr.prefix, cr.counter, last), cr.chunk[:n], cr.header)
+	if err != nil {
+		return fmt.Errorf("could not decrypt chunk %d: %w", cr.counter, ErrAuthentication)
+	}
+
+	cr.counter++
+	cr.plain = plain
+	cr.done = last
+
+	return nil
+}
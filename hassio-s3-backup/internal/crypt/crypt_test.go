@@ -0,0 +1,210 @@
+package crypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var secret = []byte("correct horse battery staple")
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 5} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			plain := randomData(size)
+			encrypted := encrypt(t, plain)
+
+			if int64(len(encrypted)) != EncryptedSize(int64(size)) {
+				t.Errorf("encrypted %d bytes into %d bytes, EncryptedSize returns %d", size, len(encrypted), EncryptedSize(int64(size)))
+			}
+			// A few bytes can turn up in the ciphertext by chance, longer plaintexts can't
+			if size >= 16 && bytes.Contains(encrypted, plain) {
+				t.Error("encrypted data contains the plaintext")
+			}
+
+			decrypted, err := decrypt(encrypted, secret)
+			if err != nil {
+				t.Fatalf("decrypting returned error: %v", err)
+			}
+			if !bytes.Equal(decrypted, plain) {
+				t.Errorf("decrypted %d bytes that don't match the %d bytes encrypted", len(decrypted), size)
+			}
+		})
+	}
+}
+
+func TestReaderRejectsTamperedData(t *testing.T) {
+	encrypted := encrypt(t, randomData(2*chunkSize+100))
+	sealedSize := chunkSize + tagSize
+	chunk := func(i int) []byte {
+		return encrypted[headerSize+i*sealedSize : min(headerSize+(i+1)*sealedSize, len(encrypted))]
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		secret []byte
+		want   error
+	}{
+		{
+			name:   "wrong passphrase",
+			data:   encrypted,
+			secret: []byte("wrong passphrase"),
+			want:   ErrAuthentication,
+		},
+		{
+			name:   "truncated at a chunk boundary",
+			data:   encrypted[:headerSize+2*sealedSize],
+			secret: secret,
+			want:   ErrAuthentication,
+		},
+		{
+			name:   "final chunk missing its data",
+			data:   encrypted[:len(encrypted)-10],
+			secret: secret,
+			want:   ErrAuthentication,
+		},
+		{
+			name:   "only the header",
+			data:   encrypted[:headerSize],
+			secret: secret,
+			want:   ErrInvalidFormat,
+		},
+		{
+			name:   "chunks reordered",
+			data:   bytes.Join([][]byte{encrypted[:headerSize], chunk(1), chunk(0), chunk(2)}, nil),
+			secret: secret,
+			want:   ErrAuthentication,
+		},
+		{
+			name:   "chunk changed",
+			data:   flipBit(encrypted, headerSize+5),
+			secret: secret,
+			want:   ErrAuthentication,
+		},
+		{
+			name:   "header changed",
+			data:   flipBit(encrypted, headerSize-1),
+			secret: secret,
+			want:   ErrAuthentication,
+		},
+		{
+			name:   "not encrypted",
+			data:   []byte("plain tarball that is longer than the header"),
+			secret: secret,
+			want:   ErrInvalidFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decrypt(tt.data, tt.secret); !errors.Is(err, tt.want) {
+				t.Errorf("decrypting returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterAtResumes(t *testing.T) {
+	plain := randomData(3*chunkSize + 100)
+	header, err := NewHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A single pass over the plaintext is what the resumed writers have to produce from their offset on
+	var full bytes.Buffer
+	w, start, err := NewWriterAt(&full, secret, header, 0)
+	if err != nil || start != 0 {
+		t.Fatalf("NewWriterAt at 0 returned offset %d and error %v", start, err)
+	}
+	write(t, w, plain)
+
+	sealedSize := int64(chunkSize + tagSize)
+	for _, offset := range []int64{
+		0,
+		10,
+		int64(headerSize),
+		int64(headerSize) + sealedSize,
+		int64(headerSize) + sealedSize + 100,
+		int64(headerSize) + 3*sealedSize + 1,
+		int64(full.Len()) - 1,
+	} {
+		t.Run(fmt.Sprint(offset), func(t *testing.T) {
+			var resumed bytes.Buffer
+			w, start, err := NewWriterAt(&resumed, secret, header, offset)
+			if err != nil {
+				t.Fatalf("NewWriterAt returned error: %v", err)
+			}
+			if start%chunkSize != 0 || start > int64(len(plain)) {
+				t.Fatalf("NewWriterAt returned plaintext offset %d, want the start of a chunk", start)
+			}
+
+			write(t, w, plain[start:])
+
+			if !bytes.Equal(resumed.Bytes(), full.Bytes()[offset:]) {
+				t.Errorf("resumed writer wrote %d bytes that don't match the %d bytes of a single pass from the offset on",
+					resumed.Len(), full.Len()-int(offset))
+			}
+		})
+	}
+
+	if _, _, err := NewWriterAt(io.Discard, secret, []byte("not a header"), 0); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("NewWriterAt with an invalid header returned %v, want %v", err, ErrInvalidFormat)
+	}
+}
+
+// encrypt returns the plaintext encrypted with the test secret
+func encrypt(t *testing.T, plain []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, w, plain)
+
+	return buf.Bytes()
+}
+
+// write writes the plaintext in pieces that don't line up with the chunks, then closes the writer
+func write(t *testing.T, w *Writer, plain []byte) {
+	t.Helper()
+
+	if _, err := io.CopyBuffer(w, bytes.NewReader(plain), make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// decrypt returns the decrypted data, or the error of the reader
+func decrypt(data []byte, secret []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// flipBit returns a copy of the data with a bit of the byte at i flipped
+func flipBit(data []byte, i int) []byte {
+	flipped := bytes.Clone(data)
+	flipped[i] ^= 1
+
+	return flipped
+}
+
+// randomData returns the given number of bytes of random data that's the same for every run
+func randomData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+
+	return data
+}
@@ -0,0 +1,294 @@
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hassio-proton-drive-backup/internal/config"
+	"hassio-proton-drive-backup/internal/s3"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	tempPrefix = ".tmp-"      // Marks files that are still being written
+	metaSuffix = ".meta.json" // Marks the files holding the metadata of the file they're named after
+)
+
+// Client is a storage target backed by a local directory, such as a mounted network share
+type Client struct {
+	path string
+}
+
+// NewClient creates a new filesystem client for the given destination
+func NewClient(d config.DestinationOptions) (*Client, error) {
+	if d.Path == "" {
+		return nil, fmt.Errorf("no path configured for destination %s", d.Name)
+	}
+
+	// The key prefix is a subdirectory of the path
+	path := filepath.Join(d.Path, filepath.FromSlash(d.Prefix))
+
+	slog.Debug("initializing filesystem client", "destination", d.Name, "path", path)
+
+	// Create the target directory if it doesn't exist
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("could not create directory: %v", err)
+	}
+
+	return &Client{
+		path: path,
+	}, nil
+}
+
+// NewViewClient creates a new filesystem client for viewing the backups under the prefix of the destination.
+// Nothing is created, a directory that doesn't exist has no backups.
+func NewViewClient(d config.DestinationOptions) (*Client, error) {
+	if d.Path == "" {
+		return nil, fmt.Errorf("no path configured for destination %s", d.Name)
+	}
+
+	return &Client{
+		path: filepath.Join(d.Path, filepath.FromSlash(d.Prefix)),
+	}, nil
+}
+
+// Put writes the content of the reader to a temporary file and renames it into place once complete.
+// The metadata is written to a file next to it first, so it's there as soon as the file is.
+func (c *Client) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) (*s3.Object, error) {
+	path, err := c.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+
+	// Clean up the temporary file unless it has been renamed into place
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, &contextReader{ctx: ctx, r: r}); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("could not write file: %v", err)
+	}
+
+	// Make sure the data is on disk before the rename makes it visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("could not sync file: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("could not close file: %v", err)
+	}
+
+	if err := writeMetadata(path, metadata); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("could not rename file: %v", err)
+	}
+
+	return c.Stat(ctx, key)
+}
+
+// Stat returns the attributes of the file with the given key
+func (c *Client) Stat(ctx context.Context, key string) (*s3.Object, error) {
+	path, err := c.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	object := newObject(key, info)
+
+	object.Metadata, err = readMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return object, nil
+}
+
+// List returns all files in the directory, subdirectories hold the backups of other instances
+func (c *Client) List(ctx context.Context) ([]*s3.Object, error) {
+	objects := []*s3.Object{}
+
+	if _, err := os.Stat(c.path); errors.Is(err, fs.ErrNotExist) {
+		return objects, nil
+	}
+
+	err := filepath.WalkDir(c.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() && path != c.path {
+			return filepath.SkipDir
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) || strings.HasSuffix(d.Name(), metaSuffix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		key, err := filepath.Rel(c.path, path)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, newObject(filepath.ToSlash(key), info))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not list files: %v", err)
+	}
+
+	return objects, nil
+}
+
+// Get opens the file with the given key for reading, reads fail once the context is cancelled.
+// The returned reader is an io.ReadSeekCloser, so readers can skip over content they don't need.
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := c.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contextFile{contextReader: contextReader{ctx: ctx, r: file}, file: file}, nil
+}
+
+// Delete removes the file with the given key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	path, err := c.resolve(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path + metaSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// writeMetadata atomically writes the metadata of the file at the given path
+func writeMetadata(path string, metadata map[string]string) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write metadata: %v", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path+metaSuffix); err != nil {
+		return fmt.Errorf("could not rename file: %v", err)
+	}
+
+	return nil
+}
+
+// readMetadata reads the metadata of the file at the given path, files without metadata have none
+func readMetadata(path string) (map[string]string, error) {
+	metadata := make(map[string]string)
+
+	data, err := os.ReadFile(path + metaSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return metadata, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read metadata: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("could not parse metadata: %v", err)
+	}
+
+	return metadata, nil
+}
+
+// resolve maps a key to a path inside the directory, rejecting keys that would escape it
+func (c *Client) resolve(key string) (string, error) {
+	local := filepath.FromSlash(key)
+	if !filepath.IsLocal(local) {
+		return "", fmt.Errorf("invalid key: %s", key)
+	}
+
+	return filepath.Join(c.path, local), nil
+}
+
+// newObject converts file info to an object
+func newObject(key string, info fs.FileInfo) *s3.Object {
+	return &s3.Object{
+		Key:      key,
+		Size:     float64(info.Size()) / (1024 * 1024), // convert bytes to MB
+		Modified: info.ModTime(),
+	}
+}
+
+// contextReader stops reading once the context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return cr.r.Read(p)
+}
+
+// contextFile is a file that stops reading once the context is cancelled, and can still seek
+type contextFile struct {
+	contextReader
+	file *os.File
+}
+
+func (cf *contextFile) Seek(offset int64, whence int) (int64, error) {
+	return cf.file.Seek(offset, whence)
+}
+
+func (cf *contextFile) Close() error {
+	return cf.file.Close()
+}
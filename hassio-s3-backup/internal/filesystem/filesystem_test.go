@@ -0,0 +1,170 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hassio-proton-drive-backup/internal/config"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, t.TempDir())
+
+	data := []byte("backup tarball")
+	metadata := map[string]string{"slug": "aaaa1111", "name": "Full Backup"}
+
+	object, err := c.Put(ctx, "Full_Backup.tar", bytes.NewReader(data), int64(len(data)), metadata)
+	if err != nil {
+		t.Fatalf("Put returned error: %v", err)
+	}
+	if object.Key != "Full_Backup.tar" || object.Size != float64(len(data))/(1024*1024) || object.Metadata["slug"] != "aaaa1111" {
+		t.Errorf("Put returned %+v", object)
+	}
+
+	object, err = c.Stat(ctx, "Full_Backup.tar")
+	if err != nil {
+		t.Fatalf("Stat returned error: %v", err)
+	}
+	if len(object.Metadata) != 2 || object.Metadata["name"] != "Full Backup" {
+		t.Errorf("Stat returned metadata %v, want %v", object.Metadata, metadata)
+	}
+
+	r, err := c.Get(ctx, "Full_Backup.tar")
+	if err != nil {
+		t.Fatalf("Get returned error: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Get returned %q, want %q", got, data)
+	}
+
+	if err := c.Delete(ctx, "Full_Backup.tar"); err != nil {
+		t.Fatalf("Delete returned error: %v", err)
+	}
+	if _, err := c.Stat(ctx, "Full_Backup.tar"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of a deleted file returned %v, want %v", err, fs.ErrNotExist)
+	}
+	if entries, _ := os.ReadDir(c.path); len(entries) != 0 {
+		t.Errorf("%d files are left after deleting the only one", len(entries))
+	}
+}
+
+func TestListSkipsMetadataAndOtherInstances(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	c := newTestClient(t, dir)
+
+	for _, key := range []string{"First.tar", "Second.tar"} {
+		if _, err := c.Put(ctx, key, strings.NewReader(key), int64(len(key)), map[string]string{"slug": key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The backups of another instance are in a subdirectory, and a write still in progress has a temporary file
+	other := newTestClient(t, filepath.Join(dir, "other"))
+	if _, err := other.Put(ctx, "Other.tar", strings.NewReader("other"), 5, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, tempPrefix+"123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := c.List(ctx)
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+
+	keys := []string{}
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	if len(keys) != 2 || keys[0] != "First.tar" || keys[1] != "Second.tar" {
+		t.Errorf("List returned %v, want only the backups of the instance", keys)
+	}
+}
+
+func TestListMissingDirectory(t *testing.T) {
+	c, err := NewViewClient(config.DestinationOptions{Name: "nas", Path: filepath.Join(t.TempDir(), "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := c.List(context.Background())
+	if err != nil || len(objects) != 0 {
+		t.Errorf("List of a missing directory returned %v and error %v, want no objects", objects, err)
+	}
+}
+
+func TestPutFailureLeavesNoFile(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, t.TempDir())
+
+	errBroken := errors.New("connection lost")
+	r := io.MultiReader(strings.NewReader("first half"), &failingReader{err: errBroken})
+	if _, err := c.Put(ctx, "Full_Backup.tar", r, 100, map[string]string{"slug": "aaaa1111"}); err == nil || !strings.Contains(err.Error(), errBroken.Error()) {
+		t.Fatalf("Put of a failing reader returned %v, want %v", err, errBroken)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.Put(cancelled, "Other.tar", strings.NewReader("data"), 4, nil); err == nil {
+		t.Fatal("Put with a cancelled context returned no error")
+	}
+
+	if entries, _ := os.ReadDir(c.path); len(entries) != 0 {
+		names := []string{}
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("failed writes left files %v", names)
+	}
+	if objects, _ := c.List(ctx); len(objects) != 0 {
+		t.Errorf("List returned %d objects after failed writes", len(objects))
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(t, t.TempDir())
+
+	for _, key := range []string{"../escape.tar", "/etc/passwd", ""} {
+		if _, err := c.Put(ctx, key, strings.NewReader("data"), 4, nil); err == nil {
+			t.Errorf("Put of key %q returned no error", key)
+		}
+		if _, err := c.Get(ctx, key); err == nil {
+			t.Errorf("Get of key %q returned no error", key)
+		}
+	}
+}
+
+// newTestClient creates a client for the directory, creating it like a configured destination
+func newTestClient(t *testing.T, path string) *Client {
+	t.Helper()
+
+	c, err := NewClient(config.DestinationOptions{Name: "nas", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// failingReader fails every read with the given error
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
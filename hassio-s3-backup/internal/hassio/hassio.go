@@ -2,6 +2,7 @@ package hassio
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,11 +14,49 @@ import (
 
 // Backup represents the details of a backup in Home Assistant
 type Backup struct {
-	Date time.Time `json:"date"`
-	Slug string    `json:"slug"`
-	Name string    `json:"name"`
-	Type string    `json:"type"`
-	Size float64   `json:"size"`
+	Date      time.Time `json:"date"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Size      float64   `json:"size"`
+	Protected bool      `json:"protected"`
+	Content   Content   `json:"content"`
+}
+
+// Content represents what's included in a backup
+type Content struct {
+	HomeAssistant bool     `json:"homeassistant"`
+	Addons        []string `json:"addons"`
+	Folders       []string `json:"folders"`
+}
+
+// BackupRequest represents the options for creating a backup in Home Assistant
+type BackupRequest struct {
+	Name                         string `json:"name"`
+	Password                     string `json:"password,omitempty"`
+	Compressed                   bool   `json:"compressed"`
+	HomeassistantExcludeDatabase bool   `json:"homeassistant_exclude_database,omitempty"`
+}
+
+// PartialBackupRequest represents the options for creating a partial backup in Home Assistant
+type PartialBackupRequest struct {
+	BackupRequest
+	HomeAssistant bool     `json:"homeassistant"`
+	Addons        []string `json:"addons,omitempty"`
+	Folders       []string `json:"folders,omitempty"`
+}
+
+// RestoreRequest represents the options for restoring a backup in Home Assistant
+type RestoreRequest struct {
+	Password string `json:"password,omitempty"`
+}
+
+// PartialRestoreRequest represents the options for restoring the selected add-ons and folders of a backup in Home Assistant
+type PartialRestoreRequest struct {
+	RestoreRequest
+	HomeAssistant bool     `json:"homeassistant"`
+	Addons        []string `json:"addons,omitempty"`
+	Folders       []string `json:"folders,omitempty"`
 }
 
 // BaseResponse represents a generic response from Home Assistant
@@ -49,6 +88,20 @@ type IngressResponse struct {
 	} `json:"data"`
 }
 
+// HostInfoResponse represents the response from the Supervisor for the host info
+type HostInfoResponse struct {
+	BaseResponse
+	Data struct {
+		Hostname string `json:"hostname"`
+	} `json:"data"`
+}
+
+// State is the state of an entity in Home Assistant
+type State struct {
+	State      string                 `json:"state"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
 // Client is a client for the Hassio API
 type Client struct {
 	client *http.Client
@@ -65,13 +118,33 @@ func (r *RequestError) Error() string {
 	return fmt.Sprintf("status %d: %v", r.StatusCode, r.Err)
 }
 
+// supervisorURL is the address of the Supervisor API from inside an add-on
+const supervisorURL = "http://supervisor"
+
+// Timeouts of requests to the Supervisor
+const (
+	requestTimeout = time.Minute     // Requests that are answered right away, such as listing or deleting backups
+	backupTimeout  = 3 * time.Hour   // Creating or restoring a backup, which the Supervisor only answers once it's done
+	stallTimeout   = 5 * time.Minute // Time the upload of a backup may stop sending data
+)
+
 // NewService initializes and returns a new Hassio Client
 func NewService(token string) *Client {
+	return NewClient(supervisorURL, token)
+}
+
+// NewClient returns a Hassio Client for the Supervisor API at the given url.
+// Creating and restoring backups end after backupTimeout, uploads once they stall for stallTimeout,
+// and every other request after requestTimeout at the latest. The Supervisor has to answer an uploaded backup
+// within backupTimeout, so a hung request never blocks the operations waiting for it.
+func NewClient(url string, token string) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = backupTimeout
+
 	return &Client{
-		token: token,
-		client: &http.Client{
-			Timeout: 10 * time.Minute,
-		},
+		token:  token,
+		url:    url,
+		client: &http.Client{Transport: transport},
 	}
 }
 
@@ -109,10 +182,13 @@ func handleResponse(resp *http.Response, data interface{}) error {
 }
 
 // GetBackup retrieves the details of a specific backup by its slug
-func (c *Client) GetBackup(slug string) (*Backup, error) {
+func (c *Client) GetBackup(ctx context.Context, slug string) (*Backup, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	// Create the HTTP request
-	url := fmt.Sprintf("http://supervisor/backups/%s/info", slug)
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/backups/%s/info", c.url, slug)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -139,10 +215,13 @@ func (c *Client) GetBackup(slug string) (*Backup, error) {
 }
 
 // ListBackups retrieves a list of all backups from Home Assistant
-func (c *Client) ListBackups() ([]*Backup, error) {
+func (c *Client) ListBackups(ctx context.Context) ([]*Backup, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	// Create the HTTP request
-	url := "http://supervisor/backups"
-	req, err := http.NewRequest("GET", url, nil)
+	url := c.url + "/backups"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -164,14 +243,29 @@ func (c *Client) ListBackups() ([]*Backup, error) {
 }
 
 // BackupFull requests a full backup from Home Assistant
-func (c *Client) BackupFull(name string) (string, error) {
+func (c *Client) BackupFull(ctx context.Context, request BackupRequest) (string, error) {
+	return c.createBackup(ctx, c.url+"/backups/new/full", request)
+}
+
+// BackupPartial requests a partial backup of the selected add-ons and folders from Home Assistant
+func (c *Client) BackupPartial(ctx context.Context, request PartialBackupRequest) (string, error) {
+	return c.createBackup(ctx, c.url+"/backups/new/partial", request)
+}
+
+// createBackup posts a backup request to the given url and returns the slug of the new backup
+func (c *Client) createBackup(ctx context.Context, url string, request interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, backupTimeout)
+	defer cancel()
+
 	// Create the JSON body for the request
-	jsonBody := []byte(fmt.Sprintf(`{"name": "%s"}`, name))
+	jsonBody, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
 	bodyReader := bytes.NewReader(jsonBody)
 
 	// Create the HTTP request
-	url := "http://supervisor/backups/new/full"
-	req, err := http.NewRequest(http.MethodPost, url, bodyReader)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bodyReader)
 	if err != nil {
 		return "", err
 	}
@@ -200,38 +294,105 @@ func (c *Client) BackupFull(name string) (string, error) {
 	return slug, nil
 }
 
-// UploadBackup uploads a backup file to Home Assistant
-func (c *Client) UploadBackup(data io.Reader) error {
-	// Create a buffer to hold the multipart form data
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+// UploadBackup streams a backup file to Home Assistant, the upload ends with the context or once it stalls
+func (c *Client) UploadBackup(ctx context.Context, data io.Reader) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Write the multipart form data as it's sent, so the backup is never held in memory
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+
+	var copyErr error
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+
+		// Create the form file field
+		part, err := writer.CreateFormFile("file", "temp")
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		// Copy the file content into the form field
+		if _, copyErr = io.Copy(part, data); copyErr != nil {
+			pw.CloseWithError(copyErr)
+			return
+		}
+
+		// Close the multipart writer to finalize the form data
+		pw.CloseWithError(writer.Close())
+	}()
 
-	// Create the form file field
-	part, err := writer.CreateFormFile("file", "temp")
+	// Stop writing and wait for the writer, so data isn't read once this returns
+	defer func() {
+		pr.Close()
+		<-copied
+	}()
+
+	// Create the HTTP request
+	url := c.url + "/backups/new/upload"
+	stall := time.AfterFunc(stallTimeout, func() { cancel(errUploadStalled) })
+	defer stall.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, &stallReader{r: pr, timer: stall})
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	// Copy the file content into the form field
-	_, err = io.Copy(part, data)
+	// Perform the request
+	resp, err := c.client.Do(req)
 	if err != nil {
+		// Report why the backup couldn't be read, such as a failed decryption, rather than the aborted request
+		pr.Close()
+		<-copied
+		if errors.Is(context.Cause(ctx), errUploadStalled) {
+			return errUploadStalled
+		}
+		if copyErr != nil {
+			return copyErr
+		}
 		return err
 	}
 
-	// Close the multipart writer to finalize the form data
-	err = writer.Close()
+	return handleResponse(resp, nil)
+}
+
+// errUploadStalled is returned when an upload stopped sending data, unlike a cancelled upload it can be retried
+var errUploadStalled = fmt.Errorf("upload stalled for %s", stallTimeout)
+
+// stallReader restarts its timer whenever data is read, and stops it once all data was read
+type stallReader struct {
+	r     io.Reader
+	timer *time.Timer
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
 	if err != nil {
-		return err
+		s.timer.Stop()
+	} else {
+		s.timer.Reset(stallTimeout)
 	}
 
+	return n, err
+}
+
+// DeleteBackup requests a specific backup to be deleted from Home Assistant
+func (c *Client) DeleteBackup(ctx context.Context, slug string) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
 	// Create the HTTP request
-	url := "http://supervisor/backups/new/upload"
-	req, err := http.NewRequest("POST", url, body)
+	url := fmt.Sprintf("%s/backups/%s", c.url, slug)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Authorization", "Bearer "+c.token)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
 	// Perform the request
 	resp, err := c.client.Do(req)
@@ -242,14 +403,34 @@ func (c *Client) UploadBackup(data io.Reader) error {
 	return handleResponse(resp, nil)
 }
 
-// DeleteBackup requests a specific backup to be deleted from Home Assistant
-func (c *Client) DeleteBackup(slug string) error {
+// RestoreBackup requests a specific backup to be restored in Home Assistant, the password is only used for protected backups
+func (c *Client) RestoreBackup(ctx context.Context, slug string, password string) error {
+	return c.restoreBackup(ctx, fmt.Sprintf("%s/backups/%s/restore/full", c.url, slug), RestoreRequest{Password: password})
+}
+
+// RestorePartialBackup requests the selected content of a partial backup to be restored in Home Assistant.
+// The Supervisor refuses to restore partial backups in full.
+func (c *Client) RestorePartialBackup(ctx context.Context, slug string, request PartialRestoreRequest) error {
+	return c.restoreBackup(ctx, fmt.Sprintf("%s/backups/%s/restore/partial", c.url, slug), request)
+}
+
+// restoreBackup posts a restore request to the given url
+func (c *Client) restoreBackup(ctx context.Context, url string, request interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, backupTimeout)
+	defer cancel()
+
+	// Create the JSON body for the request
+	jsonBody, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
 	// Create the HTTP request
-	url := fmt.Sprintf("http://supervisor/backups/%s", slug)
-	req, err := http.NewRequest("DELETE", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.token)
 
 	// Perform the request
@@ -261,14 +442,50 @@ func (c *Client) DeleteBackup(slug string) error {
 	return handleResponse(resp, nil)
 }
 
-// RestoreBackup requests a specific backup to be restored in Home Assistant
-func (c *Client) RestoreBackup(slug string) error {
+// SetState creates or updates the state of an entity in Home Assistant through the Core API proxy of the Supervisor
+func (c *Client) SetState(ctx context.Context, entityID string, state State) error {
+	return c.postCore(ctx, fmt.Sprintf("states/%s", entityID), state)
+}
+
+// CreateNotification creates a persistent notification in Home Assistant, replacing the one with the same ID
+func (c *Client) CreateNotification(ctx context.Context, id string, title string, message string) error {
+	return c.postCore(ctx, "services/persistent_notification/create", map[string]string{
+		"notification_id": id,
+		"title":           title,
+		"message":         message,
+	})
+}
+
+// DismissNotification removes a persistent notification from Home Assistant
+func (c *Client) DismissNotification(ctx context.Context, id string) error {
+	return c.postCore(ctx, "services/persistent_notification/dismiss", map[string]string{
+		"notification_id": id,
+	})
+}
+
+// FireEvent fires an event of the given type in Home Assistant
+func (c *Client) FireEvent(ctx context.Context, eventType string, data interface{}) error {
+	return c.postCore(ctx, fmt.Sprintf("events/%s", eventType), data)
+}
+
+// postCore posts a JSON body to the Core API of Home Assistant through the proxy of the Supervisor
+func (c *Client) postCore(ctx context.Context, path string, body interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	// Create the JSON body for the request
+	jsonBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
 	// Create the HTTP request
-	url := fmt.Sprintf("http://supervisor/backups/%s/restore/full", slug)
-	req, err := http.NewRequest("POST", url, nil)
+	url := fmt.Sprintf("%s/core/api/%s", c.url, path)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
 	if err != nil {
 		return err
 	}
+	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.token)
 
 	// Perform the request
@@ -277,7 +494,61 @@ func (c *Client) RestoreBackup(slug string) error {
 		return err
 	}
 
-	return handleResponse(resp, nil)
+	return handleCoreResponse(resp)
+}
+
+// handleCoreResponse checks the response of a request to the Core API, which doesn't use the response format of the Supervisor
+func handleCoreResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	var response struct {
+		Message string `json:"message"`
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+
+	message := string(respBody)
+	if json.Unmarshal(respBody, &response) == nil && response.Message != "" {
+		message = response.Message
+	}
+
+	return &RequestError{
+		StatusCode: resp.StatusCode,
+		Err:        errors.New(message),
+	}
+}
+
+// GetHostname returns the hostname of the host running the Supervisor
+func GetHostname(token string) (string, error) {
+	req, err := http.NewRequest("GET", "http://supervisor/host/info", nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Add("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	var response HostInfoResponse
+	if err := handleResponse(resp, &response); err != nil {
+		return "", err
+	}
+
+	if response.Data.Hostname == "" {
+		return "", errors.New("missing hostname in response")
+	}
+
+	return response.Data.Hostname, nil
 }
 
 // GetIngressEntry returns the hassio ingress path for the addon
@@ -285,7 +556,7 @@ func GetIngressEntry(token string) (string, error) {
 	bearer := "Bearer " + token
 
 	// Create the HTTP request
-	url := "http://supervisor/addons/self/info"
+	url := supervisorURL + "/addons/self/info"
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", err
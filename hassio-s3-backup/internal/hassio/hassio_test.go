@@ -0,0 +1,44 @@
+package hassio
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBackupFullSendsRequest(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/backups/new/full" {
+			t.Errorf("received %s %s, want POST /backups/new/full", r.Method, r.URL.Path)
+		}
+		body, _ = io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "ok", "data": {"slug": "aaaa1111"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "token")
+	request := BackupRequest{Name: `Backup "before" the \ upgrade`, Password: "secret", Compressed: true}
+
+	slug, err := client.BackupFull(context.Background(), request)
+	if err != nil {
+		t.Fatalf("BackupFull returned error: %v", err)
+	}
+	if slug != "aaaa1111" {
+		t.Errorf("BackupFull returned slug %q, want aaaa1111", slug)
+	}
+
+	// Names with quotes and backslashes are sent as they are
+	var received BackupRequest
+	if err := json.Unmarshal(body, &received); err != nil {
+		t.Fatalf("request body %s isn't JSON: %v", body, err)
+	}
+	if received != request {
+		t.Errorf("Supervisor received %+v, want %+v", received, request)
+	}
+}
@@ -0,0 +1,333 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Buckets for the durations of backups, in seconds
+var DurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+
+// Buckets for the latencies of requests, in seconds
+var LatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// collector is a metric that writes itself in the Prometheus text format
+type collector interface {
+	describe() *desc
+	write(w io.Writer)
+}
+
+// Registry holds the metrics served by its handler
+type Registry struct {
+	mutex      sync.Mutex
+	collectors map[string]collector
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// DefaultRegistry is the registry the metrics are created in
+var DefaultRegistry = NewRegistry()
+
+// register adds a metric to the registry and returns the metric to use. A counter or histogram created again
+// under the same name is the one already registered, so its series are kept, while a gauge function replaces
+// the earlier one. Metrics of another kind or with other labels can't share a name.
+func (r *Registry) register(c collector) collector {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	d := c.describe()
+	if existing, exists := r.collectors[d.metricName]; exists {
+		e := existing.describe()
+		if e.kind != d.kind || !slices.Equal(e.labels, d.labels) {
+			panic(fmt.Sprintf("metric %s is already registered as a %s with labels %v", d.metricName, e.kind, e.labels))
+		}
+
+		if _, replace := c.(*gaugeFunc); !replace {
+			return existing
+		}
+	}
+
+	r.collectors[d.metricName] = c
+	return c
+}
+
+// Handler returns a handler serving the metrics of the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mutex.Lock()
+		collectors := make([]collector, 0, len(r.collectors))
+		for _, c := range r.collectors {
+			collectors = append(collectors, c)
+		}
+		r.mutex.Unlock()
+
+		sort.Slice(collectors, func(i, j int) bool {
+			return collectors[i].describe().metricName < collectors[j].describe().metricName
+		})
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		for _, c := range collectors {
+			c.write(w)
+		}
+	})
+}
+
+// desc describes a metric
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d *desc) describe() *desc {
+	return d
+}
+
+// writeHeader writes the help and type of the metric
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, d.help, d.metricName, d.kind)
+}
+
+// series is the value of a metric for one set of label values
+type series struct {
+	labels []string
+	value  float64
+}
+
+// vec holds the series of a counter by label values
+type vec struct {
+	desc
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+// get returns the series for the label values, creating it if needed. The mutex must be held.
+func (v *vec) get(labels []string) *series {
+	if len(labels) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", v.metricName, len(v.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+	s, exists := v.series[key]
+	if !exists {
+		s = &series{labels: append([]string(nil), labels...)}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.writeHeader(w)
+	for _, s := range sortedSeries(v.series) {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, formatLabels(v.labels, s.labels, "", ""), formatValue(s.value))
+	}
+}
+
+// Counter is a value that only goes up, by label values
+type Counter struct {
+	vec
+}
+
+// NewCounter creates a counter with the given label names in the default registry
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewCounter creates a counter with the given label names in the registry
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{vec{desc: desc{name, help, "counter", labels}, series: make(map[string]*series)}}
+	return r.register(c).(*Counter)
+}
+
+// Add increases the counter for the label values
+func (c *Counter) Add(value float64, labels ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.get(labels).value += value
+}
+
+// Inc increases the counter for the label values by one
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Sample is a value of a gauge function for one set of label values
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// gaugeFunc is a gauge whose values are collected when the metrics are served
+type gaugeFunc struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc creates a gauge in the default registry whose values are collected by f when the metrics are served
+func NewGaugeFunc(name string, help string, labels []string, f func() []Sample) {
+	DefaultRegistry.NewGaugeFunc(name, help, labels, f)
+}
+
+// NewGaugeFunc creates a gauge in the registry whose values are collected by f when the metrics are served
+func (r *Registry) NewGaugeFunc(name string, help string, labels []string, f func() []Sample) {
+	r.register(&gaugeFunc{desc: desc{name, help, "gauge", labels}, collect: f})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	samples := g.collect()
+	sort.Slice(samples, func(i, j int) bool {
+		return strings.Join(samples[i].Labels, "\xff") < strings.Join(samples[j].Labels, "\xff")
+	})
+
+	g.writeHeader(w)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, formatLabels(g.labels, s.Labels, "", ""), formatValue(s.Value))
+	}
+}
+
+// histogramSeries is the distribution of observations for one set of label values
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // Observations by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Histogram counts observations in buckets, by label values
+type Histogram struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogram creates a histogram with the given upper bounds of its buckets and label names in the default registry
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labels...)
+}
+
+// NewHistogram creates a histogram with the given upper bounds of its buckets and label names in the registry
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	return r.register(h).(*Histogram)
+}
+
+// Observe adds an observation for the label values
+func (h *Histogram) Observe(value float64, labels ...string) {
+	if len(labels) != len(h.labels) {
+		panic(fmt.Sprintf("metric %s takes %d labels, got %d", h.metricName, len(h.labels), len(labels)))
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := strings.Join(labels, "\xff")
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{labels: append([]string(nil), labels...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h.writeHeader(w)
+	for _, key := range keys {
+		s := h.series[key]
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, s.labels, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.labels, s.labels, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.labels, s.labels, "", ""), s.count)
+	}
+}
+
+// sortedSeries returns the series ordered by their label values
+func sortedSeries(m map[string]*series) []*series {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, m[key])
+	}
+
+	return sorted
+}
+
+// formatLabels formats label names and values as {name="value",...}, with an extra label if its name isn't empty
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+labelEscaper.Replace(extraValue)+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// labelEscaper escapes label values as the Prometheus text format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatValue formats a sample value
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
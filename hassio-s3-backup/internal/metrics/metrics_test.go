@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests served.", "path", "code")
+
+	c.Inc("/b", "200")
+	c.Add(2.5, "/a", "500")
+	c.Inc("/a", "500")
+	c.Inc(`C:\backups "daily"`+"\n", "200")
+
+	assertExposition(t, r, `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{path="/a",code="500"} 3.5
+requests_total{path="/b",code="200"} 1
+requests_total{path="C:\\backups \"daily\"\n",code="200"} 1
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("duration_seconds", "Time taken.", []float64{0.5, 1, 5}, "operation")
+
+	for _, value := range []float64{0.25, 1, 3, 3, 10} {
+		h.Observe(value, "get")
+	}
+	h.Observe(0.5, "put")
+
+	// Buckets are cumulative, and the +Inf bucket counts every observation
+	assertExposition(t, r, `# HELP duration_seconds Time taken.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{operation="get",le="0.5"} 1
+duration_seconds_bucket{operation="get",le="1"} 2
+duration_seconds_bucket{operation="get",le="5"} 4
+duration_seconds_bucket{operation="get",le="+Inf"} 5
+duration_seconds_sum{operation="get"} 17.25
+duration_seconds_count{operation="get"} 5
+duration_seconds_bucket{operation="put",le="0.5"} 1
+duration_seconds_bucket{operation="put",le="1"} 1
+duration_seconds_bucket{operation="put",le="5"} 1
+duration_seconds_bucket{operation="put",le="+Inf"} 1
+duration_seconds_sum{operation="put"} 0.5
+duration_seconds_count{operation="put"} 1
+`)
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	r.NewGaugeFunc("backups", "Backups by status.", []string{"status"}, func() []Sample {
+		return []Sample{{Labels: []string{"SYNCED"}, Value: 3}, {Labels: []string{"FAILED"}, Value: 1}}
+	})
+	r.NewGaugeFunc("up", "Whether the add-on is up.", nil, func() []Sample {
+		return []Sample{{Value: 1}}
+	})
+
+	assertExposition(t, r, `# HELP backups Backups by status.
+# TYPE backups gauge
+backups{status="FAILED"} 1
+backups{status="SYNCED"} 3
+# HELP up Whether the add-on is up.
+# TYPE up gauge
+up 1
+`)
+}
+
+func TestRegisterAgain(t *testing.T) {
+	r := NewRegistry()
+
+	// Counters and histograms created again are the ones already registered, so no series is lost or served twice
+	first := r.NewCounter("errors_total", "Errors.", "operation")
+	second := r.NewCounter("errors_total", "Errors.", "operation")
+	first.Inc("get")
+	second.Inc("get")
+
+	r.NewHistogram("duration_seconds", "Time taken.", []float64{1}).Observe(0.5)
+	r.NewHistogram("duration_seconds", "Time taken.", []float64{1}).Observe(2)
+
+	// Gauge functions created again replace the earlier one, like the ones of a service created again
+	r.NewGaugeFunc("backups", "Backups.", nil, func() []Sample { return []Sample{{Value: 1}} })
+	r.NewGaugeFunc("backups", "Backups.", nil, func() []Sample { return []Sample{{Value: 2}} })
+
+	assertExposition(t, r, `# HELP backups Backups.
+# TYPE backups gauge
+backups 2
+# HELP duration_seconds Time taken.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="1"} 1
+duration_seconds_bucket{le="+Inf"} 2
+duration_seconds_sum 2.5
+duration_seconds_count 2
+# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{operation="get"} 2
+`)
+
+	// Mistakes in the use of metrics panic
+	for name, f := range map[string]func(){
+		"name of another kind": func() { r.NewHistogram("errors_total", "Errors.", []float64{1}, "operation") },
+		"other labels":         func() { r.NewCounter("errors_total", "Errors.", "destination") },
+		"wrong label count":    func() { first.Inc("get", "extra") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("didn't panic")
+				}
+			}()
+			f()
+		})
+	}
+}
+
+// assertExposition checks that the handler of the registry serves exactly the given metrics
+func assertExposition(t *testing.T, r *Registry, want string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("served metrics as %q", contentType)
+	}
+	if got := w.Body.String(); got != want {
+		t.Errorf("served metrics:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -1,21 +1,34 @@
 package s3
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"hassio-proton-drive-backup/internal/config"
+	"io"
 	"log/slog"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
+const (
+	defaultPartSize = 16 * 1024 * 1024   // Bytes, used when no part size is configured
+	maxParts        = 10000              // The most parts S3 allows in a multipart upload
+	maxBuffered     = 1024 * 1024 * 1024 // Bytes of parts held in memory while they're uploaded at the same time
+)
+
 type Object struct {
-	Modified time.Time `json:"modified"`
-	Key      string    `json:"key"`
-	Size     float64   `json:"size"`
+	Modified time.Time         `json:"modified"`
+	Key      string            `json:"key"`
+	Size     float64           `json:"size"`
+	Metadata map[string]string `json:"metadata,omitempty"` // User metadata with lower case keys, only set by Stat
 }
 
 type Credentials struct {
@@ -23,15 +36,69 @@ type Credentials struct {
 	SecretAccessKey string
 }
 
-// NewClient creates a new S3 client
-func NewClient(cs *config.Service) (*minio.Client, error) {
-	c := cs.Config
+// Client is a storage target backed by an S3 compatible bucket
+type Client struct {
+	client      *minio.Client
+	bucket      string
+	partSize    uint64
+	concurrency uint
+	prefix      string // Prepended to every key, so the client only sees objects under it
+}
+
+// NewClient creates a new S3 client for the given destination, creating the bucket if it doesn't exist
+func NewClient(d config.DestinationOptions, upload config.UploadOptions) (*Client, error) {
+	client, err := newMinioClient(d)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if the specified bucket exists in S3
+	bucketExists, err := client.BucketExists(context.Background(), d.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("could not check if bucket exists: %v", err)
+	}
+
+	// If the bucket does not exist, create it
+	if !bucketExists {
+		err := client.MakeBucket(context.Background(), d.Bucket, minio.MakeBucketOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not create bucket: %v", err)
+		}
+	}
+
+	// Return the initialized S3 client
+	return &Client{
+		client:      client,
+		bucket:      d.Bucket,
+		partSize:    uint64(upload.PartSize) * 1024 * 1024,
+		concurrency: uint(upload.Concurrency),
+		prefix:      d.Prefix,
+	}, nil
+}
+
+// NewViewClient creates a new S3 client for viewing the backups under the prefix of the destination,
+// without touching the bucket. It's only used to list and stat objects.
+func NewViewClient(d config.DestinationOptions) (*Client, error) {
+	client, err := newMinioClient(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		client: client,
+		bucket: d.Bucket,
+		prefix: d.Prefix,
+	}, nil
+}
+
+// newMinioClient creates the minio client for the endpoint and credentials of a destination
+func newMinioClient(d config.DestinationOptions) (*minio.Client, error) {
 	// Get bucket and credentials from config
-	bucket := c.S3.Bucket
-	creds := credentials.NewStaticV4(c.S3.AccessKey, c.S3.SecretKey, "")
+	bucket := d.Bucket
+	creds := credentials.NewStaticV4(d.AccessKey, d.SecretKey, "")
 
 	// Parse the S3 endpoint URL from the config
-	url, err := url.Parse(c.S3.Endpoint)
+	url, err := url.Parse(d.Endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("could not parse endpoint: %v", err)
 	}
@@ -46,7 +113,7 @@ func NewClient(cs *config.Service) (*minio.Client, error) {
 	}
 
 	// Log the initialization of the S3 client with debug level
-	slog.Debug("initializing S3 client", "endpoint", url, "bucket", bucket)
+	slog.Debug("initializing S3 client", "destination", d.Name, "endpoint", url, "bucket", bucket, "prefix", d.Prefix)
 
 	// Create a new minio client with the parsed URL host and options
 	client, err := minio.New(url.Host, opts)
@@ -54,20 +121,391 @@ func NewClient(cs *config.Service) (*minio.Client, error) {
 		return nil, fmt.Errorf("could not create S3 client: %v", err)
 	}
 
-	// Check if the specified bucket exists in S3
-	bucketExists, err := client.BucketExists(context.Background(), bucket)
+	return client, nil
+}
+
+// Put streams the content of the reader to the bucket under the given key.
+// Backups are uploaded by PutResumable, which is the one using the configured part size and concurrency.
+func (c *Client) Put(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string) (*Object, error) {
+	opts := minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: metadata,
+	}
+
+	info, err := c.client.PutObject(ctx, c.bucket, c.fullKey(key), r, size, opts)
 	if err != nil {
-		return nil, fmt.Errorf("could not check if bucket exists: %v", err)
+		return nil, err
 	}
 
-	// If the bucket does not exist, create it
-	if !bucketExists {
-		err := client.MakeBucket(context.Background(), bucket, minio.MakeBucketOptions{})
+	return &Object{
+		Key:      c.relativeKey(info.Key),
+		Size:     bytesToMB(info.Size),
+		Modified: info.LastModified,
+	}, nil
+}
+
+// Upload is the state of a multipart upload, persisted so it can be resumed after an interruption
+type Upload struct {
+	ID        string    `json:"id"`
+	Key       string    `json:"key"` // Key relative to the prefix of the client, like the keys of objects
+	Size      int64     `json:"size"`
+	PartSize  int64     `json:"partSize"`
+	Parts     []Part    `json:"parts"`
+	Initiated time.Time `json:"initiated"`
+}
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// offset returns the offset the upload continues from, the end of the parts uploaded without gaps
+func (u *Upload) offset() (int64, int) {
+	uploaded := make(map[int]bool)
+	for _, p := range u.Parts {
+		uploaded[p.Number] = true
+	}
+
+	next := 1
+	for uploaded[next] {
+		next++
+	}
+
+	return int64(next-1) * u.PartSize, next
+}
+
+// addPart records an uploaded part, replacing an earlier upload of the same part
+func (u *Upload) addPart(part Part) {
+	for i := range u.Parts {
+		if u.Parts[i].Number == part.Number {
+			u.Parts[i] = part
+			return
+		}
+	}
+
+	u.Parts = append(u.Parts, part)
+}
+
+// PutResumable uploads an object in parts, continuing the given upload if it still exists in the bucket.
+// open returns the content from an offset, and save is called with the upload state after every part.
+// Empty objects can't be uploaded in parts, they're put in a single request.
+func (c *Client) PutResumable(ctx context.Context, key string, open func(offset int64) (io.ReadCloser, error), size int64, metadata map[string]string, upload *Upload, save func(*Upload)) (*Object, error) {
+	if size == 0 {
+		r, err := open(0)
 		if err != nil {
-			return nil, fmt.Errorf("could not create bucket: %v", err)
+			return nil, err
 		}
+		defer r.Close()
+
+		return c.Put(ctx, key, r, size, metadata)
 	}
 
-	// Return the initialized S3 client
-	return client, nil
+	core := minio.Core{Client: c.client}
+
+	if upload != nil && !c.resumable(ctx, upload, key, size) {
+		slog.Info("discarding multipart upload that can't be resumed", "key", upload.Key, "upload", upload.ID)
+		if err := core.AbortMultipartUpload(ctx, c.bucket, c.fullKey(upload.Key), upload.ID); err != nil {
+			slog.Debug("could not abort multipart upload", "key", upload.Key, "upload", upload.ID, "error", err)
+		}
+		upload = nil
+	}
+
+	if upload == nil {
+		opts := minio.PutObjectOptions{ContentType: "application/octet-stream", UserMetadata: metadata}
+
+		id, err := core.NewMultipartUpload(ctx, c.bucket, c.fullKey(key), opts)
+		if err != nil {
+			return nil, fmt.Errorf("could not start multipart upload: %w", err)
+		}
+
+		upload = &Upload{
+			ID:        id,
+			Key:       key,
+			Size:      size,
+			PartSize:  c.uploadPartSize(size),
+			Parts:     []Part{},
+			Initiated: time.Now(),
+		}
+		save(upload)
+	}
+
+	offset, number := upload.offset()
+	if offset > 0 {
+		slog.Info("resuming multipart upload", "key", key, "offset", offset, "size", size)
+	}
+
+	r, err := open(offset)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	if err := c.uploadParts(ctx, upload, r, offset, number, save); err != nil {
+		return nil, err
+	}
+
+	parts := make([]minio.CompletePart, 0, len(upload.Parts))
+	for _, p := range upload.Parts {
+		parts = append(parts, minio.CompletePart{PartNumber: p.Number, ETag: p.ETag})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	if _, err := core.CompleteMultipartUpload(ctx, c.bucket, c.fullKey(key), upload.ID, parts, minio.PutObjectOptions{}); err != nil {
+		return nil, fmt.Errorf("could not complete multipart upload: %w", err)
+	}
+
+	return c.Stat(ctx, key)
+}
+
+// uploadParts uploads the remaining parts from the reader, several at a time if concurrency is configured.
+// Every part is held in memory while it's uploaded, so fewer run at the same time if they'd take too much.
+func (c *Client) uploadParts(ctx context.Context, upload *Upload, r io.Reader, offset int64, number int, save func(*Upload)) error {
+	core := minio.Core{Client: c.client}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := int(c.concurrency)
+	if limit := int(maxBuffered / upload.PartSize); concurrency > limit {
+		concurrency = limit
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		firstErr error
+	)
+	slots := make(chan struct{}, concurrency)
+
+	fail := func(err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for ; offset < upload.Size; number++ {
+		length := upload.PartSize
+		if remaining := upload.Size - offset; remaining < length {
+			length = remaining
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			<-slots
+			fail(fmt.Errorf("could not read part %d: %w", number, err))
+			break
+		}
+		offset += length
+
+		wg.Add(1)
+		go func(number int, buf []byte) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			part, err := core.PutObjectPart(ctx, c.bucket, c.fullKey(upload.Key), upload.ID, number, bytes.NewReader(buf), int64(len(buf)), minio.PutObjectPartOptions{})
+			if err != nil {
+				fail(fmt.Errorf("could not upload part %d: %w", number, err))
+				return
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			upload.addPart(Part{Number: number, ETag: part.ETag, Size: part.Size})
+			save(upload)
+		}(number, buf)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return ctx.Err()
+}
+
+// resumable returns true if the upload is for the same object and still exists in the bucket.
+// The parts in the bucket replace the persisted ones, they're the ones that will be completed.
+func (c *Client) resumable(ctx context.Context, upload *Upload, key string, size int64) bool {
+	if upload.Key != key || upload.Size != size || upload.PartSize <= 0 {
+		return false
+	}
+
+	core := minio.Core{Client: c.client}
+	parts := []Part{}
+	marker := 0
+
+	for {
+		result, err := core.ListObjectParts(ctx, c.bucket, c.fullKey(upload.Key), upload.ID, marker, 1000)
+		if err != nil {
+			return false
+		}
+
+		for _, p := range result.ObjectParts {
+			parts = append(parts, Part{Number: p.PartNumber, ETag: p.ETag, Size: p.Size})
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextPartNumberMarker
+	}
+
+	upload.Parts = parts
+
+	return true
+}
+
+// uploadPartSize returns the configured part size, increased if needed to stay within the limit of 10000 parts
+func (c *Client) uploadPartSize(size int64) int64 {
+	partSize := int64(c.partSize)
+	if partSize == 0 {
+		partSize = defaultPartSize
+	}
+
+	if minimum := (size + maxParts - 1) / maxParts; partSize < minimum {
+		// Round up to a whole MB
+		partSize = (minimum + 1024*1024 - 1) / (1024 * 1024) * (1024 * 1024)
+	}
+
+	return partSize
+}
+
+// IncompleteUploads returns the multipart uploads in the bucket that were never completed or aborted
+func (c *Client) IncompleteUploads(ctx context.Context) ([]*Upload, error) {
+	uploads := []*Upload{}
+
+	for info := range c.client.ListIncompleteUploads(ctx, c.bucket, c.prefix, true) {
+		if info.Err != nil {
+			return nil, fmt.Errorf("could not list incomplete uploads: %v", info.Err)
+		}
+
+		// Uploads in deeper prefixes belong to other instances
+		if strings.Contains(c.relativeKey(info.Key), "/") {
+			continue
+		}
+
+		uploads = append(uploads, &Upload{
+			ID:        info.UploadID,
+			Key:       c.relativeKey(info.Key),
+			Initiated: info.Initiated,
+		})
+	}
+
+	return uploads, nil
+}
+
+// AbortUpload aborts a multipart upload and removes its parts from the bucket
+func (c *Client) AbortUpload(ctx context.Context, key string, id string) error {
+	core := minio.Core{Client: c.client}
+	return core.AbortMultipartUpload(ctx, c.bucket, c.fullKey(key), id)
+}
+
+// Stat returns the attributes of the object with the given key
+func (c *Client) Stat(ctx context.Context, key string) (*Object, error) {
+	info, err := c.client.StatObject(ctx, c.bucket, c.fullKey(key), minio.StatObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range info.UserMetadata {
+		metadata[strings.ToLower(k)] = v
+	}
+
+	return &Object{
+		Key:      c.relativeKey(info.Key),
+		Size:     bytesToMB(info.Size),
+		Modified: info.LastModified,
+		Metadata: metadata,
+	}, nil
+}
+
+// List returns all objects directly under the prefix of the client
+func (c *Client) List(ctx context.Context) ([]*Object, error) {
+	objects := []*Object{}
+
+	for object := range c.client.ListObjects(ctx, c.bucket, minio.ListObjectsOptions{Prefix: c.prefix}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("could not list objects: %v", object.Err)
+		}
+
+		// Skip the prefixes of other instances
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+
+		objects = append(objects, &Object{
+			Key:      c.relativeKey(object.Key),
+			Size:     bytesToMB(object.Size),
+			Modified: object.LastModified,
+		})
+	}
+
+	return objects, nil
+}
+
+// Get returns a reader for the object with the given key
+func (c *Client) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return c.client.GetObject(ctx, c.bucket, c.fullKey(key), minio.GetObjectOptions{})
+}
+
+// Delete removes the object with the given key from the bucket
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.client.RemoveObject(ctx, c.bucket, c.fullKey(key), minio.RemoveObjectOptions{})
+}
+
+// fullKey returns the key of an object in the bucket
+func (c *Client) fullKey(key string) string {
+	return c.prefix + key
+}
+
+// relativeKey returns the key of an object relative to the prefix of the client
+func (c *Client) relativeKey(key string) string {
+	return strings.TrimPrefix(key, c.prefix)
+}
+
+// permanentErrors are S3 error codes that retrying won't fix
+var permanentErrors = map[string]bool{
+	"AccessDenied":          true,
+	"AccountProblem":        true,
+	"AllAccessDisabled":     true,
+	"EntityTooLarge":        true,
+	"InvalidAccessKeyId":    true,
+	"InvalidBucketName":     true,
+	"NoSuchBucket":          true,
+	"SignatureDoesNotMatch": true,
+}
+
+// IsPermanent returns true if the error is an S3 error that retrying won't fix, such as missing permissions or bucket
+func IsPermanent(err error) bool {
+	var response minio.ErrorResponse
+	if errors.As(err, &response) {
+		return permanentErrors[response.Code]
+	}
+
+	return false
+}
+
+// bytesToMB converts a size in bytes to megabytes
+func bytesToMB(size int64) float64 {
+	return float64(size) / (1024 * 1024)
 }
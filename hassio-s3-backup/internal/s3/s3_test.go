@@ -0,0 +1,71 @@
+package s3
+
+import "testing"
+
+func TestUploadOffset(t *testing.T) {
+	tests := []struct {
+		name       string
+		parts      []int
+		wantOffset int64
+		wantNumber int
+	}{
+		{name: "no parts", parts: nil, wantOffset: 0, wantNumber: 1},
+		{name: "parts in order", parts: []int{1, 2, 3}, wantOffset: 300, wantNumber: 4},
+		{name: "parts out of order", parts: []int{2, 1}, wantOffset: 200, wantNumber: 3},
+		{name: "gap left by a failed part", parts: []int{1, 2, 4}, wantOffset: 200, wantNumber: 3},
+		{name: "first part missing", parts: []int{2, 3}, wantOffset: 0, wantNumber: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := &Upload{PartSize: 100}
+			for _, number := range tt.parts {
+				upload.addPart(Part{Number: number, ETag: "etag", Size: 100})
+			}
+
+			if offset, number := upload.offset(); offset != tt.wantOffset || number != tt.wantNumber {
+				t.Errorf("upload continues at %d with part %d, want %d with part %d", offset, number, tt.wantOffset, tt.wantNumber)
+			}
+		})
+	}
+}
+
+func TestUploadAddPartReplaces(t *testing.T) {
+	upload := &Upload{PartSize: 100}
+	upload.addPart(Part{Number: 1, ETag: "first"})
+	upload.addPart(Part{Number: 1, ETag: "again"})
+
+	if len(upload.Parts) != 1 || upload.Parts[0].ETag != "again" {
+		t.Errorf("upload has parts %+v, want the part uploaded again", upload.Parts)
+	}
+}
+
+func TestUploadPartSize(t *testing.T) {
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name     string
+		partSize uint64
+		size     int64
+		want     int64
+	}{
+		{name: "default", size: 100 * mb, want: defaultPartSize},
+		{name: "configured", partSize: 5 * mb, size: 100 * mb, want: 5 * mb},
+		{name: "at the part limit", partSize: 5 * mb, size: maxParts * 5 * mb, want: 5 * mb},
+		{name: "above the part limit", partSize: 5 * mb, size: maxParts*5*mb + 1, want: 6 * mb},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{partSize: tt.partSize}
+
+			got := c.uploadPartSize(tt.size)
+			if got != tt.want {
+				t.Errorf("part size is %d, want %d", got, tt.want)
+			}
+			if (tt.size+got-1)/got > maxParts {
+				t.Errorf("part size %d takes more than %d parts", got, maxParts)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Window is a time of day range, it wraps past midnight if it ends before it starts
+type Window struct {
+	Start time.Duration // Since midnight
+	End   time.Duration // Since midnight
+}
+
+// ParseWindow parses a window such as "08:00-23:30"
+func ParseWindow(value string) (Window, error) {
+	var startHour, startMin, endHour, endMin int
+	if _, err := fmt.Sscanf(value, "%d:%d-%d:%d", &startHour, &startMin, &endHour, &endMin); err != nil {
+		return Window{}, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", value)
+	}
+
+	for _, v := range []struct{ value, max int }{{startHour, 23}, {startMin, 59}, {endHour, 23}, {endMin, 59}} {
+		if v.value < 0 || v.value > v.max {
+			return Window{}, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", value)
+		}
+	}
+
+	return Window{
+		Start: time.Duration(startHour)*time.Hour + time.Duration(startMin)*time.Minute,
+		End:   time.Duration(endHour)*time.Hour + time.Duration(endMin)*time.Minute,
+	}, nil
+}
+
+// contains returns true if the time of day is within the window. The time of day is read off the wall clock,
+// so windows keep their hours on days daylight saving time starts or ends.
+func (w Window) contains(t time.Time) bool {
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+
+	return offset >= w.Start || offset < w.End
+}
+
+// Limit is a rate limit that applies at all times, or only within its windows if there are any
+type Limit struct {
+	BytesPerSecond int64
+	Windows        []Window
+	Timezone       *time.Location
+}
+
+// Active returns true if the limit applies at the given time
+func (l Limit) Active(t time.Time) bool {
+	if l.BytesPerSecond <= 0 {
+		return false
+	}
+
+	if len(l.Windows) == 0 {
+		return true
+	}
+
+	if l.Timezone != nil {
+		t = t.In(l.Timezone)
+	}
+
+	for _, w := range l.Windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clock tells the time the rate is measured with and waits
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the clock of the system
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// reader limits the rate data is read from the underlying reader
+type reader struct {
+	ctx   context.Context
+	r     io.Reader
+	limit Limit
+	clock clock
+	start time.Time // Start of the current measurement, zero while the limit isn't active
+	bytes int64     // Bytes read since start
+}
+
+// NewReader returns a reader that reads from r no faster than the limit allows
+func NewReader(ctx context.Context, r io.Reader, limit Limit) io.Reader {
+	return newReader(ctx, r, limit, systemClock{})
+}
+
+// newReader returns a reader that reads from r no faster than the limit allows, as measured by the clock
+func newReader(ctx context.Context, r io.Reader, limit Limit, clock clock) io.Reader {
+	if limit.BytesPerSecond <= 0 {
+		return r
+	}
+
+	return &reader{ctx: ctx, r: r, limit: limit, clock: clock}
+}
+
+// Read reads at most a fraction of a second worth of data and waits until it's allowed by the limit
+func (tr *reader) Read(p []byte) (int, error) {
+	now := tr.clock.Now()
+	if !tr.limit.Active(now) {
+		tr.start = time.Time{}
+		return tr.r.Read(p)
+	}
+
+	if tr.start.IsZero() {
+		tr.start = now
+		tr.bytes = 0
+	}
+
+	// Keep reads small so the rate is even
+	if chunk := tr.limit.BytesPerSecond / 10; chunk > 0 && int64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+
+	n, err := tr.r.Read(p)
+	tr.bytes += int64(n)
+
+	allowed := time.Duration(float64(tr.bytes) / float64(tr.limit.BytesPerSecond) * float64(time.Second))
+	wait := allowed - tr.clock.Now().Sub(tr.start)
+
+	// Don't let a slow source build up credit for a burst later on
+	if wait < -time.Second {
+		tr.start = tr.clock.Now()
+		tr.bytes = 0
+		return n, err
+	}
+
+	if wait > 0 {
+		select {
+		case <-tr.clock.After(wait):
+		case <-tr.ctx.Done():
+			return n, tr.ctx.Err()
+		}
+	}
+
+	return n, err
+}
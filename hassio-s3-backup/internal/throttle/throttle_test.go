@@ -0,0 +1,238 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	window, err := ParseWindow("22:30-06:05")
+	if err != nil {
+		t.Fatalf("ParseWindow returned error: %v", err)
+	}
+	if window.Start != 22*time.Hour+30*time.Minute || window.End != 6*time.Hour+5*time.Minute {
+		t.Errorf("parsed window from %s to %s", window.Start, window.End)
+	}
+
+	for _, value := range []string{"", "22:30", "24:00-06:00", "22:60-06:00", "22:30-06:-1", "night"} {
+		if _, err := ParseWindow(value); err == nil {
+			t.Errorf("ParseWindow(%q) returned no error", value)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	day := Window{Start: 8 * time.Hour, End: 23*time.Hour + 30*time.Minute}
+	night := Window{Start: 22 * time.Hour, End: 6 * time.Hour}
+
+	tests := []struct {
+		window Window
+		time   string
+		want   bool
+	}{
+		{day, "07:59:59", false},
+		{day, "08:00:00", true},
+		{day, "23:29:59", true},
+		{day, "23:30:00", false},
+		{night, "21:59:59", false},
+		{night, "22:00:00", true},
+		{night, "00:00:00", true},
+		{night, "05:59:59", true},
+		{night, "06:00:00", false},
+		{night, "12:00:00", false},
+	}
+
+	for _, tt := range tests {
+		at, err := time.Parse("2006-01-02 15:04:05", "2024-09-25 "+tt.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tt.window.contains(at); got != tt.want {
+			t.Errorf("window from %s to %s contains %s: %t, want %t", tt.window.Start, tt.window.End, tt.time, got, tt.want)
+		}
+	}
+}
+
+func TestLimitActive(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+
+	night := []Window{{Start: time.Hour, End: 5 * time.Hour}}
+	early := []Window{{Start: 3 * time.Hour, End: 4 * time.Hour}}
+
+	tests := []struct {
+		name  string
+		limit Limit
+		time  time.Time
+		want  bool
+	}{
+		{
+			name:  "no limit",
+			limit: Limit{Windows: night},
+			time:  time.Date(2024, 9, 25, 2, 0, 0, 0, time.UTC),
+			want:  false,
+		},
+		{
+			name:  "no windows",
+			limit: Limit{BytesPerSecond: 1000},
+			time:  time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC),
+			want:  true,
+		},
+		{
+			name:  "in a window",
+			limit: Limit{BytesPerSecond: 1000, Windows: night, Timezone: time.UTC},
+			time:  time.Date(2024, 9, 25, 2, 0, 0, 0, time.UTC),
+			want:  true,
+		},
+		{
+			name:  "in a window of another time zone",
+			limit: Limit{BytesPerSecond: 1000, Windows: night, Timezone: tokyo},
+			time:  time.Date(2024, 9, 24, 17, 0, 0, 0, time.UTC), // 02:00 in Tokyo
+			want:  true,
+		},
+		{
+			name:  "outside a window of another time zone",
+			limit: Limit{BytesPerSecond: 1000, Windows: night, Timezone: tokyo},
+			time:  time.Date(2024, 9, 25, 2, 0, 0, 0, time.UTC), // 11:00 in Tokyo
+			want:  false,
+		},
+		{
+			name:  "in a window on the day daylight saving time starts",
+			limit: Limit{BytesPerSecond: 1000, Windows: early, Timezone: berlin},
+			time:  time.Date(2024, 3, 31, 3, 30, 0, 0, berlin), // 2:30 after midnight
+			want:  true,
+		},
+		{
+			name:  "in a window on the day daylight saving time ends",
+			limit: Limit{BytesPerSecond: 1000, Windows: early, Timezone: berlin},
+			time:  time.Date(2024, 10, 27, 3, 30, 0, 0, berlin), // 4:30 after midnight
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limit.Active(tt.time); got != tt.want {
+				t.Errorf("limit active at %s: %t, want %t", tt.time, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReaderRate(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC)}
+	r := newReader(context.Background(), &source{}, Limit{BytesPerSecond: 1000}, clock)
+
+	started := clock.now
+	n, err := io.CopyN(io.Discard, r, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last read is waited on for the bytes it returned
+	if elapsed := clock.now.Sub(started); elapsed < 10*time.Second-time.Millisecond || elapsed > 10*time.Second+time.Millisecond {
+		t.Errorf("read %d bytes in %s, want 10s at 1000 bytes per second", n, elapsed)
+	}
+	for _, wait := range clock.waits {
+		if wait > 100*time.Millisecond+time.Millisecond {
+			t.Errorf("waited %s at once, want the rate to be even", wait)
+		}
+	}
+}
+
+func TestReaderOutsideWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC)}
+	limit := Limit{BytesPerSecond: 1000, Windows: []Window{{Start: 22 * time.Hour, End: 6 * time.Hour}}, Timezone: time.UTC}
+	r := newReader(context.Background(), &source{}, limit, clock)
+
+	if _, err := io.CopyN(io.Discard, r, 10000); err != nil {
+		t.Fatal(err)
+	}
+	if len(clock.waits) > 0 {
+		t.Errorf("waited %v outside the windows of the limit", clock.waits)
+	}
+}
+
+func TestReaderDoesNotBurstAfterSlowSource(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC)}
+	src := &source{clock: clock, delay: 5 * time.Second}
+	r := newReader(context.Background(), src, Limit{BytesPerSecond: 1000}, clock)
+
+	// The source is slower than the limit for a while, which doesn't allow for a burst afterwards
+	buf := make([]byte, 100)
+	for i := 0; i < 5; i++ {
+		if _, err := r.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(clock.waits) > 0 {
+		t.Fatalf("waited %v for a source slower than the limit", clock.waits)
+	}
+
+	src.delay = 0
+	started := clock.now
+	if _, err := io.CopyN(io.Discard, r, 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := clock.now.Sub(started); elapsed < 4*time.Second {
+		t.Errorf("read 5000 bytes in %s once the source was fast, want about 5s at 1000 bytes per second", elapsed)
+	}
+}
+
+func TestReaderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	clock := &fakeClock{now: time.Date(2024, 9, 25, 12, 0, 0, 0, time.UTC), block: true}
+	r := newReader(ctx, &source{}, Limit{BytesPerSecond: 1000}, clock)
+
+	if _, err := r.Read(make([]byte, 100)); !errors.Is(err, context.Canceled) {
+		t.Errorf("read returned %v while waiting with a cancelled context, want %v", err, context.Canceled)
+	}
+}
+
+// fakeClock is a clock whose time moves forward only when waited on, by the time waited
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+	block bool // Waits never end
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	if c.block {
+		return ch
+	}
+
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+	ch <- c.now
+
+	return ch
+}
+
+// source returns as much data as it's asked for, taking the given delay on the clock for every read
+type source struct {
+	clock *fakeClock
+	delay time.Duration
+}
+
+func (s *source) Read(p []byte) (int, error) {
+	if s.delay > 0 {
+		s.clock.now = s.clock.now.Add(s.delay)
+	}
+
+	return len(p), nil
+}
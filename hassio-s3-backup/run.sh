@@ -6,10 +6,77 @@ bashio::log.info "Setting up configuration"
 
 # Create main config
 export LOG_LEVEL=$(bashio::config 'log_level')
-export S3_ENDPOINT=$(bashio::config 's3_endpoint')
-export S3_BUCKET_NAME=$(bashio::config 's3_bucket')
-export S3_ACCESS_KEY=$(bashio::config 's3_access_key')
-export S3_SECRET_KEY=$(bashio::config 's3_secret_key')
+
+if bashio::config.has_value 's3_endpoint'; then
+  export S3_ENDPOINT=$(bashio::config 's3_endpoint')
+  export S3_BUCKET_NAME=$(bashio::config 's3_bucket')
+  export S3_ACCESS_KEY=$(bashio::config 's3_access_key')
+  export S3_SECRET_KEY=$(bashio::config 's3_secret_key')
+fi
+
+if bashio::config.has_value 'local_path'; then
+  export LOCAL_PATH=$(bashio::config 'local_path')
+fi
+
+export DESTINATIONS=$(bashio::jq "${CONFIG_PATH}" '.destinations')
+
+if bashio::config.has_value 'encryption_passphrase'; then
+  export ENCRYPTION_PASSPHRASE=$(bashio::config 'encryption_passphrase')
+fi
+
+if bashio::config.has_value 'encryption_key_file'; then
+  export ENCRYPTION_KEY_FILE=$(bashio::config 'encryption_key_file')
+fi
+
+if bashio::config.has_value 'upload_part_size'; then
+  export UPLOAD_PART_SIZE=$(bashio::config 'upload_part_size')
+fi
+
+if bashio::config.has_value 'upload_concurrency'; then
+  export UPLOAD_CONCURRENCY=$(bashio::config 'upload_concurrency')
+fi
+
+if bashio::config.has_value 'bandwidth_limit'; then
+  export BANDWIDTH_LIMIT=$(bashio::config 'bandwidth_limit')
+fi
+
+if bashio::config.has_value 'restore_bandwidth_limit'; then
+  export RESTORE_BANDWIDTH_LIMIT=$(bashio::config 'restore_bandwidth_limit')
+fi
+
+export BANDWIDTH_LIMIT_WINDOWS=$(bashio::jq "${CONFIG_PATH}" '.bandwidth_limit_windows')
+
+if bashio::config.has_value 'retry_attempts'; then
+  export RETRY_ATTEMPTS=$(bashio::config 'retry_attempts')
+fi
+
+if bashio::config.has_value 'retry_backoff'; then
+  export RETRY_BACKOFF=$(bashio::config 'retry_backoff')
+fi
+
+if bashio::config.has_value 'verify_schedule'; then
+  export VERIFY_SCHEDULE=$(bashio::config 'verify_schedule')
+fi
+
+if bashio::config.has_value 'failure_notification'; then
+  export FAILURE_NOTIFICATION=$(bashio::config 'failure_notification')
+fi
+
+if bashio::config.has_value 'backup_events'; then
+  export BACKUP_EVENTS=$(bashio::config 'backup_events')
+fi
+
+export WEBHOOKS=$(bashio::jq "${CONFIG_PATH}" '.webhooks')
+
+if bashio::config.has_value 'metrics'; then
+  export METRICS=$(bashio::config 'metrics')
+fi
+
+if bashio::config.has_value 'key_prefix'; then
+  export KEY_PREFIX=$(bashio::config 'key_prefix')
+fi
+
+export VIEW_KEY_PREFIXES=$(bashio::jq "${CONFIG_PATH}" '.view_key_prefixes')
 
 # Start application
 bashio::log.info "Starting Home Assistant Proton Drive Backup"
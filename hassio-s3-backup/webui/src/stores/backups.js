@@ -1,10 +1,26 @@
 import { defineStore } from "pinia";
 
+// remoteSize returns the size of a backup in its remote destinations
+export function remoteSize(backup) {
+  const sizes = Object.values(backup.remotes || {}).map((r) => r.size);
+  return sizes.length > 0 ? Math.max(...sizes) : 0;
+}
+
 export const useBackupsStore = defineStore("backups", {
   state: () => ({
     backups: [],
+    instanceBackups: [],
+    jobs: [],
   }),
   getters: {
+    activeJob(state) {
+      return (backupId) =>
+        state.jobs.find(
+          (job) =>
+            job.backupId === backupId &&
+            (job.state === "QUEUED" || job.state === "RUNNING"),
+        );
+    },
     pinnedBackups(state) {
       return state.backups.filter((backup) => backup.pinned);
     },
@@ -13,12 +29,18 @@ export const useBackupsStore = defineStore("backups", {
     },
     s3Backups(state) {
       return state.backups.filter(
-        (backup) => backup.status === "S3ONLY" || backup.status === "SYNCED",
+        (backup) =>
+          backup.status === "S3ONLY" ||
+          backup.status === "SYNCED" ||
+          backup.status === "INCOMPLETE",
       );
     },
     haBackups(state) {
       return state.backups.filter(
-        (backup) => backup.status === "HAONLY" || backup.status === "SYNCED",
+        (backup) =>
+          backup.status === "HAONLY" ||
+          backup.status === "SYNCED" ||
+          backup.status === "INCOMPLETE",
       );
     },
     s3BackupsCount() {
@@ -44,7 +66,7 @@ export const useBackupsStore = defineStore("backups", {
     s3BackupsSize() {
       const totalSizeMB = this.s3Backups.reduce((acc, backup) => {
         if (!backup.pinned) {
-          return acc + backup.s3.size;
+          return acc + remoteSize(backup);
         }
         return acc;
       }, 0);
@@ -65,7 +87,7 @@ export const useBackupsStore = defineStore("backups", {
     pinnedS3BackupsSize() {
       const totalSizeMB = this.s3Backups.reduce((acc, backup) => {
         if (backup.pinned) {
-          return acc + backup.s3.size;
+          return acc + remoteSize(backup);
         }
         return acc;
       }, 0);
@@ -86,7 +108,7 @@ export const useBackupsStore = defineStore("backups", {
 
     totalS3BackupsSize() {
       const totalSizeMB = this.s3Backups.reduce(
-        (acc, backup) => acc + backup.s3.size,
+        (acc, backup) => acc + remoteSize(backup),
         0,
       );
 
@@ -181,7 +203,7 @@ export const useBackupsStore = defineStore("backups", {
         console.error(error);
       }
     },
-    async createBackup(name) {
+    async createBackup(name, profile) {
       try {
         const response = await fetch(
           "http://replaceme.homeassistant/api/backups/new/full",
@@ -192,12 +214,14 @@ export const useBackupsStore = defineStore("backups", {
             },
             body: JSON.stringify({
               name: name,
+              profile: profile,
             }),
           },
         );
 
         if (response.status === 202) {
           this.fetchBackups();
+          this.fetchJobs();
           return { success: true };
         } else {
           const errorText = await response.text();
@@ -217,8 +241,8 @@ export const useBackupsStore = defineStore("backups", {
           },
         );
 
-        if (response.status === 200) {
-          this.backups = this.backups.filter((backup) => backup.id !== id);
+        if (response.status === 202) {
+          this.fetchJobs();
           return { success: true };
         } else {
           const errorText = await response.text();
@@ -238,7 +262,8 @@ export const useBackupsStore = defineStore("backups", {
           },
         );
 
-        if (response.status === 200) {
+        if (response.status === 202) {
+          this.fetchJobs();
           return { success: true };
         } else {
           const errorText = await response.text();
@@ -249,16 +274,21 @@ export const useBackupsStore = defineStore("backups", {
         return { success: false, error: error };
       }
     },
-    async restoreBackup(id) {
+    async restoreBackup(id, password) {
       try {
         const response = await fetch(
           `http://replaceme.homeassistant/api/backups/${id}/restore`,
           {
             method: "POST",
+            headers: {
+              "Content-Type": "application/json",
+            },
+            body: JSON.stringify({ password: password || "" }),
           },
         );
 
         if (response.status === 202) {
+          this.fetchJobs();
           return { success: true };
         } else {
           const errorText = await response.text();
@@ -269,6 +299,133 @@ export const useBackupsStore = defineStore("backups", {
         return { success: false, error: error };
       }
     },
+    async fetchInstanceBackups() {
+      try {
+        const response = await fetch(
+          "http://replaceme.homeassistant/api/backups/instances",
+        );
+        if (!response.ok) {
+          throw new Error("Network response was not ok");
+        }
+
+        this.instanceBackups = await response.json();
+      } catch (error) {
+        console.error(error);
+      }
+    },
+    async verifyBackup(id) {
+      try {
+        const response = await fetch(
+          `http://replaceme.homeassistant/api/backups/${id}/verify`,
+          {
+            method: "POST",
+          },
+        );
+
+        if (response.status === 202) {
+          this.fetchJobs();
+          return { success: true };
+        } else {
+          const errorText = await response.text();
+          throw new Error(errorText);
+        }
+      } catch (error) {
+        console.error("Failed to verify backup:", error);
+        return { success: false, error: error };
+      }
+    },
+    async uploadBackup(id) {
+      try {
+        const response = await fetch(
+          `http://replaceme.homeassistant/api/backups/${id}/upload`,
+          {
+            method: "POST",
+          },
+        );
+
+        if (response.status === 202) {
+          this.fetchJobs();
+          return { success: true };
+        } else {
+          const errorText = await response.text();
+          throw new Error(errorText);
+        }
+      } catch (error) {
+        console.error("Failed to upload backup:", error);
+        return { success: false, error: error };
+      }
+    },
+    async fetchJobs() {
+      try {
+        const response = await fetch("http://replaceme.homeassistant/api/jobs");
+        if (!response.ok) {
+          throw new Error("Network response was not ok");
+        }
+
+        this.jobs = await response.json();
+      } catch (error) {
+        console.error(error);
+      }
+    },
+    // listenForEvents keeps the backups and jobs up to date from the event stream and returns it
+    listenForEvents() {
+      const events = new EventSource(
+        "http://replaceme.homeassistant/api/events",
+      );
+
+      // Catch up on what happened while the stream was disconnected
+      events.onopen = () => {
+        this.fetchBackups();
+        this.fetchJobs();
+      };
+
+      events.addEventListener("status", () => this.fetchBackups());
+      events.addEventListener("sync_finished", () => this.fetchBackups());
+
+      events.addEventListener("progress", (e) => {
+        const progress = JSON.parse(e.data).data;
+        const backup = this.backups.find((backup) => backup.id === progress.id);
+        if (backup) {
+          backup.progress = progress.progress;
+          backup.bytesTransferred = progress.bytesTransferred;
+          backup.bytesTotal = progress.bytesTotal;
+          backup.throughput = progress.throughput;
+        }
+      });
+
+      events.addEventListener("job", (e) => {
+        const job = JSON.parse(e.data).data;
+        const index = this.jobs.findIndex((j) => j.id === job.id);
+        if (index === -1) {
+          this.jobs.push(job);
+        } else {
+          this.jobs[index] = job;
+        }
+      });
+
+      return events;
+    },
+    async cancelJob(id) {
+      try {
+        const response = await fetch(
+          `http://replaceme.homeassistant/api/jobs/${id}`,
+          {
+            method: "DELETE",
+          },
+        );
+
+        if (response.status === 202) {
+          this.fetchJobs();
+          return { success: true };
+        } else {
+          const errorText = await response.text();
+          throw new Error(errorText);
+        }
+      } catch (error) {
+        console.error("Failed to cancel job:", error);
+        return { success: false, error: error };
+      }
+    },
     async pinBackup(id) {
       try {
         const response = await fetch(
@@ -322,7 +479,7 @@ export const useBackupsStore = defineStore("backups", {
           },
         );
 
-        if (response.status === 200) {
+        if (response.ok) {
           this.backup = [];
           return { success: true };
         } else {
@@ -4,7 +4,16 @@ export const useConfigStore = defineStore("config", {
   state: () => ({
     config: {},
   }),
-  getters: {},
+  getters: {
+    // backupsInS3 is the highest number of backups kept in any destination, 0 if any keeps all
+    backupsInS3(state) {
+      const keeps = (state.config.destinations || []).map((d) => d.keep);
+      if (keeps.length === 0 || keeps.includes(0)) {
+        return 0;
+      }
+      return Math.max(...keeps);
+    },
+  },
   setters: {},
   actions: {
     async fetchConfig() {
@@ -22,6 +31,26 @@ export const useConfigStore = defineStore("config", {
         console.error(error);
       }
     },
+    async previewRetention(config) {
+      try {
+        const response = await fetch(
+          "http://replaceme.homeassistant/api/backups/retention/preview",
+          {
+            method: "POST",
+            body: JSON.stringify(config),
+          },
+        );
+
+        if (!response.ok) {
+          throw new Error(await response.text());
+        }
+
+        return { success: true, deletions: await response.json() };
+      } catch (error) {
+        console.error("Failed to preview retention:", error);
+        return { success: false, error: error };
+      }
+    },
     async saveConfig(config) {
       try {
         const response = await fetch(
@@ -33,7 +62,8 @@ export const useConfigStore = defineStore("config", {
         );
 
         if (response.status === 200) {
-          this.config = config;
+          // The saved password is never returned, only whether one is set
+          await this.fetchConfig();
           return { success: true };
         } else {
           throw new Error("Failed to save configuration");